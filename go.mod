@@ -1,9 +1,34 @@
 module github.com/retailcrm/mg-transport-telegram
 
+go 1.27.1
+
+require (
+	github.com/aws/aws-sdk-go v1.15.35
+	github.com/getsentry/raven-go v0.0.0-20180903072508-084a9de9eb03
+	github.com/gin-contrib/multitemplate v0.0.0-20180827023943-5799bbbb6dce
+	github.com/gin-gonic/gin v1.3.0
+	github.com/go-telegram-bot-api/telegram-bot-api v4.6.4+incompatible
+	github.com/golang-migrate/migrate v3.4.0+incompatible
+	github.com/h2non/filetype v1.0.10
+	github.com/h2non/gock v1.0.10
+	github.com/jessevdk/go-flags v1.4.0
+	github.com/jinzhu/gorm v1.9.1
+	github.com/nicksnyder/go-i18n/v2 v2.0.0-beta.5
+	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7
+	github.com/pkg/errors v0.8.0
+	github.com/retailcrm/api-client-go v1.1.1
+	github.com/retailcrm/mg-transport-api-client-go v1.1.31
+	github.com/stretchr/testify v1.2.2
+	golang.org/x/image v0.0.0-20181116024801-cd38e8056d9b
+	golang.org/x/text v0.3.0
+	gopkg.in/go-playground/validator.v8 v8.18.2
+	gopkg.in/yaml.v2 v2.2.1
+)
+
 require (
 	cloud.google.com/go v0.27.0 // indirect
+	github.com/BurntSushi/toml v0.3.0 // indirect
 	github.com/Microsoft/go-winio v0.4.11 // indirect
-	github.com/aws/aws-sdk-go v1.15.35
 	github.com/certifi/gocertifi v0.0.0-20180905225744-ee1a9a0726d2 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/denisenkom/go-mssqldb v0.0.0-20180901172138-1eb28afdf9b6 // indirect
@@ -12,22 +37,13 @@ require (
 	github.com/docker/go-connections v0.4.0 // indirect
 	github.com/docker/go-units v0.3.3 // indirect
 	github.com/erikstmartin/go-testdb v0.0.0-20160219214506-8d10e4a1bae5 // indirect
-	github.com/getsentry/raven-go v0.0.0-20180903072508-084a9de9eb03
-	github.com/gin-contrib/multitemplate v0.0.0-20180827023943-5799bbbb6dce
 	github.com/gin-contrib/sse v0.0.0-20170109093832-22d885f9ecc7 // indirect
-	github.com/gin-gonic/gin v1.3.0
 	github.com/go-ini/ini v1.38.2 // indirect
 	github.com/go-sql-driver/mysql v1.4.0 // indirect
-	github.com/go-telegram-bot-api/telegram-bot-api v4.6.4+incompatible
-	github.com/golang-migrate/migrate v3.4.0+incompatible
 	github.com/golang/protobuf v1.2.0 // indirect
 	github.com/google/go-cmp v0.2.0 // indirect
 	github.com/google/go-querystring v0.0.0-20170111101155-53e6ce116135 // indirect
 	github.com/gopherjs/gopherjs v0.0.0-20180825215210-0210a2f0f73c // indirect
-	github.com/h2non/filetype v1.0.10
-	github.com/h2non/gock v1.0.10
-	github.com/jessevdk/go-flags v1.4.0
-	github.com/jinzhu/gorm v1.9.1
 	github.com/jinzhu/inflection v0.0.0-20180308033659-04140366298a // indirect
 	github.com/jinzhu/now v0.0.0-20180511015916-ed742868f2ae // indirect
 	github.com/jmespath/go-jmespath v0.0.0-20180206201540-c2b33e8439af // indirect
@@ -35,34 +51,26 @@ require (
 	github.com/json-iterator/go v1.1.5 // indirect
 	github.com/jtolds/gls v4.2.1+incompatible // indirect
 	github.com/kr/pretty v0.1.0 // indirect
+	github.com/kr/pty v1.1.1 // indirect
+	github.com/kr/text v0.1.0 // indirect
 	github.com/lib/pq v1.0.0 // indirect
 	github.com/mattn/go-isatty v0.0.4 // indirect
 	github.com/mattn/go-sqlite3 v1.9.0 // indirect
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/modern-go/reflect2 v1.0.1 // indirect
 	github.com/nbio/st v0.0.0-20140626010706-e9e8d9816f32 // indirect
-	github.com/nicksnyder/go-i18n/v2 v2.0.0-beta.5
-	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7
-	github.com/pkg/errors v0.8.0
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/retailcrm/api-client-go v1.1.1
-	github.com/retailcrm/mg-transport-api-client-go v1.1.31
 	github.com/smartystreets/assertions v0.0.0-20180820201707-7c9eb446e3cf // indirect
 	github.com/smartystreets/goconvey v0.0.0-20180222194500-ef6db91d284a // indirect
 	github.com/stevvooe/resumable v0.0.0-20180830230917-22b14a53ba50 // indirect
-	github.com/stretchr/testify v1.2.2
 	github.com/technoweenie/multipartstreamer v1.0.1 // indirect
 	github.com/ugorji/go v1.1.1 // indirect
 	golang.org/x/crypto v0.0.0-20180910181607-0e37d006457b // indirect
-	golang.org/x/image v0.0.0-20181116024801-cd38e8056d9b
 	golang.org/x/net v0.0.0-20180911220305-26e67e76b6c3 // indirect
 	golang.org/x/sync v0.0.0-20180314180146-1d60e4601c6f // indirect
 	golang.org/x/sys v0.0.0-20180909124046-d0be0721c37e // indirect
-	golang.org/x/text v0.3.0
 	google.golang.org/appengine v1.1.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
 	gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
-	gopkg.in/go-playground/validator.v8 v8.18.2
 	gopkg.in/ini.v1 v1.38.2 // indirect
-	gopkg.in/yaml.v2 v2.2.1
 )
@@ -0,0 +1,14 @@
+create table chat
+(
+  id          serial not null
+    constraint chat_pkey
+    primary key,
+  bot_id      integer not null,
+  external_id bigint not null,
+  blocked     boolean default false not null,
+  created_at  timestamp with time zone default current_timestamp,
+  updated_at  timestamp with time zone default current_timestamp,
+  constraint chat_key unique (bot_id, external_id)
+);
+
+alter table chat add foreign key (bot_id) references bot on delete cascade;
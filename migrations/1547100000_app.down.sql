@@ -0,0 +1,4 @@
+drop index chat_migrated_to_id_idx;
+alter table chat drop column migrated_to_id;
+
+alter table bot drop column group_policy;
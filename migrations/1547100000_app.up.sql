@@ -0,0 +1,4 @@
+alter table bot add column group_policy varchar(10) default 'ignore' not null;
+
+alter table chat add column migrated_to_id bigint;
+create index chat_migrated_to_id_idx on chat (bot_id, migrated_to_id);
@@ -0,0 +1,3 @@
+drop table payload_source;
+
+alter table chat drop column start_payload;
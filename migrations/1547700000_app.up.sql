@@ -0,0 +1,16 @@
+alter table chat add column start_payload varchar(64);
+
+create table payload_source
+(
+  id         serial not null
+    constraint payload_source_pkey
+    primary key,
+  bot_id     integer not null,
+  prefix     varchar(64) not null,
+  source     varchar(255) not null,
+  created_at timestamp with time zone default current_timestamp,
+  updated_at timestamp with time zone default current_timestamp,
+  constraint payload_source_key unique (bot_id, prefix)
+);
+
+alter table payload_source add foreign key (bot_id) references bot on delete cascade;
@@ -0,0 +1 @@
+drop table deep_link;
@@ -0,0 +1,14 @@
+create table deep_link
+(
+  id         serial not null
+    constraint deep_link_pkey
+    primary key,
+  bot_id     integer not null,
+  name       varchar(100) not null,
+  payload    varchar(64) not null,
+  created_at timestamp with time zone default current_timestamp,
+  updated_at timestamp with time zone default current_timestamp,
+  constraint deep_link_key unique (bot_id, payload)
+);
+
+alter table deep_link add foreign key (bot_id) references bot on delete cascade;
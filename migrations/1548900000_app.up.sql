@@ -0,0 +1,17 @@
+create table bot_command
+(
+  id          serial not null
+    constraint bot_command_pkey
+    primary key,
+  bot_id      integer not null,
+  name        varchar(32) not null,
+  lang        varchar(2) not null,
+  description varchar(256) not null,
+  reply       text,
+  forward     boolean default false not null,
+  created_at  timestamp with time zone default current_timestamp,
+  updated_at  timestamp with time zone default current_timestamp,
+  constraint bot_command_key unique (bot_id, name, lang)
+);
+
+alter table bot_command add foreign key (bot_id) references bot on delete cascade;
@@ -0,0 +1,10 @@
+drop table away_message;
+drop table holiday;
+drop table business_hours;
+
+alter table chat drop column away_sent_at;
+
+alter table bot
+  drop column timezone,
+  drop column away_interval,
+  drop column away_note;
@@ -0,0 +1,51 @@
+alter table bot
+  add column timezone varchar(50),
+  add column away_interval integer default 0 not null,
+  add column away_note boolean default false not null;
+
+alter table chat add column away_sent_at timestamp with time zone;
+
+create table business_hours
+(
+  id         serial not null
+    constraint business_hours_pkey
+    primary key,
+  bot_id     integer not null,
+  weekday    smallint not null,
+  opens      varchar(5) not null,
+  closes     varchar(5) not null,
+  created_at timestamp with time zone default current_timestamp,
+  updated_at timestamp with time zone default current_timestamp
+);
+
+alter table business_hours add foreign key (bot_id) references bot on delete cascade;
+
+create table holiday
+(
+  id         serial not null
+    constraint holiday_pkey
+    primary key,
+  bot_id     integer not null,
+  date       date not null,
+  name       varchar(100),
+  created_at timestamp with time zone default current_timestamp,
+  updated_at timestamp with time zone default current_timestamp,
+  constraint holiday_key unique (bot_id, date)
+);
+
+alter table holiday add foreign key (bot_id) references bot on delete cascade;
+
+create table away_message
+(
+  id         serial not null
+    constraint away_message_pkey
+    primary key,
+  bot_id     integer not null,
+  lang       varchar(2) not null,
+  text       text not null,
+  created_at timestamp with time zone default current_timestamp,
+  updated_at timestamp with time zone default current_timestamp,
+  constraint away_message_key unique (bot_id, lang)
+);
+
+alter table away_message add foreign key (bot_id) references bot on delete cascade;
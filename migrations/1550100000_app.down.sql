@@ -0,0 +1 @@
+drop table auto_reply_rule;
@@ -0,0 +1,20 @@
+create table auto_reply_rule
+(
+  id         serial not null
+    constraint auto_reply_rule_pkey
+    primary key,
+  bot_id     integer not null,
+  position   integer not null,
+  type       varchar(10) not null,
+  pattern    varchar(255) not null,
+  lang       varchar(2),
+  reply      text not null,
+  forward    boolean default false not null,
+  active     boolean default true not null,
+  hits       integer default 0 not null,
+  created_at timestamp with time zone default current_timestamp,
+  updated_at timestamp with time zone default current_timestamp
+);
+
+alter table auto_reply_rule add foreign key (bot_id) references bot on delete cascade;
+create index auto_reply_rule_position_idx on auto_reply_rule (bot_id, position);
@@ -0,0 +1,8 @@
+drop table blocked_user;
+
+drop table flood_counter;
+
+alter table bot
+  drop column flood_limit,
+  drop column flood_mute,
+  drop column blocked_messages;
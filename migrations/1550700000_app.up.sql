@@ -0,0 +1,34 @@
+alter table bot
+  add column flood_limit integer default 0 not null,
+  add column flood_mute integer default 0 not null,
+  add column blocked_messages integer default 0 not null;
+
+create table flood_counter
+(
+  id          serial not null
+    constraint flood_counter_pkey
+    primary key,
+  bot_id      integer not null,
+  user_id     integer not null,
+  window_at   timestamp with time zone,
+  count       integer default 0 not null,
+  muted_until timestamp with time zone,
+  constraint flood_counter_key unique (bot_id, user_id)
+);
+
+create table blocked_user
+(
+  id         serial not null
+    constraint blocked_user_pkey
+    primary key,
+  bot_id     integer not null,
+  user_id    integer not null,
+  comment    varchar(255),
+  hits       integer default 0 not null,
+  created_at timestamp with time zone default current_timestamp,
+  updated_at timestamp with time zone default current_timestamp,
+  constraint blocked_user_key unique (bot_id, user_id)
+);
+
+alter table blocked_user add foreign key (bot_id) references bot on delete cascade;
+alter table flood_counter add foreign key (bot_id) references bot on delete cascade;
@@ -0,0 +1,4 @@
+drop table campaign_recipient;
+drop table campaign;
+
+alter table chat drop column opted_out;
@@ -0,0 +1,39 @@
+alter table chat add column opted_out boolean default false not null;
+
+create table campaign
+(
+  id          serial not null
+    constraint campaign_pkey
+    primary key,
+  bot_id      integer not null,
+  name        varchar(100) not null,
+  text        text,
+  photo_url   varchar(255),
+  buttons     text,
+  status      varchar(10) default 'draft' not null,
+  started_at  timestamp with time zone,
+  finished_at timestamp with time zone,
+  created_at  timestamp with time zone default current_timestamp,
+  updated_at  timestamp with time zone default current_timestamp
+);
+
+alter table campaign add foreign key (bot_id) references bot on delete cascade;
+create index campaign_status_idx on campaign (status);
+
+create table campaign_recipient
+(
+  id          serial not null
+    constraint campaign_recipient_pkey
+    primary key,
+  campaign_id integer not null,
+  external_id bigint not null,
+  status      varchar(10) default 'pending' not null,
+  error       varchar(255),
+  sent_at     timestamp with time zone,
+  created_at  timestamp with time zone default current_timestamp,
+  updated_at  timestamp with time zone default current_timestamp,
+  constraint campaign_recipient_key unique (campaign_id, external_id)
+);
+
+alter table campaign_recipient add foreign key (campaign_id) references campaign on delete cascade;
+create index campaign_recipient_status_idx on campaign_recipient (campaign_id, status);
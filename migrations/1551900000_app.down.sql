@@ -0,0 +1,2 @@
+drop index connection_notify_token_idx;
+alter table connection drop column notify_token;
@@ -0,0 +1,2 @@
+alter table connection add column notify_token varchar(100);
+create unique index connection_notify_token_idx on connection (notify_token);
@@ -0,0 +1,9 @@
+drop index chat_customer_ext_id_idx;
+
+alter table chat
+  drop column customer_id,
+  drop column customer_ext_id,
+  drop column first_name,
+  drop column last_name,
+  drop column phone,
+  drop column last_inbound_at;
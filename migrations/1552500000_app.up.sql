@@ -0,0 +1,9 @@
+alter table chat
+  add column customer_id integer,
+  add column customer_ext_id varchar(255),
+  add column first_name varchar(255),
+  add column last_name varchar(255),
+  add column phone varchar(50),
+  add column last_inbound_at timestamp with time zone;
+
+create index chat_customer_ext_id_idx on chat (bot_id, customer_ext_id);
@@ -0,0 +1,2 @@
+alter table bot drop column request_phone;
+alter table chat drop column phone_asked_at;
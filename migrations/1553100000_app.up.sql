@@ -0,0 +1,2 @@
+alter table bot add column request_phone boolean default false not null;
+alter table chat add column phone_asked_at timestamp with time zone;
@@ -0,0 +1,4 @@
+alter table bot
+  drop column order_button,
+  drop column order_status,
+  drop column order_method;
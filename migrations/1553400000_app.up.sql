@@ -0,0 +1,4 @@
+alter table bot
+  add column order_button boolean default false not null,
+  add column order_status varchar(255),
+  add column order_method varchar(255);
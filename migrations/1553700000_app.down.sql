@@ -0,0 +1,4 @@
+alter table bot
+  drop column payment_token,
+  drop column payment_type,
+  drop column payment_status;
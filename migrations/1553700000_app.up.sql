@@ -0,0 +1,4 @@
+alter table bot
+  add column payment_token varchar(255),
+  add column payment_type varchar(255),
+  add column payment_status varchar(255);
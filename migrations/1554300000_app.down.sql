@@ -0,0 +1,4 @@
+drop table rating;
+
+alter table bot drop column csat;
+alter table chat drop column rating_id;
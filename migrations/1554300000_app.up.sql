@@ -0,0 +1,20 @@
+alter table bot add column csat boolean default false not null;
+alter table chat add column rating_id integer;
+
+create table rating
+(
+  id          serial not null
+    constraint rating_pkey
+    primary key,
+  bot_id      integer not null,
+  external_id bigint not null,
+  message_id  integer default 0 not null,
+  score       integer not null,
+  comment     text,
+  created_at  timestamp with time zone default current_timestamp,
+  updated_at  timestamp with time zone default current_timestamp
+);
+
+alter table rating add foreign key (bot_id) references bot on delete cascade;
+
+create unique index rating_message_idx on rating (bot_id, external_id, message_id) where message_id <> 0;
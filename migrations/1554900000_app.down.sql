@@ -0,0 +1,6 @@
+alter table bot
+  drop column operator_joined,
+  drop column operator_joined_text,
+  drop column chat_actions;
+
+alter table chat drop column operator;
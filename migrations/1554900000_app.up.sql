@@ -0,0 +1,6 @@
+alter table bot
+  add column operator_joined boolean default false not null,
+  add column operator_joined_text varchar(255),
+  add column chat_actions boolean default false not null;
+
+alter table chat add column operator varchar(255);
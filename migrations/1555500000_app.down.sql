@@ -0,0 +1,3 @@
+alter table bot
+  drop column signature_position,
+  drop column signature_format;
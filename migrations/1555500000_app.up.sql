@@ -0,0 +1,3 @@
+alter table bot
+  add column signature_position varchar(10),
+  add column signature_format varchar(255);
@@ -0,0 +1,11 @@
+alter table bot
+  drop column staff_chat_id,
+  drop column unanswered_minutes,
+  drop column staff_since;
+
+alter table chat
+  drop column name,
+  drop column last_message,
+  drop column last_reply_at,
+  drop column alert_sent_at,
+  drop column dialog_closed;
@@ -0,0 +1,11 @@
+alter table bot
+  add column staff_chat_id bigint default 0 not null,
+  add column unanswered_minutes integer default 0 not null,
+  add column staff_since timestamp with time zone;
+
+alter table chat
+  add column name varchar(255),
+  add column last_message varchar(255),
+  add column last_reply_at timestamp with time zone,
+  add column alert_sent_at timestamp with time zone,
+  add column dialog_closed boolean default false not null;
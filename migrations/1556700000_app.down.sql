@@ -0,0 +1,9 @@
+drop table deferred_message;
+
+alter table bot
+  drop column quiet_from,
+  drop column quiet_to,
+  drop column quiet_defer;
+
+alter table chat
+  drop column alert_locked_at;
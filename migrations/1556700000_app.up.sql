@@ -0,0 +1,25 @@
+alter table bot
+  add column quiet_from varchar(5),
+  add column quiet_to varchar(5),
+  add column quiet_defer boolean default false not null;
+
+alter table chat
+  add column alert_locked_at timestamp with time zone;
+
+create table deferred_message
+(
+  id          serial not null
+    constraint deferred_message_pkey
+    primary key,
+  bot_id      integer not null,
+  external_id bigint not null,
+  kind        varchar(10) not null,
+  text        text,
+  send_at     timestamp with time zone not null,
+  created_at  timestamp with time zone default current_timestamp,
+  updated_at  timestamp with time zone default current_timestamp
+);
+
+alter table deferred_message add foreign key (bot_id) references bot on delete cascade;
+
+create index deferred_message_send_at_idx on deferred_message (send_at);
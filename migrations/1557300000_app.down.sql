@@ -0,0 +1,3 @@
+alter table bot
+  drop column edit_fallback,
+  drop column delete_fallback;
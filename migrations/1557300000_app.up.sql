@@ -0,0 +1,3 @@
+alter table bot
+  add column edit_fallback varchar(10),
+  add column delete_fallback varchar(10);
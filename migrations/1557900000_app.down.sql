@@ -0,0 +1 @@
+alter table chat drop column lang;
@@ -0,0 +1 @@
+alter table chat add column lang varchar(2);
@@ -0,0 +1 @@
+drop table album_message;
@@ -0,0 +1,15 @@
+create table album_message
+(
+  id               serial not null
+    constraint album_message_pkey
+    primary key,
+  bot_id           integer not null,
+  external_id      bigint not null,
+  message_id       integer not null,
+  album_message_id integer not null,
+  created_at       timestamp with time zone default current_timestamp
+);
+
+alter table album_message add foreign key (bot_id) references bot on delete cascade;
+
+create unique index album_message_idx on album_message (bot_id, external_id, message_id);
@@ -0,0 +1,2 @@
+alter table bot
+  drop column inline_search;
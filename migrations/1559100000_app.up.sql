@@ -0,0 +1,2 @@
+alter table bot
+  add column inline_search boolean default false not null;
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+func init() {
+	parser.AddCommand("block",
+		"Manage the block list of the bot",
+		"Block, unblock or list the Telegram users whose messages are not forwarded to MG.",
+		&BlockCommand{},
+	)
+}
+
+// BlockCommand struct
+type BlockCommand struct {
+	Token   string `short:"t" long:"token" required:"true" description:"Bot token."`
+	User    int    `short:"u" long:"user" description:"Telegram user ID."`
+	Comment string `long:"comment" default:"" description:"Reason of blocking."`
+	Remove  bool   `short:"r" long:"remove" description:"Remove the user from the block list."`
+	List    bool   `short:"l" long:"list" description:"Print the block list."`
+}
+
+// Execute method
+func (x *BlockCommand) Execute(args []string) error {
+	config = LoadConfig(options.Config)
+	orm = NewDb(config)
+	defer orm.DB.Close()
+
+	b, err := getBotByToken(x.Token)
+	if err != nil {
+		return err
+	}
+
+	if b.ID == 0 {
+		return errors.New("bot not found")
+	}
+
+	if x.List {
+		for _, v := range b.getBlockedUsers() {
+			fmt.Printf("%d\t%d\t%s\n", v.UserID, v.Hits, v.Comment)
+		}
+
+		return nil
+	}
+
+	if x.User <= 0 {
+		return errors.New("the user ID is required")
+	}
+
+	if x.Remove {
+		fmt.Printf("Unblocking user %d for bot @%s\n", x.User, b.Name)
+		return b.unblockUser(x.User)
+	}
+
+	fmt.Printf("Blocking user %d for bot @%s\n", x.User, b.Name)
+
+	return b.blockUser(BlockedUser{UserID: x.User, Comment: x.Comment})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	// BroadcastBatchSize is the number of recipients loaded at once
+	BroadcastBatchSize = 100
+	// BroadcastDelay is the pause between messages, Telegram allows about 30 messages per second
+	BroadcastDelay = 50 * time.Millisecond
+	// BroadcastIdle is the pause between the checks for running campaigns
+	BroadcastIdle = 10 * time.Second
+	// BroadcastLease is the time the recipients claimed by the instance are not sent by the others
+	BroadcastLease = 10 * time.Minute
+	// MaxCaptionLength is the Telegram limit for the photo caption
+	MaxCaptionLength = 1024
+)
+
+var errCampaignButtons = errors.New("campaign buttons must be lines of the text and the link separated by |")
+
+// getCampaignButtons parses the lines "Text | https://link" into the inline keyboard
+func getCampaignButtons(buttons string) ([][]tgbotapi.InlineKeyboardButton, error) {
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	for _, line := range strings.Split(buttons, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(line, "|", 2)
+		if len(parts) != 2 {
+			return nil, errCampaignButtons
+		}
+
+		text, link := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if u, err := url.Parse(link); text == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "tg") {
+			return nil, errCampaignButtons
+		}
+
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonURL(text, link)))
+	}
+
+	return rows, nil
+}
+
+// isValid reports whether the campaign can be sent
+func (c *Campaign) isValid() bool {
+	if c.Text == "" && c.PhotoURL == "" {
+		return false
+	}
+
+	if c.PhotoURL != "" && len([]rune(c.Text)) > MaxCaptionLength {
+		return false
+	}
+
+	_, err := getCampaignButtons(c.Buttons)
+
+	return err == nil
+}
+
+// Processed returns the number of recipients the campaign was sent to or skipped
+func (c Campaign) Processed() int {
+	return c.Stats["total"] - c.Stats[RecipientStatusPending]
+}
+
+// getCampaignMessage returns the campaign message with the note how to unsubscribe
+func getCampaignMessage(c *Campaign, cid int64, stopNote string) tgbotapi.Chattable {
+	text := c.Text
+	if stopNote != "" {
+		text = strings.TrimSpace(text + "\n\n" + stopNote)
+	}
+
+	var markup interface{}
+	if rows, _ := getCampaignButtons(c.Buttons); len(rows) > 0 {
+		markup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	}
+
+	if c.PhotoURL != "" {
+		if len([]rune(text)) > MaxCaptionLength {
+			text = c.Text
+		}
+
+		msg := tgbotapi.NewPhotoShare(cid, c.PhotoURL)
+		msg.Caption = text
+		msg.ReplyMarkup = markup
+
+		return msg
+	}
+
+	msg := tgbotapi.NewMessage(cid, text)
+	msg.ReplyMarkup = markup
+
+	return msg
+}
+
+// broadcastWorker sends the running campaigns in turns, a batch of each campaign per round
+func broadcastWorker() {
+	for {
+		sent := false
+		campaigns := getRunningCampaigns()
+		for i := range campaigns {
+			n, err := sendCampaignBatch(&campaigns[i])
+			if err != nil {
+				logger.Errorf("broadcastWorker campaign: %d, err: %s", campaigns[i].ID, err.Error())
+			}
+
+			sent = sent || n > 0
+		}
+
+		if !sent {
+			time.Sleep(BroadcastIdle)
+		}
+	}
+}
+
+// sendCampaignBatch sends the next batch of the campaign recipients and returns the number of the processed ones,
+// the campaign is finished when no recipients are left
+func sendCampaignBatch(c *Campaign) (int, error) {
+	b := getBotByID(c.BotID)
+	if b.ID == 0 {
+		return 0, c.setStatus(CampaignStatusPaused)
+	}
+
+	recipients := c.claimPendingRecipients(BroadcastBatchSize, time.Now(), BroadcastLease)
+	if len(recipients) == 0 {
+		if c.hasUnsentRecipients() {
+			return 0, nil
+		}
+
+		return 0, c.setStatus(CampaignStatusFinished)
+	}
+
+	bot, err := tgbotapi.NewBotAPI(b.Token)
+	if err != nil {
+		return 0, err
+	}
+
+	bot.Debug = config.Debug
+
+	for i := range recipients {
+		if err := sendCampaignMessage(bot, b, c, &recipients[i]); err != nil {
+			return i, err
+		}
+
+		time.Sleep(BroadcastDelay)
+	}
+
+	return len(recipients), nil
+}
+
+func sendCampaignMessage(bot *tgbotapi.BotAPI, b *Bot, c *Campaign, r *CampaignRecipient) error {
+	chat := getChat(b.ID, r.ExternalID)
+	if chat.OptedOut || chat.Blocked {
+		return r.setStatus(RecipientStatusSkipped, "")
+	}
+
+	lang := b.Lang
+	if chat.Lang != "" {
+		lang = chat.Lang
+	}
+
+	stopNote := localize(newLocalizer(lang), "broadcast_stop_note", nil)
+
+	_, err := bot.Send(getCampaignMessage(c, r.ExternalID, stopNote))
+	if e, ok := err.(tgbotapi.Error); ok && e.RetryAfter > 0 {
+		time.Sleep(time.Duration(e.RetryAfter) * time.Second)
+		_, err = bot.Send(getCampaignMessage(c, r.ExternalID, stopNote))
+	}
+
+	switch {
+	case err == nil:
+		return r.setStatus(RecipientStatusSent, "")
+	case isChatUnreachableError(err):
+		if e := setChatBlocked(b.ID, r.ExternalID, true); e != nil {
+			logger.Error(b.ID, r.ExternalID, e)
+		}
+
+		return r.setStatus(RecipientStatusBlocked, err.Error())
+	default:
+		return r.setStatus(RecipientStatusFailed, err.Error())
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBroadcast_getCampaignButtons(t *testing.T) {
+	rows, err := getCampaignButtons("Shop | https://example.com/shop\n\nChannel|tg://resolve?domain=example")
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "Shop", rows[0][0].Text)
+	assert.Equal(t, "https://example.com/shop", *rows[0][0].URL)
+
+	_, err = getCampaignButtons("Shop https://example.com")
+	assert.Equal(t, errCampaignButtons, err)
+
+	_, err = getCampaignButtons("Shop | javascript:alert(1)")
+	assert.Equal(t, errCampaignButtons, err)
+}
+
+func TestBroadcast_isValid(t *testing.T) {
+	assert.True(t, (&Campaign{Text: "Sale"}).isValid())
+	assert.True(t, (&Campaign{PhotoURL: "https://example.com/sale.png"}).isValid())
+	assert.False(t, (&Campaign{}).isValid())
+	assert.False(t, (&Campaign{Text: strings.Repeat("a", 1025), PhotoURL: "https://example.com/sale.png"}).isValid())
+	assert.False(t, (&Campaign{Text: "Sale", Buttons: "Shop"}).isValid())
+}
+
+func TestBroadcast_getCampaignMessage(t *testing.T) {
+	msg, ok := getCampaignMessage(&Campaign{Text: "Sale", Buttons: "Shop | https://example.com"}, 1, "Send /stop").(tgbotapi.MessageConfig)
+	require.True(t, ok)
+	assert.Equal(t, "Sale\n\nSend /stop", msg.Text)
+	assert.IsType(t, tgbotapi.InlineKeyboardMarkup{}, msg.ReplyMarkup)
+
+	photo, ok := getCampaignMessage(&Campaign{PhotoURL: "https://example.com/sale.png"}, 1, "").(tgbotapi.PhotoConfig)
+	require.True(t, ok)
+	assert.Equal(t, "https://example.com/sale.png", photo.FileID)
+	assert.Nil(t, photo.ReplyMarkup)
+}
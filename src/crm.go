@@ -0,0 +1,452 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/retailcrm/api-client-go/errs"
+	"github.com/retailcrm/api-client-go/v5"
+	"github.com/retailcrm/mg-transport-api-client-go/v1"
+)
+
+func newCRMClient(conn *Connection) *v5.Client {
+	client := v5.New(conn.APIURL, conn.APIKEY)
+	client.Debug = config.Debug
+
+	return client
+}
+
+func getCRMError(status int, e errs.Failure) error {
+	if e.RuntimeErr != nil {
+		return e.RuntimeErr
+	}
+
+	if status >= http.StatusBadRequest {
+		return fmt.Errorf("status: %d, error: %s", status, e.ApiErr)
+	}
+
+	return nil
+}
+
+// CredentialsCacheTTL is how long the credentials of the API key are reused by the features checking them on the fly
+const CredentialsCacheTTL = 10 * time.Minute
+
+type credentialsCacheEntry struct {
+	credentials []string
+	expiresAt   time.Time
+}
+
+var credentialsCache = struct {
+	sync.Mutex
+	entries map[int]credentialsCacheEntry
+}{entries: map[int]credentialsCacheEntry{}}
+
+// hasCRMCredentials reports whether the API key of the connection has the required credentials, the credentials are
+// cached for CredentialsCacheTTL, the failure is cached as no credentials
+func hasCRMCredentials(conn *Connection, required []string) bool {
+	now := time.Now()
+
+	credentialsCache.Lock()
+	entry, ok := credentialsCache.entries[conn.ID]
+	credentialsCache.Unlock()
+
+	if !ok || now.After(entry.expiresAt) {
+		res, status, e := newCRMClient(conn).APICredentials()
+		entry = credentialsCacheEntry{credentials: res.Credentials, expiresAt: now.Add(CredentialsCacheTTL)}
+		if err := getCRMError(status, e); err != nil {
+			logger.Errorf("APICredentials apiURL: %s, err: %s", conn.APIURL, err.Error())
+			entry.credentials = nil
+		}
+
+		credentialsCache.Lock()
+		credentialsCache.entries[conn.ID] = entry
+		credentialsCache.Unlock()
+	}
+
+	return len(checkCredentials(entry.credentials, required)) == 0
+}
+
+var (
+	errCustomerNotLinked = errors.New("the chat is not linked to a CRM customer")
+	errOrdersDisabled    = errors.New("the order button is turned off for the bot")
+)
+
+// customerExternalIDPrefix prefixes the Telegram user ID in the CRM customer external ID
+const customerExternalIDPrefix = "telegram_"
+
+// getCustomerExternalID returns the CRM customer external ID for the Telegram user
+func getCustomerExternalID(userID int) string {
+	return fmt.Sprintf("%s%d", customerExternalIDPrefix, userID)
+}
+
+// getPayloadSource returns the customer source for the deep-link payload using the longest matching prefix
+func getPayloadSource(sources []PayloadSource, payload string) *v5.Source {
+	var match *PayloadSource
+
+	for i, v := range sources {
+		if strings.HasPrefix(payload, v.Prefix) && (match == nil || len(v.Prefix) > len(match.Prefix)) {
+			match = &sources[i]
+		}
+	}
+
+	if match == nil {
+		return nil
+	}
+
+	return &v5.Source{
+		Source:   match.Source,
+		Campaign: strings.Trim(strings.TrimPrefix(payload, match.Prefix), "_-"),
+	}
+}
+
+// setCustomerSource stores the source on the CRM customer, creating the customer if there is none yet
+func setCustomerSource(conn *Connection, user *tgbotapi.User, source *v5.Source) error {
+	client := newCRMClient(conn)
+	customer := v5.Customer{
+		ExternalID: getCustomerExternalID(user.ID),
+		Source:     source,
+	}
+
+	_, status, e := client.CustomerEdit(customer, "externalId")
+	if status != http.StatusNotFound {
+		return getCRMError(status, e)
+	}
+
+	customer.FirstName = user.FirstName
+	customer.LastName = user.LastName
+
+	_, status, e = client.CustomerCreate(customer)
+
+	return getCRMError(status, e)
+}
+
+// normalizePhone keeps the digits of the phone, the CRM and Telegram write the numbers differently
+func normalizePhone(phone string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+
+		return -1
+	}, phone)
+}
+
+// hasPhone reports whether the customer has exactly the phone
+func hasPhone(customer *v5.Customer, phone string) bool {
+	phone = normalizePhone(phone)
+	if phone == "" {
+		return false
+	}
+
+	for _, p := range customer.Phones {
+		if normalizePhone(p.Number) == phone {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findCRMCustomer returns the CRM customer with exactly the phone, the CRM search also matches the names and the parts
+// of the phones. The customer without external ID is given the Telegram one unless another customer already has it,
+// e.g. the one created on the first message, then the chat is linked by the CRM ID
+func findCRMCustomer(client *v5.Client, user *tgbotapi.User, phone string) (*v5.Customer, error) {
+	data, status, e := client.Customers(v5.CustomersRequest{
+		Filter: v5.CustomersFilter{Name: phone},
+		Limit:  20,
+	})
+	if err := getCRMError(status, e); err != nil {
+		return nil, err
+	}
+
+	var customer *v5.Customer
+	for i := range data.Customers {
+		if hasPhone(&data.Customers[i], phone) {
+			customer = &data.Customers[i]
+			break
+		}
+	}
+
+	if customer == nil {
+		return nil, nil
+	}
+
+	if customer.ExternalID != "" {
+		return customer, nil
+	}
+
+	externalID := getCustomerExternalID(user.ID)
+
+	_, status, e = client.Customer(externalID, "externalId", "")
+	if status != http.StatusNotFound {
+		return customer, getCRMError(status, e)
+	}
+
+	_, status, e = client.CustomerEdit(v5.Customer{ID: customer.ID, ExternalID: externalID}, "id")
+	if err := getCRMError(status, e); err != nil {
+		return nil, err
+	}
+
+	customer.ExternalID = externalID
+
+	return customer, nil
+}
+
+// getCRMCustomer looks up the CRM customer of the Telegram user by the phone and by the external ID, creating the customer if there is none yet
+func getCRMCustomer(conn *Connection, user *tgbotapi.User, phone string) (*v5.Customer, error) {
+	client := newCRMClient(conn)
+
+	if phone != "" {
+		customer, err := findCRMCustomer(client, user, phone)
+		if err != nil || customer != nil {
+			return customer, err
+		}
+	}
+
+	externalID := getCustomerExternalID(user.ID)
+
+	data, status, e := client.Customer(externalID, "externalId", "")
+	if status != http.StatusNotFound {
+		if err := getCRMError(status, e); err != nil {
+			return nil, err
+		}
+
+		customer := data.Customer
+		if customer == nil {
+			return nil, errors.New("empty customer in the CRM response")
+		}
+
+		if phone != "" && len(customer.Phones) == 0 {
+			customer.Phones = []v5.Phone{{Number: phone}}
+
+			_, status, e := client.CustomerEdit(v5.Customer{ExternalID: externalID, Phones: customer.Phones}, "externalId")
+			if err := getCRMError(status, e); err != nil {
+				return nil, err
+			}
+		}
+
+		return customer, nil
+	}
+
+	customer := v5.Customer{
+		ExternalID: externalID,
+		FirstName:  user.FirstName,
+		LastName:   user.LastName,
+	}
+
+	if phone != "" {
+		customer.Phones = []v5.Phone{{Number: phone}}
+	}
+
+	res, status, e := client.CustomerCreate(customer)
+	if err := getCRMError(status, e); err != nil {
+		return nil, err
+	}
+
+	customer.ID = res.ID
+
+	return &customer, nil
+}
+
+// setLinkedCustomer stores the CRM customer on the chat
+func (c *Chat) setLinkedCustomer(customer *v5.Customer, phone string) {
+	c.CustomerID = customer.ID
+	c.CustomerExtID = customer.ExternalID
+	c.FirstName = customer.FirstName
+	c.LastName = customer.LastName
+	c.Phone = phone
+
+	if c.Phone == "" && len(customer.Phones) > 0 {
+		c.Phone = customer.Phones[0].Number
+	}
+}
+
+// setMGCustomer fills the MG customer with the chosen language and the name and the phone of the linked CRM customer,
+// the external ID stays the Telegram one MG knows the dialog by
+func (c *Chat) setMGCustomer(customer *v1.Customer) {
+	if c.Lang != "" {
+		customer.Language = c.Lang
+	}
+
+	if c.CustomerID == 0 {
+		return
+	}
+
+	customer.Phone = c.Phone
+
+	if c.FirstName != "" || c.LastName != "" {
+		customer.Firstname = c.FirstName
+		customer.Lastname = c.LastName
+	}
+}
+
+// getCRMProduct returns the catalog product with the ID
+func getCRMProduct(conn *Connection, id int) (*v5.Product, error) {
+	client := newCRMClient(conn)
+
+	data, status, e := client.Products(v5.ProductsRequest{Filter: v5.ProductsFilter{Ids: []int{id}}, Limit: 20})
+	if err := getCRMError(status, e); err != nil {
+		return nil, err
+	}
+
+	if len(data.Products) == 0 {
+		return nil, errors.New("product not found")
+	}
+
+	return &data.Products[0], nil
+}
+
+// crmCatalog returns the offers of the products from the CRM catalog of the connection
+type crmCatalog struct {
+	conn *Connection
+}
+
+// GetOffers returns the offers of the CRM product, the MG product ID is the CRM product ID
+func (c crmCatalog) GetOffers(productID int) ([]v5.Offer, error) {
+	product, err := getCRMProduct(c.conn, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	return product.Offers, nil
+}
+
+// createCRMOrder creates the draft order of the offer for the CRM customer with the order status and method of the bot
+// and returns the order ID
+func createCRMOrder(conn *Connection, b *Bot, customerID, offerID int) (int, error) {
+	client := newCRMClient(conn)
+	order := v5.Order{
+		Status:      b.OrderStatus,
+		OrderMethod: b.OrderMethod,
+		Customer:    &v5.Customer{ID: customerID},
+		Items:       []v5.OrderItem{{Offer: v5.Offer{ID: offerID}, Quantity: 1}},
+	}
+
+	res, status, e := client.OrderCreate(order)
+	if err := getCRMError(status, e); err != nil {
+		return 0, err
+	}
+
+	return res.ID, nil
+}
+
+// getCRMOrderURL returns the link to the order in the CRM
+func getCRMOrderURL(conn *Connection, id int) string {
+	return fmt.Sprintf("%s/orders/%d/edit", strings.TrimRight(conn.APIURL, "/"), id)
+}
+
+// crmOrder is the part of the CRM order the invoice is checked against, the currency is not known to the v5 client types
+type crmOrder struct {
+	ID         int     `json:"id"`
+	TotalSumm  float32 `json:"totalSumm"`
+	PrepaySum  float32 `json:"prepaySum"`
+	FullPaidAt string  `json:"fullPaidAt"`
+	Currency   string  `json:"currency"`
+}
+
+// getCRMOrder returns the CRM order with the number
+func getCRMOrder(conn *Connection, number string) (*crmOrder, error) {
+	client := newCRMClient(conn)
+	params := url.Values{"filter[numbers][]": {number}, "limit": {"20"}}
+
+	data, status, e := client.GetRequest("/orders?" + params.Encode())
+	if err := getCRMError(status, e); err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		Orders []crmOrder `json:"orders"`
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+
+	if len(res.Orders) == 0 {
+		return nil, errors.New("order not found")
+	}
+
+	return &res.Orders[0], nil
+}
+
+// crmOrders returns the orders from the CRM of the connection
+type crmOrders struct {
+	conn *Connection
+}
+
+// GetOrder returns the CRM order with the number
+func (c crmOrders) GetOrder(number string) (*crmOrder, error) {
+	return getCRMOrder(c.conn, number)
+}
+
+// getCRMOrderID returns the ID of the CRM order with the number
+func getCRMOrderID(conn *Connection, number string) (int, error) {
+	order, err := getCRMOrder(conn, number)
+	if err != nil {
+		return 0, err
+	}
+
+	return order.ID, nil
+}
+
+// createCRMPayment records the Telegram payment against the CRM order
+func createCRMPayment(conn *Connection, b *Bot, orderID int, payment *tgbotapi.SuccessfulPayment) error {
+	client := newCRMClient(conn)
+
+	_, status, e := client.OrderPaymentCreate(v5.Payment{
+		ExternalID: payment.TelegramPaymentChargeID,
+		Amount:     getMajorUnits(payment.TotalAmount, payment.Currency),
+		Type:       b.PaymentType,
+		Status:     b.PaymentStatus,
+		PaidAt:     time.Now().Format("2006-01-02 15:04:05"),
+		Comment:    payment.ProviderPaymentChargeID,
+		Order:      &v5.Order{ID: orderID},
+	})
+
+	return getCRMError(status, e)
+}
+
+// searchCRMProducts returns the active catalog products with the name, the popular ones are returned for the empty query
+func searchCRMProducts(conn *Connection, query string) ([]v5.Product, error) {
+	client := newCRMClient(conn)
+	filter := v5.ProductsFilter{Name: query, Active: 1}
+	if query == "" {
+		filter.Popular = 1
+	}
+
+	data, status, e := client.Products(v5.ProductsRequest{Filter: filter, Limit: 20})
+	if err := getCRMError(status, e); err != nil {
+		return nil, err
+	}
+
+	return data.Products, nil
+}
+
+// getCRMCurrency returns the base currency of the CRM, the catalog prices are in it. The v5 client has no settings method
+func getCRMCurrency(conn *Connection) (string, error) {
+	client := newCRMClient(conn)
+
+	data, status, e := client.GetRequest("/settings")
+	if err := getCRMError(status, e); err != nil {
+		return "", err
+	}
+
+	var res struct {
+		Settings struct {
+			DefaultCurrency struct {
+				Value string `json:"value"`
+			} `json:"default_currency"`
+		} `json:"settings"`
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(res.Settings.DefaultCurrency.Value), nil
+}
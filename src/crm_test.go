@@ -0,0 +1,282 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/h2non/gock"
+	"github.com/retailcrm/api-client-go/v5"
+	"github.com/retailcrm/mg-transport-api-client-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCRM_getPayloadSource(t *testing.T) {
+	sources := []PayloadSource{
+		{Prefix: "fb", Source: "facebook"},
+		{Prefix: "fb_ads", Source: "facebook-ads"},
+		{Prefix: "vk", Source: "vkontakte"},
+	}
+
+	source := getPayloadSource(sources, "fb_ads_summer")
+	require.NotNil(t, source)
+	assert.Equal(t, "facebook-ads", source.Source)
+	assert.Equal(t, "summer", source.Campaign)
+
+	source = getPayloadSource(sources, "fb-winter")
+	require.NotNil(t, source)
+	assert.Equal(t, "facebook", source.Source)
+	assert.Equal(t, "winter", source.Campaign)
+
+	assert.Nil(t, getPayloadSource(sources, "google"))
+}
+
+func TestCRM_checkCredentials(t *testing.T) {
+	assert.Empty(t, checkCredentials(append(credentialsTransport, credentialsCustomers...), credentialsCustomers))
+	assert.Equal(
+		t,
+		[]string{"/api/customers/{externalId}/edit", "/api/customers/create"},
+		checkCredentials(append(credentialsTransport, "/api/customers", "/api/customers/{externalId}"), credentialsCustomers),
+	)
+}
+
+func TestCRM_getCRMCustomerByPhone(t *testing.T) {
+	defer gock.Off()
+
+	conn := &Connection{APIURL: "https://test.retailcrm.ru", APIKEY: "key"}
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/v5/customers").
+		MatchParam("filter[name]", "\\+79990000000").
+		Reply(200).
+		BodyString(`{"success":true,"customers":[{"id":10,"firstName":"Ivan","lastName":"Petrov","phones":[{"number":"+79990000000"}]}]}`)
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/v5/customers/telegram_1").
+		Reply(404).
+		BodyString(`{"success":false,"errorMsg":"Not found"}`)
+
+	gock.New("https://test.retailcrm.ru").
+		Post("/api/v5/customers/10/edit").
+		Reply(200).
+		BodyString(`{"success":true,"id":10}`)
+
+	customer, err := getCRMCustomer(conn, &tgbotapi.User{ID: 1, FirstName: "John"}, "+79990000000")
+	require.NoError(t, err)
+	assert.Equal(t, 10, customer.ID)
+	assert.Equal(t, "telegram_1", customer.ExternalID)
+	assert.Equal(t, "Ivan", customer.FirstName)
+	assert.True(t, gock.IsDone())
+}
+
+func TestCRM_getCRMCustomerByPhoneAfterChat(t *testing.T) {
+	defer gock.Off()
+
+	conn := &Connection{APIURL: "https://test.retailcrm.ru", APIKEY: "key"}
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/v5/customers").
+		MatchParam("filter[name]", "\\+79990000000").
+		Reply(200).
+		BodyString(`{"success":true,"customers":[{"id":10,"firstName":"Ivan","lastName":"Petrov","phones":[{"number":"+79990000000"}]}]}`)
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/v5/customers/telegram_1").
+		Reply(200).
+		BodyString(`{"success":true,"customer":{"id":11,"externalId":"telegram_1","firstName":"John"}}`)
+
+	customer, err := getCRMCustomer(conn, &tgbotapi.User{ID: 1, FirstName: "John"}, "+79990000000")
+	require.NoError(t, err)
+	assert.Equal(t, 10, customer.ID)
+	assert.Empty(t, customer.ExternalID)
+	assert.Equal(t, "Ivan", customer.FirstName)
+	assert.True(t, gock.IsDone())
+
+	var chat Chat
+	chat.setLinkedCustomer(customer, "+79990000000")
+	assert.Equal(t, 10, chat.CustomerID)
+	assert.Equal(t, "+79990000000", chat.Phone)
+}
+
+func TestCRM_getCRMCustomerByPartialPhone(t *testing.T) {
+	defer gock.Off()
+
+	conn := &Connection{APIURL: "https://test.retailcrm.ru", APIKEY: "key"}
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/v5/customers").
+		MatchParam("filter[name]", "\\+79990000000").
+		Reply(200).
+		BodyString(`{"success":true,"customers":[{"id":10,"firstName":"Ivan","phones":[{"number":"+7 (999) 000-00-001"}]}]}`)
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/v5/customers/telegram_1").
+		Reply(200).
+		BodyString(`{"success":true,"customer":{"id":11,"externalId":"telegram_1","firstName":"John","phones":[{"number":"+79990000000"}]}}`)
+
+	customer, err := getCRMCustomer(conn, &tgbotapi.User{ID: 1, FirstName: "John"}, "+79990000000")
+	require.NoError(t, err)
+	assert.Equal(t, 11, customer.ID)
+	assert.Equal(t, "telegram_1", customer.ExternalID)
+	assert.True(t, gock.IsDone())
+
+	assert.True(t, hasPhone(&v5.Customer{Phones: []v5.Phone{{Number: "+7 (999) 000-00-00"}}}, "+79990000000"))
+	assert.False(t, hasPhone(&v5.Customer{Phones: []v5.Phone{{Number: "+79990000000"}}}, ""))
+}
+
+func TestCRM_getCRMCustomerCreate(t *testing.T) {
+	defer gock.Off()
+
+	conn := &Connection{APIURL: "https://test.retailcrm.ru", APIKEY: "key"}
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/v5/customers/telegram_1").
+		Reply(404).
+		BodyString(`{"success":false,"errorMsg":"Not found"}`)
+
+	gock.New("https://test.retailcrm.ru").
+		Post("/api/v5/customers/create").
+		Reply(201).
+		BodyString(`{"success":true,"id":11}`)
+
+	customer, err := getCRMCustomer(conn, &tgbotapi.User{ID: 1, FirstName: "John", LastName: "Doe"}, "")
+	require.NoError(t, err)
+	assert.Equal(t, 11, customer.ID)
+	assert.Equal(t, "telegram_1", customer.ExternalID)
+	assert.Equal(t, "John", customer.FirstName)
+	assert.True(t, gock.IsDone())
+}
+
+func TestCRM_setMGCustomer(t *testing.T) {
+	customer := v1.Customer{ExternalID: "1", Firstname: "John", Nickname: "jdoe"}
+
+	var chat Chat
+	chat.setMGCustomer(&customer)
+	assert.Equal(t, "1", customer.ExternalID)
+
+	chat.setLinkedCustomer(&v5.Customer{
+		ID:         10,
+		ExternalID: "telegram_1",
+		FirstName:  "Ivan",
+		Phones:     []v5.Phone{{Number: "+79990000000"}},
+	}, "")
+	chat.setMGCustomer(&customer)
+	assert.Equal(t, "1", customer.ExternalID)
+	assert.Equal(t, "Ivan", customer.Firstname)
+	assert.Equal(t, "+79990000000", customer.Phone)
+	assert.Equal(t, "jdoe", customer.Nickname)
+
+	chat.Lang = "es"
+	chat.setMGCustomer(&customer)
+	assert.Equal(t, "es", customer.Language)
+}
+
+func TestCRM_hasCRMCredentials(t *testing.T) {
+	defer gock.Off()
+
+	conn := &Connection{ID: 201, APIURL: "https://test.retailcrm.ru", APIKEY: "key"}
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/credentials").
+		Reply(200).
+		BodyString(`{"success": true, "credentials": ["/api/customers", "/api/customers/{externalId}", "/api/customers/{externalId}/edit", "/api/customers/create"]}`)
+
+	assert.True(t, hasCRMCredentials(conn, credentialsCustomers))
+	assert.False(t, hasCRMCredentials(conn, credentialsOrders), "the credentials are cached")
+	assert.True(t, gock.IsDone())
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/credentials").
+		Reply(403).
+		BodyString(`{"success": false, "errorMsg": "Wrong \"apiKey\" value."}`)
+
+	assert.False(t, hasCRMCredentials(&Connection{ID: 202, APIURL: "https://test.retailcrm.ru", APIKEY: "wrong"}, credentialsCustomers))
+	assert.True(t, gock.IsDone())
+}
+
+func TestCRM_createCRMOrder(t *testing.T) {
+	defer gock.Off()
+
+	conn := &Connection{APIURL: "https://test.retailcrm.ru/", APIKEY: "key"}
+
+	gock.New("https://test.retailcrm.ru").
+		Post("/api/v5/orders/create").
+		BodyString(`draft`).
+		Reply(201).
+		BodyString(`{"success":true,"id":25}`)
+
+	id, err := createCRMOrder(conn, &Bot{OrderStatus: "draft", OrderMethod: "messenger"}, 10, 42)
+	require.NoError(t, err)
+	assert.Equal(t, 25, id)
+	assert.Equal(t, "https://test.retailcrm.ru/orders/25/edit", getCRMOrderURL(conn, id))
+	assert.True(t, gock.IsDone())
+}
+
+func TestCRM_createCRMPayment(t *testing.T) {
+	defer gock.Off()
+
+	conn := &Connection{APIURL: "https://test.retailcrm.ru", APIKEY: "key"}
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/v5/orders").
+		MatchParam("filter[numbers][]", "1234C").
+		Reply(200).
+		BodyString(`{"success":true,"orders":[{"id":25,"number":"1234C","totalSumm":2350.5,"currency":"RUB"}]}`)
+
+	gock.New("https://test.retailcrm.ru").
+		Post("/api/v5/orders/payments/create").
+		BodyString(`2350.5`).
+		Reply(201).
+		BodyString(`{"success":true,"id":7}`)
+
+	order, err := getCRMOrder(conn, "1234C")
+	require.NoError(t, err)
+	assert.Equal(t, 25, order.ID)
+	assert.Equal(t, "RUB", order.Currency)
+	assert.Equal(t, float32(2350.5), order.TotalSumm)
+
+	id := order.ID
+
+	err = createCRMPayment(conn, &Bot{PaymentType: "card", PaymentStatus: "paid"}, id, &tgbotapi.SuccessfulPayment{
+		Currency:                "RUB",
+		TotalAmount:             235050,
+		TelegramPaymentChargeID: "telegram-charge",
+		ProviderPaymentChargeID: "provider-charge",
+	})
+	require.NoError(t, err)
+	assert.True(t, gock.IsDone())
+}
+
+func TestCRM_crmCatalog_GetOffers(t *testing.T) {
+	defer gock.Off()
+
+	conn := &Connection{APIURL: "https://test.retailcrm.ru", APIKEY: "key"}
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/v5/store/products").
+		MatchParam("filter[ids][]", "42").
+		Reply(200).
+		BodyString(`{"success":true,"products":[{"id":42,"name":"Phone case","offers":[{"id":420,"name":"Red"},{"id":421,"name":"Blue"}]}]}`)
+
+	offers, err := crmCatalog{conn: conn}.GetOffers(42)
+	require.NoError(t, err)
+	require.Len(t, offers, 2)
+	assert.Equal(t, 420, offers[0].ID)
+	assert.True(t, gock.IsDone())
+}
+
+func TestCRM_getCRMCurrency(t *testing.T) {
+	defer gock.Off()
+
+	conn := &Connection{APIURL: "https://test.retailcrm.ru", APIKEY: "key"}
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/v5/settings").
+		Reply(200).
+		BodyString(`{"success":true,"settings":{"default_currency":{"value":"RUB","updated_at":"2019-06-01 10:00:00"}}}`)
+
+	res, err := getCRMCurrency(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "rub", res)
+	assert.True(t, gock.IsDone())
+}
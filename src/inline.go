@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/retailcrm/api-client-go/v5"
+	"github.com/retailcrm/mg-transport-api-client-go/v1"
+)
+
+const (
+	// ProductCacheTTL is how long the catalog search results are reused for the same query
+	ProductCacheTTL = 5 * time.Minute
+	// CurrencyErrorTTL is how long the CRM is not asked for the currency again after the failure
+	CurrencyErrorTTL = time.Minute
+)
+
+type productCacheEntry struct {
+	products  []v5.Product
+	expiresAt time.Time
+}
+
+var productCache = struct {
+	sync.Mutex
+	entries map[string]productCacheEntry
+}{entries: map[string]productCacheEntry{}}
+
+func getProductCacheKey(conn *Connection, query string) string {
+	return fmt.Sprintf("%d:%s", conn.ID, strings.ToLower(strings.TrimSpace(query)))
+}
+
+// getCachedProducts returns the unexpired search results of the query, ok is false on a miss
+func getCachedProducts(conn *Connection, query string, now time.Time) ([]v5.Product, bool) {
+	productCache.Lock()
+	defer productCache.Unlock()
+
+	key := getProductCacheKey(conn, query)
+	entry, ok := productCache.entries[key]
+	if !ok || now.After(entry.expiresAt) {
+		delete(productCache.entries, key)
+		return nil, false
+	}
+
+	return entry.products, true
+}
+
+func setCachedProducts(conn *Connection, query string, products []v5.Product, now time.Time) {
+	productCache.Lock()
+	defer productCache.Unlock()
+
+	for k, v := range productCache.entries {
+		if now.After(v.expiresAt) {
+			delete(productCache.entries, k)
+		}
+	}
+
+	productCache.entries[getProductCacheKey(conn, query)] = productCacheEntry{
+		products:  products,
+		expiresAt: now.Add(ProductCacheTTL),
+	}
+}
+
+type currencyCacheEntry struct {
+	currency  string
+	expiresAt time.Time
+}
+
+var currencyCache = struct {
+	sync.Mutex
+	entries map[int]currencyCacheEntry
+}{entries: map[int]currencyCacheEntry{}}
+
+// getCatalogCurrency returns the currency of the catalog prices, it is cached for ProductCacheTTL, the failure for
+// CurrencyErrorTTL. The prices are shown without the currency if the CRM settings are not available
+func getCatalogCurrency(conn *Connection) string {
+	now := time.Now()
+
+	currencyCache.Lock()
+	entry, ok := currencyCache.entries[conn.ID]
+	currencyCache.Unlock()
+
+	if ok && now.Before(entry.expiresAt) {
+		return entry.currency
+	}
+
+	res, err := getCRMCurrency(conn)
+	ttl := ProductCacheTTL
+	if err != nil {
+		logger.Errorf("getCRMCurrency apiURL: %s, err: %s", conn.APIURL, err.Error())
+		res, ttl = "", CurrencyErrorTTL
+	}
+
+	currencyCache.Lock()
+	currencyCache.entries[conn.ID] = currencyCacheEntry{currency: res, expiresAt: now.Add(ttl)}
+	currencyCache.Unlock()
+
+	return res
+}
+
+// findProducts searches the CRM catalog, the results are cached for ProductCacheTTL
+func findProducts(conn *Connection, query string) ([]v5.Product, error) {
+	now := time.Now()
+	if products, ok := getCachedProducts(conn, query, now); ok {
+		return products, nil
+	}
+
+	products, err := searchCRMProducts(conn, strings.TrimSpace(query))
+	if err != nil {
+		return nil, err
+	}
+
+	setCachedProducts(conn, query, products, now)
+
+	return products, nil
+}
+
+// findProduct returns the product chosen from the results of the query, the catalog is asked when the results are gone from the cache
+func findProduct(conn *Connection, query string, id int) (*v5.Product, error) {
+	if products, ok := getCachedProducts(conn, query, time.Now()); ok {
+		for i := range products {
+			if products[i].ID == id {
+				return &products[i], nil
+			}
+		}
+	}
+
+	return getCRMProduct(conn, id)
+}
+
+// getMessageDataProduct converts the catalog product to the product of the MG message
+func getMessageDataProduct(product *v5.Product, currency string) *v1.MessageDataProduct {
+	res := &v1.MessageDataProduct{
+		ID:      uint64(product.ID),
+		Name:    product.Name,
+		Article: product.Article,
+		Url:     product.URL,
+		Img:     product.ImageURL,
+	}
+
+	if product.MinPrice != 0 {
+		res.Cost = &v1.MessageDataOrderCost{Value: product.MinPrice, Currency: currency}
+	}
+
+	return res
+}
+
+func getProductCost(amount float32, code string) string {
+	symbol, ok := currency[code]
+	if !ok {
+		return fmt.Sprintf("%v", amount)
+	}
+
+	return getLocalizedTemplateMessage("cost_currency", map[string]interface{}{"Amount": amount, "Currency": symbol})
+}
+
+// getProductPrice returns the price of the product, the range is shown for the offers with different prices
+func getProductPrice(product *v5.Product, currency string) string {
+	if product.MinPrice == 0 {
+		return ""
+	}
+
+	if product.MaxPrice > product.MinPrice {
+		return fmt.Sprintf("%s – %s", getProductCost(product.MinPrice, currency), getProductCost(product.MaxPrice, currency))
+	}
+
+	return getProductCost(product.MinPrice, currency)
+}
+
+// getInlineResults returns the article results of the products
+func getInlineResults(products []v5.Product, currency string) []interface{} {
+	res := make([]interface{}, 0, len(products))
+
+	for i := range products {
+		p := &products[i]
+		article := tgbotapi.NewInlineQueryResultArticleMarkdown(
+			strconv.Itoa(p.ID),
+			p.Name,
+			getProductMessage(getMessageDataProduct(p, currency)),
+		)
+		article.URL = p.URL
+		article.ThumbURL = p.ImageURL
+
+		if price := getProductPrice(p, currency); price != "" {
+			article.Description = fmt.Sprintf("%s: %s", getLocalizedMessage("item_cost"), price)
+		}
+
+		res = append(res, article)
+	}
+
+	return res
+}
+
+// getChosenProductText returns the MG message about the product the customer has sent in inline mode
+func getChosenProductText(product *v5.Product, currency string) string {
+	lines := []string{product.Name}
+
+	if price := getProductPrice(product, currency); price != "" {
+		lines = append(lines, fmt.Sprintf("%s: %s", getLocalizedMessage("item_cost"), price))
+	}
+
+	if product.URL != "" {
+		lines = append(lines, product.URL)
+	}
+
+	return strings.Join(lines, "\n")
+}
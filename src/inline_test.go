@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/h2non/gock"
+	"github.com/retailcrm/api-client-go/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInline_findProducts(t *testing.T) {
+	defer gock.Off()
+
+	conn := &Connection{ID: 101, APIURL: "https://test.retailcrm.ru", APIKEY: "key"}
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/v5/store/products").
+		MatchParam("filter[name]", "case").
+		MatchParam("filter[active]", "1").
+		Reply(200).
+		BodyString(`{"success":true,"products":[{"id":42,"name":"Phone case","minPrice":500,"maxPrice":700,"url":"https://example.com/phone-case"}]}`)
+
+	products, err := findProducts(conn, "case")
+	require.NoError(t, err)
+	require.Len(t, products, 1)
+	assert.Equal(t, 42, products[0].ID)
+	assert.True(t, gock.IsDone())
+
+	products, err = findProducts(conn, " Case ")
+	require.NoError(t, err)
+	require.Len(t, products, 1)
+
+	product, err := findProduct(conn, "case", 42)
+	require.NoError(t, err)
+	assert.Equal(t, "Phone case", product.Name)
+}
+
+func TestInline_getCatalogCurrency(t *testing.T) {
+	defer gock.Off()
+
+	conn := &Connection{ID: 104, APIURL: "https://test.retailcrm.ru", APIKEY: "key"}
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/v5/settings").
+		Reply(500).
+		BodyString(`{"success":false,"errorMsg":"Internal error"}`)
+
+	assert.Equal(t, "", getCatalogCurrency(conn))
+	assert.True(t, gock.IsDone())
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/v5/settings").
+		Reply(200).
+		BodyString(`{"success":true,"settings":{"default_currency":{"value":"RUB"}}}`)
+
+	assert.Equal(t, "", getCatalogCurrency(conn), "the failure is cached")
+	assert.True(t, gock.IsPending())
+
+	currencyCache.Lock()
+	delete(currencyCache.entries, conn.ID)
+	currencyCache.Unlock()
+
+	assert.Equal(t, "rub", getCatalogCurrency(conn))
+	assert.Equal(t, "rub", getCatalogCurrency(conn))
+	assert.True(t, gock.IsDone())
+}
+
+func TestInline_getCachedProducts(t *testing.T) {
+	conn := &Connection{ID: 102}
+	now := time.Now()
+
+	setCachedProducts(conn, "lamp", []v5.Product{{ID: 1}}, now)
+
+	products, ok := getCachedProducts(conn, "lamp", now.Add(ProductCacheTTL-time.Second))
+	assert.True(t, ok)
+	assert.Len(t, products, 1)
+
+	_, ok = getCachedProducts(conn, "lamp", now.Add(ProductCacheTTL+time.Second))
+	assert.False(t, ok)
+
+	_, ok = getCachedProducts(&Connection{ID: 103}, "lamp", now)
+	assert.False(t, ok)
+}
+
+func TestInline_getInlineResults(t *testing.T) {
+	setLocale("en")
+
+	results := getInlineResults([]v5.Product{
+		{ID: 42, Name: "Phone_case", MinPrice: 500, MaxPrice: 700, URL: "https://example.com/phone-case", ImageURL: "https://example.com/phone-case.jpg"},
+		{ID: 43, Name: "Gift card"},
+	}, "usd")
+	require.Len(t, results, 2)
+
+	article := results[0].(tgbotapi.InlineQueryResultArticle)
+	assert.Equal(t, "42", article.ID)
+	assert.Equal(t, "Phone_case", article.Title)
+	assert.Equal(t, "Cost: $500 – $700", article.Description)
+	assert.Equal(t, "https://example.com/phone-case.jpg", article.ThumbURL)
+	assert.Equal(t, "https://example.com/phone-case", article.URL)
+	assert.Contains(t, article.InputMessageContent.(tgbotapi.InputTextMessageContent).Text, "*Phone\\_case*")
+	assert.Contains(t, article.InputMessageContent.(tgbotapi.InputTextMessageContent).Text, "Cost: $500")
+
+	assert.Empty(t, results[1].(tgbotapi.InlineQueryResultArticle).Description)
+}
+
+func TestInline_getChosenProductText(t *testing.T) {
+	setLocale("en")
+
+	assert.Equal(
+		t,
+		"Phone case\nCost: ₽500\nhttps://example.com/phone-case",
+		getChosenProductText(&v5.Product{Name: "Phone case", MinPrice: 500, MaxPrice: 500, URL: "https://example.com/phone-case"}, "rub"),
+	)
+	assert.Equal(t, "Gift card", getChosenProductText(&v5.Product{Name: "Gift card"}, "rub"))
+	assert.Equal(t, "Lamp\nCost: 10", getChosenProductText(&v5.Product{Name: "Lamp", MinPrice: 10}, ""))
+}
+
+func TestInline_getMessageDataProduct(t *testing.T) {
+	res := getMessageDataProduct(&v5.Product{ID: 42, Name: "Phone case", MinPrice: 500}, "rub")
+	require.NotNil(t, res.Cost)
+	assert.Equal(t, float32(500), res.Cost.Value)
+	assert.Equal(t, "rub", res.Cost.Currency)
+
+	assert.Nil(t, getMessageDataProduct(&v5.Product{ID: 43, Name: "Gift card"}, "rub").Cost)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"html/template"
 	"io/ioutil"
 
@@ -12,11 +13,18 @@ import (
 var (
 	localizer *i18n.Localizer
 	bundle    = &i18n.Bundle{DefaultLanguage: language.English}
+	languages = []string{"en", "ru", "es"}
 	matcher   = language.NewMatcher([]language.Tag{
 		language.English,
 		language.Russian,
 		language.Spanish,
 	})
+	// languageNames are shown to the customers choosing the language
+	languageNames = map[string]string{
+		"en": "English",
+		"ru": "Русский",
+		"es": "Español",
+	}
 )
 
 func loadTranslateFile() {
@@ -33,16 +41,26 @@ func loadTranslateFile() {
 }
 
 func setLocale(al string) {
+	localizer = newLocalizer(al)
+}
+
+// newLocalizer returns a localizer of its own for the background workers, they must not switch the shared one
+func newLocalizer(al string) *i18n.Localizer {
 	tag, _ := language.MatchStrings(matcher, al)
-	localizer = i18n.NewLocalizer(bundle, tag.String())
+
+	return i18n.NewLocalizer(bundle, tag.String())
 }
 
 func getLocalizedMessage(messageID string) string {
-	return localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: messageID})
+	return localize(localizer, messageID, nil)
 }
 
 func getLocalizedTemplateMessage(messageID string, templateData map[string]interface{}) string {
-	return localizer.MustLocalize(&i18n.LocalizeConfig{
+	return localize(localizer, messageID, templateData)
+}
+
+func localize(l *i18n.Localizer, messageID string, templateData map[string]interface{}) string {
+	return l.MustLocalize(&i18n.LocalizeConfig{
 		MessageID:    messageID,
 		TemplateData: templateData,
 	})
@@ -50,19 +68,189 @@ func getLocalizedTemplateMessage(messageID string, templateData map[string]inter
 
 func getLocale() map[string]interface{} {
 	return map[string]interface{}{
-		"Version":     config.Version,
-		"ButtonSave":  getLocalizedMessage("button_save"),
-		"ApiKey":      getLocalizedMessage("api_key"),
-		"TabSettings": getLocalizedMessage("tab_settings"),
-		"TabBots":     getLocalizedMessage("tab_bots"),
-		"TableName":   getLocalizedMessage("table_name"),
-		"TableToken":  getLocalizedMessage("table_token"),
-		"AddBot":      getLocalizedMessage("add_bot"),
-		"TableDelete": getLocalizedMessage("table_delete"),
-		"Title":       getLocalizedMessage("title"),
-		"Language":    getLocalizedMessage("language"),
-		"InfoBot":     template.HTML(getLocalizedMessage("info_bot")),
-		"CRMLink":     template.HTML(getLocalizedMessage("crm_link")),
-		"DocLink":     template.HTML(getLocalizedMessage("doc_link")),
+		"Version":            config.Version,
+		"ButtonSave":         getLocalizedMessage("button_save"),
+		"ApiKey":             getLocalizedMessage("api_key"),
+		"TabSettings":        getLocalizedMessage("tab_settings"),
+		"TabBots":            getLocalizedMessage("tab_bots"),
+		"TableName":          getLocalizedMessage("table_name"),
+		"TableToken":         getLocalizedMessage("table_token"),
+		"AddBot":             getLocalizedMessage("add_bot"),
+		"TableDelete":        getLocalizedMessage("table_delete"),
+		"Title":              getLocalizedMessage("title"),
+		"Language":           getLocalizedMessage("language"),
+		"GroupPolicy":        getLocalizedMessage("group_policy"),
+		"BotSettings":        getLocalizedMessage("bot_settings"),
+		"PayloadSources":     getLocalizedMessage("payload_sources"),
+		"PayloadSourcesInfo": getLocalizedMessage("payload_sources_info"),
+		"PayloadPrefix":      getLocalizedMessage("payload_prefix"),
+		"PayloadSource":      getLocalizedMessage("payload_source"),
+		"DeepLinks":          getLocalizedMessage("deep_links"),
+		"DeepLink":           getLocalizedMessage("deep_link"),
+		"DeepLinkName":       getLocalizedMessage("deep_link_name"),
+		"DeepLinkPayload":    getLocalizedMessage("deep_link_payload"),
+		"QRCode":             getLocalizedMessage("qr_code"),
+		"Commands":           getLocalizedMessage("commands"),
+		"CommandsInfo":       getLocalizedMessage("commands_info"),
+		"Command":            getLocalizedMessage("command"),
+		"CommandDescription": getLocalizedMessage("command_description"),
+		"CommandReply":       getLocalizedMessage("command_reply"),
+		"CommandForward":     getLocalizedMessage("command_forward"),
+		"BusinessHours":      getLocalizedMessage("business_hours"),
+		"BusinessHoursInfo":  getLocalizedMessage("business_hours_info"),
+		"Timezone":           getLocalizedMessage("timezone"),
+		"AwayInterval":       getLocalizedMessage("away_interval"),
+		"AwayNote":           getLocalizedMessage("away_note"),
+		"Weekday":            getLocalizedMessage("weekday"),
+		"Opens":              getLocalizedMessage("opens"),
+		"Closes":             getLocalizedMessage("closes"),
+		"Holidays":           getLocalizedMessage("holidays"),
+		"HolidayDate":        getLocalizedMessage("holiday_date"),
+		"HolidayName":        getLocalizedMessage("holiday_name"),
+		"AwayMessages":       getLocalizedMessage("away_messages"),
+		"AwayMessage":        getLocalizedMessage("away_message"),
+		"Rules":              getLocalizedMessage("rules"),
+		"RulesInfo":          getLocalizedMessage("rules_info"),
+		"RuleType":           getLocalizedMessage("rule_type"),
+		"RulePattern":        getLocalizedMessage("rule_pattern"),
+		"RuleActive":         getLocalizedMessage("rule_active"),
+		"RuleHits":           getLocalizedMessage("rule_hits"),
+		"AnyLanguage":        getLocalizedMessage("any_language"),
+		"SpamFilter":         getLocalizedMessage("spam_filter"),
+		"SpamFilterInfo":     getLocalizedMessage("spam_filter_info"),
+		"FloodLimit":         getLocalizedMessage("flood_limit"),
+		"FloodMute":          getLocalizedMessage("flood_mute"),
+		"BlockedMessages":    getLocalizedMessage("blocked_messages"),
+		"BlockedUsers":       getLocalizedMessage("blocked_users"),
+		"UserID":             getLocalizedMessage("user_id"),
+		"Comment":            getLocalizedMessage("comment"),
+		"RequestPhone":       getLocalizedMessage("request_phone"),
+		"RequestPhoneInfo":   getLocalizedMessage("request_phone_info"),
+		"RequestPhoneOn":     getLocalizedMessage("request_phone_enabled"),
+		"Orders":             getLocalizedMessage("orders"),
+		"OrdersInfo":         getLocalizedMessage("orders_info"),
+		"OrderButton":        getLocalizedMessage("order_button_enabled"),
+		"OrderStatus":        getLocalizedMessage("order_status"),
+		"OrderMethod":        getLocalizedMessage("order_method"),
+		"Payments":           getLocalizedMessage("payments"),
+		"PaymentsInfo":       getLocalizedMessage("payments_info"),
+		"PaymentToken":       getLocalizedMessage("payment_token"),
+		"PaymentType":        getLocalizedMessage("payment_type"),
+		"PaymentStatus":      getLocalizedMessage("payment_status"),
+		"Csat":               getLocalizedMessage("csat"),
+		"CsatInfo":           getLocalizedMessage("csat_info"),
+		"CsatEnabled":        getLocalizedMessage("csat_enabled"),
+		"CsatCount":          getLocalizedMessage("csat_count"),
+		"CsatAverage":        getLocalizedMessage("csat_average"),
+		"OperatorActivity":   getLocalizedMessage("operator_notifications"),
+		"OperatorInfo":       getLocalizedMessage("operator_notifications_info"),
+		"OperatorJoined":     getLocalizedMessage("operator_joined_enabled"),
+		"OperatorJoinedText": getLocalizedMessage("operator_joined_text"),
+		"ChatActions":        getLocalizedMessage("chat_actions_enabled"),
+		"Signature":          getLocalizedMessage("signature"),
+		"SignatureInfo":      getLocalizedMessage("signature_info"),
+		"SignatureFormat":    getLocalizedMessage("signature_format"),
+		"SignatureOff":       getLocalizedMessage("signature_off"),
+		"Staff":              getLocalizedMessage("staff_notifications"),
+		"StaffInfo":          getLocalizedMessage("staff_notifications_info"),
+		"StaffChatID":        getLocalizedMessage("staff_chat_id"),
+		"UnansweredMinutes":  getLocalizedMessage("unanswered_minutes"),
+		"Inline":             getLocalizedMessage("inline_search"),
+		"InlineInfo":         getLocalizedMessage("inline_search_info"),
+		"InlineEnabled":      getLocalizedMessage("inline_search_enabled"),
+		"Quiet":              getLocalizedMessage("quiet_hours"),
+		"QuietInfo":          getLocalizedMessage("quiet_hours_info"),
+		"QuietFrom":          getLocalizedMessage("quiet_from"),
+		"QuietTo":            getLocalizedMessage("quiet_to"),
+		"QuietDefer":         getLocalizedMessage("quiet_defer"),
+		"Fallbacks":          getLocalizedMessage("fallbacks"),
+		"FallbacksInfo":      getLocalizedMessage("fallbacks_info"),
+		"EditFallback":       getLocalizedMessage("edit_fallback"),
+		"DeleteFallback":     getLocalizedMessage("delete_fallback"),
+		"FallbackOff":        getLocalizedMessage("fallback_off"),
+		"Campaigns":          getLocalizedMessage("campaigns"),
+		"CampaignsInfo":      getLocalizedMessage("campaigns_info"),
+		"CampaignName":       getLocalizedMessage("campaign_name"),
+		"CampaignText":       getLocalizedMessage("campaign_text"),
+		"CampaignPhoto":      getLocalizedMessage("campaign_photo"),
+		"CampaignButtons":    getLocalizedMessage("campaign_buttons"),
+		"CampaignStatus":     getLocalizedMessage("campaign_status"),
+		"CampaignProgress":   getLocalizedMessage("campaign_progress"),
+		"RecipientSent":      getLocalizedMessage("recipient_sent"),
+		"RecipientFailed":    getLocalizedMessage("recipient_failed"),
+		"RecipientBlocked":   getLocalizedMessage("recipient_blocked"),
+		"RecipientSkipped":   getLocalizedMessage("recipient_skipped"),
+		"NotifyAPI":          getLocalizedMessage("notify_api"),
+		"NotifyAPIInfo":      getLocalizedMessage("notify_api_info"),
+		"NotifyToken":        getLocalizedMessage("notify_token"),
+		"GenerateToken":      getLocalizedMessage("generate_token"),
+		"RotateToken":        getLocalizedMessage("rotate_token"),
+		"InfoBot":            template.HTML(getLocalizedMessage("info_bot")),
+		"CRMLink":            template.HTML(getLocalizedMessage("crm_link")),
+		"DocLink":            template.HTML(getLocalizedMessage("doc_link")),
+	}
+}
+
+func isLanguage(lang string) bool {
+	for _, v := range languages {
+		if v == lang {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getWeekdays returns the weekday names indexed by time.Weekday
+func getWeekdays() []string {
+	weekdays := make([]string, 7)
+	for i := range weekdays {
+		weekdays[i] = getLocalizedMessage(fmt.Sprintf("weekday_%d", i))
+	}
+
+	return weekdays
+}
+
+func getRuleTypes() map[string]string {
+	return map[string]string{
+		RuleTypeKeyword: getLocalizedMessage("rule_type_keyword"),
+		RuleTypeRegex:   getLocalizedMessage("rule_type_regex"),
+		RuleTypeCommand: getLocalizedMessage("rule_type_command"),
+	}
+}
+
+func getCampaignStatuses() map[string]string {
+	return map[string]string{
+		CampaignStatusDraft:    getLocalizedMessage("campaign_status_draft"),
+		CampaignStatusRunning:  getLocalizedMessage("campaign_status_running"),
+		CampaignStatusPaused:   getLocalizedMessage("campaign_status_paused"),
+		CampaignStatusFinished: getLocalizedMessage("campaign_status_finished"),
+	}
+}
+
+func getSignaturePositions() map[string]string {
+	return map[string]string{
+		SignatureAppend:  getLocalizedMessage("signature_append"),
+		SignaturePrepend: getLocalizedMessage("signature_prepend"),
+	}
+}
+
+func getGroupPolicies() map[string]string {
+	return map[string]string{
+		GroupPolicyIgnore: getLocalizedMessage("group_policy_ignore"),
+		GroupPolicyBridge: getLocalizedMessage("group_policy_bridge"),
+	}
+}
+
+func getEditFallbacks() map[string]string {
+	return map[string]string{
+		EditFallbackResend: getLocalizedMessage("edit_fallback_resend"),
+	}
+}
+
+func getDeleteFallbacks() map[string]string {
+	return map[string]string{
+		DeleteFallbackNotice: getLocalizedMessage("delete_fallback_notice"),
+		DeleteFallbackIgnore: getLocalizedMessage("delete_fallback_ignore"),
 	}
 }
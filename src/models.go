@@ -2,31 +2,122 @@ package main
 
 import "time"
 
+const (
+	// GroupPolicyIgnore drops messages from group chats
+	GroupPolicyIgnore = "ignore"
+	// GroupPolicyBridge forwards a group chat to MG as a single dialog
+	GroupPolicyBridge = "bridge"
+
+	// CampaignStatusDraft is the status of the campaign which was not started yet
+	CampaignStatusDraft = "draft"
+	// CampaignStatusRunning is the status of the campaign being sent
+	CampaignStatusRunning = "running"
+	// CampaignStatusPaused is the status of the campaign stopped by the user
+	CampaignStatusPaused = "paused"
+	// CampaignStatusFinished is the status of the campaign sent to all recipients
+	CampaignStatusFinished = "finished"
+
+	// RecipientStatusPending is the status of the recipient waiting for the message
+	RecipientStatusPending = "pending"
+	// RecipientStatusSending is the status of the recipient claimed by the worker sending the message
+	RecipientStatusSending = "sending"
+	// RecipientStatusSent is the status of the recipient who got the message
+	RecipientStatusSent = "sent"
+	// RecipientStatusFailed is the status of the recipient whose message was not sent
+	RecipientStatusFailed = "failed"
+	// RecipientStatusBlocked is the status of the recipient who blocked the bot
+	RecipientStatusBlocked = "blocked"
+	// RecipientStatusSkipped is the status of the recipient who opted out after the start of the campaign
+	RecipientStatusSkipped = "skipped"
+
+	// DeferredKindRating is the deferred request to rate the dialog
+	DeferredKindRating = "rating"
+
+	// RuleTypeKeyword matches messages containing one of the comma separated keywords
+	RuleTypeKeyword = "keyword"
+	// RuleTypeRegex matches messages by the regular expression
+	RuleTypeRegex = "regex"
+	// RuleTypeCommand matches the command addressed to the bot
+	RuleTypeCommand = "command"
+
+	// SignatureAppend adds the operator signature after the text
+	SignatureAppend = "append"
+	// SignaturePrepend adds the operator signature before the text
+	SignaturePrepend = "prepend"
+
+	// EditFallbackResend sends the edited text as a new message when Telegram refuses the edit
+	EditFallbackResend = "resend"
+	// DeleteFallbackNotice sends the notice that the message was withdrawn when Telegram refuses the deletion
+	DeleteFallbackNotice = "notice"
+	// DeleteFallbackIgnore leaves the message as is when Telegram refuses the deletion
+	DeleteFallbackIgnore = "ignore"
+)
+
 // Connection model
 type Connection struct {
-	ID        int    `gorm:"primary_key"`
-	ClientID  string `gorm:"client_id type:varchar(70);not null;unique" json:"clientId,omitempty"`
-	APIKEY    string `gorm:"api_key type:varchar(100);not null" json:"api_key,omitempty" binding:"required,max=100"`
-	APIURL    string `gorm:"api_url type:varchar(255);not null" json:"api_url,omitempty" binding:"required,validatecrmurl,max=255"`
-	MGURL     string `gorm:"mg_url type:varchar(255);not null;" json:"mg_url,omitempty" binding:"max=255"`
-	MGToken   string `gorm:"mg_token type:varchar(100);not null;unique" json:"mg_token,omitempty" binding:"max=100"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	Active    bool  `json:"active,omitempty"`
-	Bots      []Bot `gorm:"foreignkey:ConnectionID"`
+	ID          int    `gorm:"primary_key"`
+	ClientID    string `gorm:"client_id type:varchar(70);not null;unique" json:"clientId,omitempty"`
+	APIKEY      string `gorm:"api_key type:varchar(100);not null" json:"api_key,omitempty" binding:"required,max=100"`
+	APIURL      string `gorm:"api_url type:varchar(255);not null" json:"api_url,omitempty" binding:"required,validatecrmurl,max=255"`
+	MGURL       string `gorm:"mg_url type:varchar(255);not null;" json:"mg_url,omitempty" binding:"max=255"`
+	MGToken     string `gorm:"mg_token type:varchar(100);not null;unique" json:"mg_token,omitempty" binding:"max=100"`
+	NotifyToken string `gorm:"notify_token type:varchar(100)" json:"-"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Active      bool  `json:"active,omitempty"`
+	Bots        []Bot `gorm:"foreignkey:ConnectionID"`
 }
 
 // Bot model
 type Bot struct {
-	ID                  int    `gorm:"primary_key"`
-	ConnectionID        int    `gorm:"connection_id" json:"connectionId,omitempty"`
-	Channel             uint64 `gorm:"channel;not null;unique" json:"channel,omitempty"`
-	ChannelSettingsHash string `gorm:"channel_settings_hash type:varchar(70)" binding:"max=70"`
-	Token               string `gorm:"token type:varchar(100);not null;unique" json:"token,omitempty" binding:"max=100"`
-	Name                string `gorm:"name type:varchar(40)" json:"name,omitempty" binding:"max=40"`
-	Lang                string `gorm:"lang type:varchar(2)" json:"lang,omitempty" binding:"max=2"`
+	ID                  int        `gorm:"primary_key"`
+	ConnectionID        int        `gorm:"connection_id" json:"connectionId,omitempty"`
+	Channel             uint64     `gorm:"channel;not null;unique" json:"channel,omitempty"`
+	ChannelSettingsHash string     `gorm:"channel_settings_hash type:varchar(70)" binding:"max=70"`
+	Token               string     `gorm:"token type:varchar(100);not null;unique" json:"token,omitempty" binding:"max=100"`
+	Name                string     `gorm:"name type:varchar(40)" json:"name,omitempty" binding:"max=40"`
+	Lang                string     `gorm:"lang type:varchar(2)" json:"lang,omitempty" binding:"max=2"`
+	GroupPolicy         string     `gorm:"group_policy type:varchar(10)" json:"groupPolicy,omitempty" binding:"max=10"`
+	Timezone            string     `gorm:"timezone type:varchar(50)" json:"timezone,omitempty" binding:"max=50"`
+	AwayInterval        int        `gorm:"away_interval" json:"awayInterval,omitempty"`
+	AwayNote            bool       `gorm:"away_note" json:"awayNote,omitempty"`
+	FloodLimit          int        `gorm:"flood_limit" json:"floodLimit,omitempty"`
+	FloodMute           int        `gorm:"flood_mute" json:"floodMute,omitempty"`
+	BlockedMessages     int        `gorm:"blocked_messages" json:"-"`
+	RequestPhone        bool       `gorm:"request_phone" json:"requestPhone,omitempty"`
+	OrderButton         bool       `gorm:"order_button" json:"orderButton,omitempty"`
+	OrderStatus         string     `gorm:"order_status type:varchar(255)" json:"orderStatus,omitempty"`
+	OrderMethod         string     `gorm:"order_method type:varchar(255)" json:"orderMethod,omitempty"`
+	PaymentToken        string     `gorm:"payment_token type:varchar(255)" json:"-"`
+	PaymentType         string     `gorm:"payment_type type:varchar(255)" json:"paymentType,omitempty"`
+	PaymentStatus       string     `gorm:"payment_status type:varchar(255)" json:"paymentStatus,omitempty"`
+	Csat                bool       `gorm:"csat" json:"csat,omitempty"`
+	InlineSearch        bool       `gorm:"inline_search" json:"inlineSearch,omitempty"`
+	OperatorJoined      bool       `gorm:"operator_joined" json:"operatorJoined,omitempty"`
+	OperatorJoinedText  string     `gorm:"operator_joined_text type:varchar(255)" json:"operatorJoinedText,omitempty"`
+	ChatActions         bool       `gorm:"chat_actions" json:"chatActions,omitempty"`
+	SignaturePosition   string     `gorm:"signature_position type:varchar(10)" json:"signaturePosition,omitempty"`
+	SignatureFormat     string     `gorm:"signature_format type:varchar(255)" json:"signatureFormat,omitempty"`
+	StaffChatID         int64      `gorm:"staff_chat_id" json:"staffChatId,omitempty"`
+	UnansweredMinutes   int        `gorm:"unanswered_minutes" json:"unansweredMinutes,omitempty"`
+	StaffSince          *time.Time `gorm:"staff_since" json:"-"`
+	QuietFrom           string     `gorm:"quiet_from type:varchar(5)" json:"quietFrom,omitempty"`
+	QuietTo             string     `gorm:"quiet_to type:varchar(5)" json:"quietTo,omitempty"`
+	QuietDefer          bool       `gorm:"quiet_defer" json:"quietDefer,omitempty"`
+	EditFallback        string     `gorm:"edit_fallback type:varchar(10)" json:"editFallback,omitempty"`
+	DeleteFallback      string     `gorm:"delete_fallback type:varchar(10)" json:"deleteFallback,omitempty"`
 	CreatedAt           time.Time
 	UpdatedAt           time.Time
+	PayloadSources      []PayloadSource `gorm:"foreignkey:BotID" json:"-"`
+	DeepLinks           []DeepLink      `gorm:"foreignkey:BotID" json:"-"`
+	Commands            []BotCommand    `gorm:"foreignkey:BotID" json:"-"`
+	BusinessHours       []BusinessHours `gorm:"foreignkey:BotID" json:"-"`
+	Holidays            []Holiday       `gorm:"foreignkey:BotID" json:"-"`
+	AwayMessages        []AwayMessage   `gorm:"foreignkey:BotID" json:"-"`
+	Rules               []AutoReplyRule `gorm:"foreignkey:BotID" json:"-"`
+	BlockedUsers        []BlockedUser   `gorm:"foreignkey:BotID" json:"-"`
+	Campaigns           []Campaign      `gorm:"foreignkey:BotID" json:"-"`
+	RatingStats         RatingStats     `gorm:"-" json:"-"`
 }
 
 // User model
@@ -43,5 +134,199 @@ func (User) TableName() string {
 	return "mg_user"
 }
 
+// Chat model
+type Chat struct {
+	ID            int        `gorm:"primary_key"`
+	BotID         int        `gorm:"bot_id;not null"`
+	ExternalID    int64      `gorm:"external_id;not null"`
+	Blocked       bool       `gorm:"blocked;not null"`
+	MigratedToID  int64      `gorm:"migrated_to_id"`
+	StartPayload  string     `gorm:"start_payload type:varchar(64)"`
+	AwaySentAt    *time.Time `gorm:"away_sent_at"`
+	OptedOut      bool       `gorm:"opted_out"`
+	CustomerID    int        `gorm:"customer_id"`
+	CustomerExtID string     `gorm:"customer_ext_id type:varchar(255)"`
+	FirstName     string     `gorm:"first_name type:varchar(255)"`
+	LastName      string     `gorm:"last_name type:varchar(255)"`
+	Phone         string     `gorm:"phone type:varchar(50)"`
+	PhoneAskedAt  *time.Time `gorm:"phone_asked_at"`
+	RatingID      int        `gorm:"rating_id"`
+	Name          string     `gorm:"name type:varchar(255)"`
+	LastMessage   string     `gorm:"last_message type:varchar(255)"`
+	LastInboundAt *time.Time `gorm:"last_inbound_at"`
+	LastReplyAt   *time.Time `gorm:"last_reply_at"`
+	AlertSentAt   *time.Time `gorm:"alert_sent_at"`
+	AlertLockedAt *time.Time `gorm:"alert_locked_at"`
+	DialogClosed  bool       `gorm:"dialog_closed"`
+	Operator      string     `gorm:"operator type:varchar(255)"`
+	Lang          string     `gorm:"lang type:varchar(2)"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// PayloadSource model maps a deep-link payload prefix to the CRM customer source
+type PayloadSource struct {
+	ID        int    `gorm:"primary_key"`
+	BotID     int    `gorm:"bot_id;not null" json:"-"`
+	Prefix    string `gorm:"prefix type:varchar(64);not null" json:"prefix" binding:"required,max=64"`
+	Source    string `gorm:"source type:varchar(255);not null" json:"source" binding:"required,max=255"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// DeepLink model is a named t.me link with the /start payload
+type DeepLink struct {
+	ID        int    `gorm:"primary_key"`
+	BotID     int    `gorm:"bot_id;not null" json:"-"`
+	Name      string `gorm:"name type:varchar(100);not null" json:"name" binding:"required,max=100"`
+	Payload   string `gorm:"payload type:varchar(64);not null" json:"payload" binding:"required,validatepayload"`
+	URL       string `gorm:"-" json:"url"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// BotCommand model is the auto-reply to the command in the customer language
+type BotCommand struct {
+	ID          int    `gorm:"primary_key"`
+	BotID       int    `gorm:"bot_id;not null" json:"-"`
+	Name        string `gorm:"name type:varchar(32);not null" json:"name" binding:"required,validatecommand"`
+	Lang        string `gorm:"lang type:varchar(2);not null" json:"lang" binding:"required,max=2"`
+	Description string `gorm:"description type:varchar(256);not null" json:"description" binding:"required,max=256"`
+	Reply       string `gorm:"reply type:text" json:"reply" binding:"max=4096"`
+	Forward     bool   `gorm:"forward;not null" json:"forward"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// BusinessHours model is the working interval of the bot operators on the weekday
+type BusinessHours struct {
+	ID        int    `gorm:"primary_key"`
+	BotID     int    `gorm:"bot_id;not null" json:"-"`
+	Weekday   int    `gorm:"weekday;not null" json:"weekday" binding:"min=0,max=6"`
+	Opens     string `gorm:"opens type:varchar(5);not null" json:"opens" binding:"required,validatetime"`
+	Closes    string `gorm:"closes type:varchar(5);not null" json:"closes" binding:"required,validatetime"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Holiday model is the day when the bot operators do not work
+type Holiday struct {
+	ID        int       `gorm:"primary_key"`
+	BotID     int       `gorm:"bot_id;not null" json:"-"`
+	Date      time.Time `gorm:"date type:date;not null" json:"date"`
+	Name      string    `gorm:"name type:varchar(100)" json:"name"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// AwayMessage model is the reply to the customer who writes out of business hours
+type AwayMessage struct {
+	ID        int    `gorm:"primary_key"`
+	BotID     int    `gorm:"bot_id;not null" json:"-"`
+	Lang      string `gorm:"lang type:varchar(2);not null" json:"lang" binding:"required,max=2"`
+	Text      string `gorm:"text type:text;not null" json:"text" binding:"required,max=4096"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// AutoReplyRule model is the canned reply to the inbound messages matching the pattern
+type AutoReplyRule struct {
+	ID        int    `gorm:"primary_key" json:"id"`
+	BotID     int    `gorm:"bot_id;not null" json:"-"`
+	Position  int    `gorm:"position;not null" json:"-"`
+	Type      string `gorm:"type type:varchar(10);not null" json:"type" binding:"required,max=10"`
+	Pattern   string `gorm:"pattern type:varchar(255);not null" json:"pattern" binding:"required,max=255"`
+	Lang      string `gorm:"lang type:varchar(2)" json:"lang" binding:"max=2"`
+	Reply     string `gorm:"reply type:text;not null" json:"reply" binding:"required,max=4096"`
+	Forward   bool   `gorm:"forward;not null" json:"forward"`
+	Active    bool   `gorm:"active;not null" json:"active"`
+	Hits      int    `gorm:"hits;not null" json:"-"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// BlockedUser model is the Telegram user whose messages are not forwarded to MG
+type BlockedUser struct {
+	ID        int    `gorm:"primary_key"`
+	BotID     int    `gorm:"bot_id;not null" json:"-"`
+	UserID    int    `gorm:"user_id;not null" json:"userId" binding:"required,min=1"`
+	Comment   string `gorm:"comment type:varchar(255)" json:"comment" binding:"max=255"`
+	Hits      int    `gorm:"hits;not null" json:"-"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// FloodCounter model counts the messages of the Telegram user for the flood limit of the bot
+type FloodCounter struct {
+	ID         int        `gorm:"primary_key"`
+	BotID      int        `gorm:"bot_id;not null"`
+	UserID     int        `gorm:"user_id;not null"`
+	WindowAt   *time.Time `gorm:"window_at"`
+	Count      int        `gorm:"count;not null"`
+	MutedUntil *time.Time `gorm:"muted_until"`
+}
+
+// Campaign model is the broadcast message to all customers of the bot
+type Campaign struct {
+	ID         int    `gorm:"primary_key"`
+	BotID      int    `gorm:"bot_id;not null" json:"-"`
+	Name       string `gorm:"name type:varchar(100);not null" json:"name" binding:"required,max=100"`
+	Text       string `gorm:"text type:text" json:"text" binding:"max=4096"`
+	PhotoURL   string `gorm:"photo_url type:varchar(255)" json:"photoUrl" binding:"omitempty,url,max=255"`
+	Buttons    string `gorm:"buttons type:text" json:"buttons" binding:"max=2048"`
+	Status     string `gorm:"status type:varchar(10);not null" json:"-"`
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Stats      map[string]int `gorm:"-" json:"-"`
+}
+
+// CampaignRecipient model is the chat the campaign is sent to
+type CampaignRecipient struct {
+	ID         int    `gorm:"primary_key"`
+	CampaignID int    `gorm:"campaign_id;not null"`
+	ExternalID int64  `gorm:"external_id;not null"`
+	Status     string `gorm:"status type:varchar(10);not null"`
+	Error      string `gorm:"error type:varchar(255)"`
+	SentAt     *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// Rating model is the customer satisfaction score given when the dialog is closed
+type Rating struct {
+	ID         int    `gorm:"primary_key"`
+	BotID      int    `gorm:"bot_id;not null"`
+	ExternalID int64  `gorm:"external_id;not null"`
+	MessageID  int    `gorm:"message_id;not null"`
+	Score      int    `gorm:"score;not null"`
+	Comment    string `gorm:"comment type:text"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// AlbumMessage model maps the album photo or another part of the MG message sent to Telegram to the first message known to MG
+type AlbumMessage struct {
+	ID             int   `gorm:"primary_key"`
+	BotID          int   `gorm:"bot_id;not null"`
+	ExternalID     int64 `gorm:"external_id;not null"`
+	MessageID      int   `gorm:"message_id;not null"`
+	AlbumMessageID int   `gorm:"album_message_id;not null"`
+	CreatedAt      time.Time
+}
+
+// DeferredMessage model is the automated message postponed until the end of the quiet hours
+type DeferredMessage struct {
+	ID         int    `gorm:"primary_key"`
+	BotID      int    `gorm:"bot_id;not null"`
+	ExternalID int64  `gorm:"external_id;not null"`
+	Kind       string `gorm:"kind type:varchar(10);not null"`
+	Text       string `gorm:"text type:text"`
+	SendAt     time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
 //Bots list
 type Bots []Bot
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+var (
+	errNotifyRecipient = errors.New("either userId or customerExternalId is required")
+	errNotifyText      = errors.New("the notification must be from 1 to 4096 characters")
+)
+
+// NotifyRequest is the message pushed to the customer through the notification API
+type NotifyRequest struct {
+	Bot                string                 `json:"bot" binding:"required,max=40"`
+	UserID             int                    `json:"userId"`
+	CustomerExternalID string                 `json:"customerExternalId" binding:"max=255"`
+	Template           string                 `json:"template" binding:"required,max=4096"`
+	Data               map[string]interface{} `json:"data"`
+}
+
+// getUserID returns the Telegram user ID of the notification recipient
+func (r *NotifyRequest) getUserID() (int, error) {
+	if r.UserID > 0 {
+		return r.UserID, nil
+	}
+
+	if !strings.HasPrefix(r.CustomerExternalID, customerExternalIDPrefix) {
+		return 0, errNotifyRecipient
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(r.CustomerExternalID, customerExternalIDPrefix))
+	if err != nil || id <= 0 {
+		return 0, errNotifyRecipient
+	}
+
+	return id, nil
+}
+
+// render executes the notification template with the request data, the missing keys are errors
+func (r *NotifyRequest) render() (string, error) {
+	tpl, err := template.New("notification").Option("missingkey=error").Parse(r.Template)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, r.Data); err != nil {
+		return "", err
+	}
+
+	text := strings.TrimSpace(buf.String())
+	if text == "" || len([]rune(text)) > int(MaxCharsCount) {
+		return "", errNotifyText
+	}
+
+	return text, nil
+}
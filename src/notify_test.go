@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotify_getUserID(t *testing.T) {
+	id, err := (&NotifyRequest{UserID: 123}).getUserID()
+	require.NoError(t, err)
+	assert.Equal(t, 123, id)
+
+	id, err = (&NotifyRequest{CustomerExternalID: "telegram_456"}).getUserID()
+	require.NoError(t, err)
+	assert.Equal(t, 456, id)
+
+	_, err = (&NotifyRequest{CustomerExternalID: "crm_456"}).getUserID()
+	assert.Equal(t, errNotifyRecipient, err)
+
+	_, err = (&NotifyRequest{}).getUserID()
+	assert.Equal(t, errNotifyRecipient, err)
+}
+
+func TestNotify_render(t *testing.T) {
+	req := NotifyRequest{
+		Template: "Order {{.number}} has been shipped",
+		Data:     map[string]interface{}{"number": "C-1001"},
+	}
+
+	text, err := req.render()
+	require.NoError(t, err)
+	assert.Equal(t, "Order C-1001 has been shipped", text)
+
+	req.Data = nil
+	_, err = req.render()
+	assert.Error(t, err)
+
+	req = NotifyRequest{Template: strings.Repeat("a", 4097)}
+	_, err = req.render()
+	assert.Equal(t, errNotifyText, err)
+}
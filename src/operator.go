@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/retailcrm/mg-transport-api-client-go/v1"
+)
+
+// operatorActions maps the MG message type to the Telegram chat action shown while the message is sent, the MG webhooks
+// of the transport do not report the operator typing
+var operatorActions = map[string]string{
+	v1.MsgTypeImage: tgbotapi.ChatUploadPhoto,
+	v1.MsgTypeFile:  tgbotapi.ChatUploadDocument,
+}
+
+// getOperatorName returns the display name of the operator from the MG webhook
+func getOperatorName(user *v1.MessageDataUser) string {
+	if user == nil {
+		return ""
+	}
+
+	return strings.TrimSpace(user.FirstName + " " + user.LastName)
+}
+
+// renderOperatorTemplate executes the template configured for the bot with the operator name
+func renderOperatorTemplate(text, name string) (string, error) {
+	tpl, err := template.New("operator").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, map[string]string{"Name": name}); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// getOperatorJoinedText returns the message about the operator who joined the dialog
+func getOperatorJoinedText(b *Bot, name string) (string, error) {
+	if b.OperatorJoinedText == "" {
+		return getLocalizedTemplateMessage("operator_joined", map[string]interface{}{"Name": name}), nil
+	}
+
+	return renderOperatorTemplate(b.OperatorJoinedText, name)
+}
+
+// getChatAction returns the Telegram chat action shown to the customer while the message of the type is sent
+func getChatAction(msgType string) string {
+	return operatorActions[msgType]
+}
+
+// getOperatorSignature returns the operator signature in the format of the bot, empty if the signature is off
+func getOperatorSignature(b *Bot, name string) (string, error) {
+	if b.SignaturePosition == "" || name == "" {
+		return "", nil
+	}
+
+	if b.SignatureFormat == "" {
+		return getLocalizedTemplateMessage("operator_signature", map[string]interface{}{"Name": name}), nil
+	}
+
+	return renderOperatorTemplate(b.SignatureFormat, name)
+}
+
+// signText adds the signature to the text, the text is left as is if the signed one exceeds the limit
+func signText(text, signature, position string, limit int) string {
+	if signature == "" {
+		return text
+	}
+
+	res := signature
+	if text != "" {
+		switch position {
+		case SignatureAppend:
+			res = text + "\n\n" + signature
+		case SignaturePrepend:
+			res = signature + "\n\n" + text
+		default:
+			return text
+		}
+	}
+
+	if len([]rune(res)) > limit {
+		return text
+	}
+
+	return res
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/retailcrm/mg-transport-api-client-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOperator_getOperatorName(t *testing.T) {
+	assert.Equal(t, "Ann Smith", getOperatorName(&v1.MessageDataUser{FirstName: "Ann", LastName: "Smith"}))
+	assert.Equal(t, "Ann", getOperatorName(&v1.MessageDataUser{FirstName: "Ann"}))
+	assert.Equal(t, "", getOperatorName(nil))
+}
+
+func TestOperator_getOperatorJoinedText(t *testing.T) {
+	setLocale("en")
+
+	text, err := getOperatorJoinedText(&Bot{}, "Ann")
+	require.NoError(t, err)
+	assert.Equal(t, "Operator Ann joined the chat", text)
+
+	text, err = getOperatorJoinedText(&Bot{OperatorJoinedText: "{{.Name}} will help you"}, "Ann")
+	require.NoError(t, err)
+	assert.Equal(t, "Ann will help you", text)
+
+	_, err = renderOperatorTemplate("{{.Operator}} will help you", "Ann")
+	assert.Error(t, err)
+}
+
+func TestOperator_getChatAction(t *testing.T) {
+	assert.Equal(t, tgbotapi.ChatUploadPhoto, getChatAction(v1.MsgTypeImage))
+	assert.Equal(t, tgbotapi.ChatUploadDocument, getChatAction(v1.MsgTypeFile))
+	assert.Equal(t, "", getChatAction(v1.MsgTypeText))
+}
+
+func TestOperator_getOperatorSignature(t *testing.T) {
+	setLocale("en")
+
+	signature, err := getOperatorSignature(&Bot{}, "Ann")
+	require.NoError(t, err)
+	assert.Equal(t, "", signature)
+
+	signature, err = getOperatorSignature(&Bot{SignaturePosition: SignatureAppend}, "Ann")
+	require.NoError(t, err)
+	assert.Equal(t, "— Ann, support team", signature)
+
+	signature, err = getOperatorSignature(&Bot{SignaturePosition: SignatureAppend, SignatureFormat: "{{.Name}}:"}, "Ann")
+	require.NoError(t, err)
+	assert.Equal(t, "Ann:", signature)
+
+	signature, err = getOperatorSignature(&Bot{SignaturePosition: SignatureAppend}, "")
+	require.NoError(t, err)
+	assert.Equal(t, "", signature)
+}
+
+func TestOperator_signText(t *testing.T) {
+	assert.Equal(t, "Hello\n\n— Ann", signText("Hello", "— Ann", SignatureAppend, 4096))
+	assert.Equal(t, "Ann:\n\nHello", signText("Hello", "Ann:", SignaturePrepend, 4096))
+	assert.Equal(t, "— Ann", signText("", "— Ann", SignatureAppend, 1024))
+	assert.Equal(t, "Hello", signText("Hello", "", SignatureAppend, 4096))
+	assert.Equal(t, "Hello", signText("Hello", "— Ann", SignatureAppend, 10))
+}
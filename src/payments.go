@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/retailcrm/mg-transport-api-client-go/v1"
+)
+
+const (
+	// InvoicePayloadPrefix prefixes the CRM order number in the invoice payload
+	InvoicePayloadPrefix = "order:"
+	// MaxInvoiceTitleLength is the Telegram limit of the invoice title
+	MaxInvoiceTitleLength = 32
+	// MaxInvoiceDescriptionLength is the Telegram limit of the invoice description
+	MaxInvoiceDescriptionLength = 255
+)
+
+var errInvoiceCost = errors.New("the order has no cost to pay")
+
+// isPaid reports whether all the order payments are paid
+func isPaid(order *v1.MessageDataOrder) bool {
+	if len(order.Payments) == 0 {
+		return false
+	}
+
+	for _, v := range order.Payments {
+		if v.Status == nil || !v.Status.Payed {
+			return false
+		}
+	}
+
+	return true
+}
+
+// currencyExponents are the numbers of the decimal places of the currencies not having two of them, see
+// https://core.telegram.org/bots/payments/currencies.json
+var currencyExponents = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// getCurrencyExponent returns the number of the decimal places of the currency
+func getCurrencyExponent(currency string) int {
+	if exp, ok := currencyExponents[strings.ToUpper(currency)]; ok {
+		return exp
+	}
+
+	return 2
+}
+
+// getMinorUnits converts the amount to the smallest currency units
+func getMinorUnits(amount float32, currency string) int {
+	return int(math.Round(float64(amount) * math.Pow10(getCurrencyExponent(currency))))
+}
+
+// getMajorUnits converts the amount in the smallest currency units to the currency units
+func getMajorUnits(amount int, currency string) float32 {
+	return float32(float64(amount) / math.Pow10(getCurrencyExponent(currency)))
+}
+
+// getInvoicePrices returns the order lines for the amount to pay, the amount is used as a single line when the lines
+// do not add up to it, e.g. when the order is partially paid
+func getInvoicePrices(order *v1.MessageDataOrder, amount int) []tgbotapi.LabeledPrice {
+	var (
+		prices []tgbotapi.LabeledPrice
+		sum    int
+	)
+
+	for _, v := range order.Items {
+		if v.Price == nil || v.Price.Value == 0 {
+			continue
+		}
+
+		label, quantity := v.Name, float32(1)
+		if v.Quantity != nil && v.Quantity.Value != 0 {
+			quantity = v.Quantity.Value
+			if quantity != 1 {
+				label = fmt.Sprintf("%s × %v", v.Name, quantity)
+			}
+		}
+
+		lineAmount := getMinorUnits(v.Price.Value*quantity, order.Cost.Currency)
+		prices = append(prices, tgbotapi.LabeledPrice{Label: label, Amount: lineAmount})
+		sum += lineAmount
+	}
+
+	if order.Delivery != nil && order.Delivery.Price != nil && order.Delivery.Price.Value != 0 {
+		lineAmount := getMinorUnits(order.Delivery.Price.Value, order.Cost.Currency)
+		prices = append(prices, tgbotapi.LabeledPrice{Label: getLocalizedMessage("delivery"), Amount: lineAmount})
+		sum += lineAmount
+	}
+
+	if amount != sum {
+		label := getLocalizedMessage("order_total")
+		if amount != getMinorUnits(order.Cost.Value, order.Cost.Currency) {
+			label = getLocalizedMessage("order_balance")
+		}
+
+		prices = []tgbotapi.LabeledPrice{{Label: label, Amount: amount}}
+	}
+
+	return prices
+}
+
+// getOrderInvoice returns the invoice for the amount left to pay for the CRM order, the same amount is checked
+// before the checkout
+func getOrderInvoice(cid int64, order *v1.MessageDataOrder, orders OrderSource, providerToken string) (tgbotapi.InvoiceConfig, error) {
+	if order.Cost == nil || order.Number == "" {
+		return tgbotapi.InvoiceConfig{}, errInvoiceCost
+	}
+
+	crmOrder, err := orders.GetOrder(order.Number)
+	if err != nil {
+		logger.Errorf("GetOrder number: %s, err: %s", order.Number, err.Error())
+		return tgbotapi.InvoiceConfig{}, err
+	}
+
+	if crmOrder.FullPaidAt != "" || (crmOrder.Currency != "" && !strings.EqualFold(crmOrder.Currency, order.Cost.Currency)) {
+		return tgbotapi.InvoiceConfig{}, errInvoiceCost
+	}
+
+	return getInvoice(cid, order, crmOrder.TotalSumm-crmOrder.PrepaySum, providerToken)
+}
+
+// getInvoice returns the invoice for the amount of the order paid through the payment provider of the bot
+func getInvoice(cid int64, order *v1.MessageDataOrder, amount float32, providerToken string) (tgbotapi.InvoiceConfig, error) {
+	if order.Cost == nil || amount <= 0 || order.Cost.Currency == "" || order.Number == "" {
+		return tgbotapi.InvoiceConfig{}, errInvoiceCost
+	}
+
+	title := []rune(strings.TrimSpace(getLocalizedMessage("order") + " " + order.Number))
+	if len(title) > MaxInvoiceTitleLength {
+		title = title[:MaxInvoiceTitleLength]
+	}
+
+	var names []string
+	for _, v := range order.Items {
+		names = append(names, v.Name)
+	}
+
+	description := []rune(strings.Join(names, ", "))
+	if len(description) == 0 {
+		description = title
+	}
+
+	if len(description) > MaxInvoiceDescriptionLength {
+		description = append(description[:MaxInvoiceDescriptionLength-1], '…')
+	}
+
+	prices := getInvoicePrices(order, getMinorUnits(amount, order.Cost.Currency))
+
+	return tgbotapi.NewInvoice(
+		cid,
+		string(title),
+		string(description),
+		InvoicePayloadPrefix+order.Number,
+		providerToken,
+		"order",
+		strings.ToUpper(order.Cost.Currency),
+		&prices,
+	), nil
+}
+
+// getInvoiceOrderNumber returns the CRM order number from the invoice payload
+func getInvoiceOrderNumber(payload string) string {
+	if !strings.HasPrefix(payload, InvoicePayloadPrefix) {
+		return ""
+	}
+
+	return strings.TrimPrefix(payload, InvoicePayloadPrefix)
+}
+
+// getPreCheckoutError returns the key of the error refusing the checkout of the invoice for the CRM order, an empty key
+// means the invoice still matches the unpaid order, the currency is checked when the CRM returns it
+func getPreCheckoutError(order *crmOrder, amount int, currency string) string {
+	if order.FullPaidAt != "" || (order.PrepaySum > 0 && order.PrepaySum >= order.TotalSumm) {
+		return "error_order_paid"
+	}
+
+	if order.Currency != "" && !strings.EqualFold(order.Currency, currency) {
+		return "error_order_changed"
+	}
+
+	if getMinorUnits(order.TotalSumm-order.PrepaySum, currency) != amount {
+		return "error_order_changed"
+	}
+
+	return ""
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/h2non/gock"
+	"github.com/retailcrm/mg-transport-api-client-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getTestOrder() *v1.MessageDataOrder {
+	return &v1.MessageDataOrder{
+		Number: "1234C",
+		Cost:   &v1.MessageDataOrderCost{Value: 2350.5, Currency: "rub"},
+		Items: []v1.MessageDataOrderItem{
+			{
+				Name:     "Phone case",
+				Quantity: &v1.MessageDataOrderQuantity{Value: 2},
+				Price:    &v1.MessageDataOrderCost{Value: 500.25, Currency: "rub"},
+			},
+			{
+				Name:  "Charger",
+				Price: &v1.MessageDataOrderCost{Value: 1000, Currency: "rub"},
+			},
+		},
+		Delivery: &v1.MessageDataOrderDelivery{
+			Name:  "Courier",
+			Price: &v1.MessageDataOrderCost{Value: 350, Currency: "rub"},
+		},
+	}
+}
+
+func TestPayments_isPaid(t *testing.T) {
+	order := getTestOrder()
+	assert.False(t, isPaid(order))
+
+	order.Payments = []v1.MessageDataOrderPayment{
+		{Name: "Card", Status: &v1.MessageDataOrderPaymentStatus{Payed: true}},
+		{Name: "Cash", Status: &v1.MessageDataOrderPaymentStatus{Payed: false}},
+	}
+	assert.False(t, isPaid(order))
+
+	order.Payments[1].Status.Payed = true
+	assert.True(t, isPaid(order))
+}
+
+func TestPayments_getInvoicePrices(t *testing.T) {
+	setLocale("en")
+
+	order := getTestOrder()
+	assert.Equal(
+		t,
+		[]tgbotapi.LabeledPrice{
+			{Label: "Phone case × 2", Amount: 100050},
+			{Label: "Charger", Amount: 100000},
+			{Label: "Delivery", Amount: 35000},
+		},
+		getInvoicePrices(order, 235050),
+	)
+
+	order.Cost.Value = 2000
+	assert.Equal(t, []tgbotapi.LabeledPrice{{Label: "Order total", Amount: 200000}}, getInvoicePrices(order, 200000))
+	assert.Equal(t, []tgbotapi.LabeledPrice{{Label: "Amount due", Amount: 150000}}, getInvoicePrices(order, 150000))
+}
+
+func TestPayments_getMinorUnits(t *testing.T) {
+	assert.Equal(t, 235050, getMinorUnits(2350.5, "rub"))
+	assert.Equal(t, 1500, getMinorUnits(1500, "JPY"))
+	assert.Equal(t, 12345, getMinorUnits(12.345, "KWD"))
+
+	assert.Equal(t, float32(2350.5), getMajorUnits(235050, "RUB"))
+	assert.Equal(t, float32(1500), getMajorUnits(1500, "JPY"))
+	assert.Equal(t, float32(12.345), getMajorUnits(12345, "KWD"))
+}
+
+func TestPayments_getInvoice(t *testing.T) {
+	defer gock.Off()
+	setLocale("en")
+
+	invoice, err := getInvoice(1, getTestOrder(), 2350.5, "provider-token")
+	require.NoError(t, err)
+	assert.Equal(t, "Order 1234C", invoice.Title)
+	assert.Equal(t, "Phone case, Charger", invoice.Description)
+	assert.Equal(t, "RUB", invoice.Currency)
+	assert.Equal(t, "1234C", getInvoiceOrderNumber(invoice.Payload))
+
+	gock.New("https://api.telegram.org").
+		Post("/bot123123:Qwerty/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":123,"is_bot":true,"first_name":"Test","username":"TestBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot123123:Qwerty/sendInvoice").
+		BodyString(`provider_token=provider-token`).
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"message_id":5,"date":0,"chat":{"id":1,"type":"private"}}}`)
+
+	bot, err := tgbotapi.NewBotAPI("123123:Qwerty")
+	require.NoError(t, err)
+
+	msg, err := bot.Send(invoice)
+	require.NoError(t, err)
+	assert.Equal(t, 5, msg.MessageID)
+	assert.True(t, gock.IsDone())
+
+	_, err = getInvoice(1, &v1.MessageDataOrder{Number: "1"}, 100, "provider-token")
+	assert.Equal(t, errInvoiceCost, err)
+}
+
+type testOrders struct {
+	order crmOrder
+}
+
+func (o testOrders) GetOrder(number string) (*crmOrder, error) {
+	order := o.order
+	order.Currency = "RUB"
+
+	return &order, nil
+}
+
+func TestPayments_getOrderInvoicePrepaid(t *testing.T) {
+	setLocale("en")
+
+	orders := testOrders{crmOrder{ID: 25, TotalSumm: 2350.5, PrepaySum: 350.5}}
+
+	invoice, err := getOrderInvoice(1, getTestOrder(), orders, "provider-token")
+	require.NoError(t, err)
+	assert.Equal(t, []tgbotapi.LabeledPrice{{Label: "Amount due", Amount: 200000}}, *invoice.Prices)
+
+	order, _ := orders.GetOrder("1234C")
+	assert.Equal(t, "", getPreCheckoutError(order, (*invoice.Prices)[0].Amount, invoice.Currency))
+
+	orders.order.FullPaidAt = "2019-05-01 10:00:00"
+	_, err = getOrderInvoice(1, getTestOrder(), orders, "provider-token")
+	assert.Equal(t, errInvoiceCost, err)
+}
+
+func TestPayments_getInvoiceOrderNumber(t *testing.T) {
+	assert.Equal(t, "1234C", getInvoiceOrderNumber("order:1234C"))
+	assert.Equal(t, "", getInvoiceOrderNumber("1234C"))
+}
+
+func TestPayments_getPreCheckoutError(t *testing.T) {
+	order := &crmOrder{ID: 25, TotalSumm: 2350.5}
+	assert.Equal(t, "", getPreCheckoutError(order, 235050, "RUB"))
+	assert.Equal(t, "error_order_changed", getPreCheckoutError(order, 200000, "RUB"))
+
+	order.Currency = "RUB"
+	assert.Equal(t, "error_order_changed", getPreCheckoutError(order, 235050, "USD"))
+
+	order.PrepaySum = 2350.5
+	assert.Equal(t, "error_order_paid", getPreCheckoutError(order, 235050, "RUB"))
+
+	order.PrepaySum = 0
+	order.FullPaidAt = "2019-05-01 10:00:00"
+	assert.Equal(t, "error_order_paid", getPreCheckoutError(order, 235050, "RUB"))
+}
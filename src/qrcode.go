@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// qrQuietZone is the width of the light border around the symbol in modules
+const qrQuietZone = 4
+
+// qrVersion describes the symbol layout for error correction level M
+type qrVersion struct {
+	number     int
+	alignment  []int
+	ecPerBlock int
+	blocks     []int
+}
+
+// qrVersions holds the versions 1-10 which are enough to encode any t.me deep link
+var qrVersions = []qrVersion{
+	{1, nil, 10, []int{16}},
+	{2, []int{6, 18}, 16, []int{28}},
+	{3, []int{6, 22}, 26, []int{44}},
+	{4, []int{6, 26}, 18, []int{32, 32}},
+	{5, []int{6, 30}, 24, []int{43, 43}},
+	{6, []int{6, 34}, 16, []int{27, 27, 27, 27}},
+	{7, []int{6, 22, 38}, 18, []int{31, 31, 31, 31}},
+	{8, []int{6, 24, 42}, 22, []int{38, 38, 39, 39}},
+	{9, []int{6, 26, 46}, 22, []int{36, 36, 36, 37, 37}},
+	{10, []int{6, 28, 50}, 26, []int{43, 43, 43, 43, 44}},
+}
+
+var errQRCodeTooLong = errors.New("content is too long for a QR code")
+
+// qrCode is a QR code symbol, true stands for a dark module
+type qrCode struct {
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+// newQRCode encodes the content in byte mode with error correction level M
+func newQRCode(content string) (*qrCode, error) {
+	for _, v := range qrVersions {
+		if data, ok := v.encode([]byte(content)); ok {
+			q := newQRCodeGrid(v)
+			q.drawCodewords(v.interleave(data))
+
+			best, penalty := 0, -1
+			for mask := 0; mask < 8; mask++ {
+				q.applyMask(mask)
+				q.drawFormat(mask)
+				if p := q.penalty(); penalty < 0 || p < penalty {
+					best, penalty = mask, p
+				}
+				q.applyMask(mask)
+			}
+
+			q.applyMask(best)
+			q.drawFormat(best)
+
+			return q, nil
+		}
+	}
+
+	return nil, errQRCodeTooLong
+}
+
+func (v qrVersion) dataCapacity() (n int) {
+	for _, b := range v.blocks {
+		n += b
+	}
+
+	return
+}
+
+// encode builds the data codewords or reports that the content does not fit
+func (v qrVersion) encode(content []byte) ([]byte, bool) {
+	countBits := 8
+	if v.number >= 10 {
+		countBits = 16
+	}
+
+	capacity := v.dataCapacity() * 8
+	if 4+countBits+len(content)*8 > capacity {
+		return nil, false
+	}
+
+	var bb qrBitBuffer
+	bb.append(0x4, 4)
+	bb.append(len(content), countBits)
+	for _, b := range content {
+		bb.append(int(b), 8)
+	}
+
+	terminator := capacity - len(bb)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bb.append(0, terminator)
+	bb.append(0, (8-len(bb)%8)%8)
+
+	for pad := 0xEC; len(bb) < capacity; pad ^= 0xEC ^ 0x11 {
+		bb.append(pad, 8)
+	}
+
+	return bb.bytes(), true
+}
+
+// interleave splits the data into blocks, adds error correction and interleaves the codewords
+func (v qrVersion) interleave(data []byte) []byte {
+	divisor := qrReedSolomonDivisor(v.ecPerBlock)
+	dataBlocks := make([][]byte, len(v.blocks))
+	ecBlocks := make([][]byte, len(v.blocks))
+
+	offset := 0
+	for i, n := range v.blocks {
+		dataBlocks[i] = data[offset : offset+n]
+		ecBlocks[i] = qrReedSolomonRemainder(dataBlocks[i], divisor)
+		offset += n
+	}
+
+	var result []byte
+	for i := 0; i < v.blocks[len(v.blocks)-1]; i++ {
+		for _, b := range dataBlocks {
+			if i < len(b) {
+				result = append(result, b[i])
+			}
+		}
+	}
+
+	for i := 0; i < v.ecPerBlock; i++ {
+		for _, b := range ecBlocks {
+			result = append(result, b[i])
+		}
+	}
+
+	return result
+}
+
+func newQRCodeGrid(v qrVersion) *qrCode {
+	size := v.number*4 + 17
+	q := &qrCode{
+		size:     size,
+		modules:  make([][]bool, size),
+		function: make([][]bool, size),
+	}
+
+	for i := range q.modules {
+		q.modules[i] = make([]bool, size)
+		q.function[i] = make([]bool, size)
+	}
+
+	for i := 0; i < size; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+
+	q.drawFinder(3, 3)
+	q.drawFinder(size-4, 3)
+	q.drawFinder(3, size-4)
+
+	last := len(v.alignment) - 1
+	for i, x := range v.alignment {
+		for j, y := range v.alignment {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+
+			q.drawAlignment(x, y)
+		}
+	}
+
+	// reserve the format area, the actual bits are drawn after masking
+	q.drawFormat(0)
+
+	if v.number >= 7 {
+		rem := v.number
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+
+		bits := v.number<<12 | rem
+		for i := 0; i < 18; i++ {
+			bit := (bits>>uint(i))&1 != 0
+			a, b := size-11+i%3, i/3
+			q.set(a, b, bit)
+			q.set(b, a, bit)
+		}
+	}
+
+	return q
+}
+
+func (q *qrCode) set(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+func (q *qrCode) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || xx >= q.size || yy < 0 || yy >= q.size {
+				continue
+			}
+
+			dist := qrMax(qrAbs(dx), qrAbs(dy))
+			q.set(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (q *qrCode) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			q.set(x+dx, y+dy, qrMax(qrAbs(dx), qrAbs(dy)) != 1)
+		}
+	}
+}
+
+func (q *qrCode) drawFormat(mask int) {
+	// error correction level M is encoded as 00
+	data := mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool {
+		return (bits>>uint(i))&1 != 0
+	}
+
+	for i := 0; i <= 5; i++ {
+		q.set(8, i, bit(i))
+	}
+	q.set(8, 7, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		q.set(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(8, q.size-15+i, bit(i))
+	}
+	q.set(8, q.size-8, true)
+}
+
+func (q *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+
+				if !q.function[y][x] && i < len(data)*8 {
+					q.modules[y][x] = (data[i>>3]>>uint(7-i&7))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+func (q *qrCode) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+
+			if invert && !q.function[y][x] {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores the symbol with the rules of ISO/IEC 18004 to pick the best mask
+func (q *qrCode) penalty() int {
+	result, dark := 0, 0
+
+	for i := 0; i < q.size; i++ {
+		rowRun, colRun := 1, 1
+		for j := 0; j < q.size; j++ {
+			if q.modules[i][j] {
+				dark++
+			}
+
+			if j == 0 {
+				continue
+			}
+
+			if q.modules[i][j] == q.modules[i][j-1] {
+				rowRun++
+				if rowRun == 5 {
+					result += 3
+				} else if rowRun > 5 {
+					result++
+				}
+			} else {
+				rowRun = 1
+			}
+
+			if q.modules[j][i] == q.modules[j-1][i] {
+				colRun++
+				if colRun == 5 {
+					result += 3
+				} else if colRun > 5 {
+					result++
+				}
+			} else {
+				colRun = 1
+			}
+		}
+	}
+
+	for y := 0; y < q.size-1; y++ {
+		for x := 0; x < q.size-1; x++ {
+			c := q.modules[y][x]
+			if c == q.modules[y][x+1] && c == q.modules[y+1][x] && c == q.modules[y+1][x+1] {
+				result += 3
+			}
+		}
+	}
+
+	finderLike := [][]bool{
+		{true, false, true, true, true, false, true, false, false, false, false},
+		{false, false, false, false, true, false, true, true, true, false, true},
+	}
+	for i := 0; i < q.size; i++ {
+		for j := 0; j+11 <= q.size; j++ {
+			for _, p := range finderLike {
+				row, col := true, true
+				for k := range p {
+					row = row && q.modules[i][j+k] == p[k]
+					col = col && q.modules[j+k][i] == p[k]
+				}
+
+				if row {
+					result += 40
+				}
+
+				if col {
+					result += 40
+				}
+			}
+		}
+	}
+
+	total := q.size * q.size
+	result += qrAbs(dark*20-total*10) / total * 10
+
+	return result
+}
+
+// image renders the symbol with the quiet zone, each module takes scale pixels
+func (q *qrCode) image(scale int) image.Image {
+	side := (q.size + qrQuietZone*2) * scale
+	img := image.NewPaletted(
+		image.Rect(0, 0, side, side),
+		color.Palette{color.White, color.Black},
+	)
+
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if !q.modules[y][x] {
+				continue
+			}
+
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex((x+qrQuietZone)*scale+dx, (y+qrQuietZone)*scale+dy, 1)
+				}
+			}
+		}
+	}
+
+	return img
+}
+
+// svg renders the symbol with the quiet zone as a scalable image
+func (q *qrCode) svg() []byte {
+	side := q.size + qrQuietZone*2
+
+	var b bytes.Buffer
+	fmt.Fprintf(
+		&b,
+		`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+			`<rect width="100%%" height="100%%" fill="#ffffff"/><path fill="#000000" d="`,
+		side, side,
+	)
+
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.modules[y][x] {
+				fmt.Fprintf(&b, "M%d,%dh1v1h-1z", x+qrQuietZone, y+qrQuietZone)
+			}
+		}
+	}
+
+	b.WriteString(`"/></svg>`)
+
+	return b.Bytes()
+}
+
+type qrBitBuffer []bool
+
+func (bb *qrBitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*bb = append(*bb, (value>>uint(i))&1 != 0)
+	}
+}
+
+func (bb qrBitBuffer) bytes() []byte {
+	result := make([]byte, len(bb)/8)
+	for i, bit := range bb {
+		if bit {
+			result[i>>3] |= 1 << uint(7-i&7)
+		}
+	}
+
+	return result
+}
+
+func qrReedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = qrMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+
+		root = qrMultiply(root, 0x02)
+	}
+
+	return result
+}
+
+func qrReedSolomonRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+
+		for i, d := range divisor {
+			result[i] ^= qrMultiply(d, factor)
+		}
+	}
+
+	return result
+}
+
+func qrMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>uint(i))&1) * int(x)
+	}
+
+	return byte(z)
+}
+
+func qrAbs(x int) int {
+	if x < 0 {
+		return -x
+	}
+
+	return x
+}
+
+func qrMax(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
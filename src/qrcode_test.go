@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQRCode_newQRCode(t *testing.T) {
+	qr, err := newQRCode("https://t.me/TestBot?start=summer_sale")
+	require.NoError(t, err)
+	assert.Equal(t, 29, qr.size)
+
+	qr, err = newQRCode(getDeepLinkURL(strings.Repeat("b", 32), strings.Repeat("p", 64)))
+	require.NoError(t, err)
+	assert.Equal(t, 45, qr.size)
+
+	_, err = newQRCode(strings.Repeat("a", 214))
+	assert.Equal(t, errQRCodeTooLong, err)
+}
+
+// qrReferenceSymbols are the module matrices produced for the same content and mask by github.com/skip2/go-qrcode,
+// the mask is fixed because the encoders score the masks differently
+var qrReferenceSymbols = []struct {
+	content string
+	mask    int
+	modules []string
+}{
+	{"https://t.me/TestBot?start=summer_sale", 3, []string{
+		"#######.##..##..#..##.#######",
+		"#.....#.##.#....###...#.....#",
+		"#.###.#...#.##..#.....#.###.#",
+		"#.###.#.#.##.###.#..#.#.###.#",
+		"#.###.#..#....####.#..#.###.#",
+		"#.....#.....###....##.#.....#",
+		"#######.#.#.#.#.#.#.#.#######",
+		"........#.###..#.###.........",
+		"#.##.###..#..#...##.#.#..#.##",
+		"#.##....#.##.#..#..#.##.#...#",
+		"....###.####.#..#...###...##.",
+		"#..#.#..####...#...###.#....#",
+		"#.#...#.......#.##..#....##..",
+		"...#...###..#.#.####.#....###",
+		"###.####.....#..#.######..###",
+		".....#.#..#.#.#...####.#...#.",
+		"..#####..#.#..#.#..###.###.#.",
+		"...#...##.....##.##.#..#.###.",
+		"#...###.#.#..####.........#..",
+		"..#.#...##.####.######..#.#..",
+		".#..###......##.###########..",
+		"........##..#...#...#...#####",
+		"#######.###.#.#.#...#.#.##.#.",
+		"#.....#.#.#..##...#.#...##...",
+		"#.###.#..##......#..#####.#..",
+		"#.###.#.#....#.####.##.###..#",
+		"#.###.#.#..##.#...#..#....#.#",
+		"#.....#..##.#####..###..##.#.",
+		"#######.#..###.#..#####..#.#.",
+	}},
+	{"https://t.me/" + strings.Repeat("b", 32) + "?start=" + strings.Repeat("p", 64), 2, []string{
+		"#######..#...#####.#.#...#.#.##.##..#.#######",
+		"#.....#..##.##....#..#.#####.....#.#..#.....#",
+		"#.###.#.#.....#.#.#.........#.####.#..#.###.#",
+		"#.###.#.####.##.###.#.#.#......#...##.#.###.#",
+		"#.###.#.#..#..##.#.#######.##.#...###.#.###.#",
+		"#.....#.#..#.##.##..#...####.#........#.....#",
+		"#######.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#######",
+		"........#..##..##...#...#..##.###...#........",
+		"#.#####..####.#..#.######.#...##.#.#..#####..",
+		".###.....#.#....#.#..#..##.####..#.##...#.###",
+		".##.#.##.##.#.#...##.#..#.##.#...##.####...#.",
+		".#####....#.#...........##..#.#.##..#...###..",
+		".#.#####.#.###.#####..#.#.....##.##...#.....#",
+		".###....#..#.#........##...#.##.#..###.#..###",
+		"#.#.#####.#.##.#..##########.#...##...##..##.",
+		"##..##.#..#..#....##..#.#...#.#.##..#...###..",
+		"#..##.#.#.###.#####..#..#......#.#.#.....#...",
+		"##.##..#.#..##..##...#.#.#.#.####..###.#..###",
+		"....#######.#.....##########...##.##.###..##.",
+		".####....####...#.##....#...##.###.#...####..",
+		".##.######..#.#.###.#####....#.#.#.#######...",
+		"....#...##...#..##..#...##...##....##...###.#",
+		"....#.#.#....#....#.#.#.####.#....###.#.#.##.",
+		"#.#.#...#..####..##.#...#..##.###.#.#...###.#",
+		"#############.##..#.#####.#...##.##.######...",
+		".#####.#.#..##..#######.##.####.....#.....#.#",
+		"##...####.###.##..#..#....##.#...##..#.##..#.",
+		"###..#....##....##.#.#.####.#.#.##.#.###.##..",
+		"##..#.#.###.###.#.#......#....##.##.#.#.#..#.",
+		"#.##.#.##..#.####...#.#.##.#.##.#...#.#...###",
+		".#.#.###...###...###.#.#.###.#...##.#..#..##.",
+		".#.##....#..######.###.##...#.#.##.#..##.##..",
+		"#.###.#..#.....#.#.##...#........#..#...##...",
+		".#..##.#...#.#.#...##.#.##.#.###...##.#...###",
+		"....#.####.#..#.#.#..#.#.###...#..#.##.#..##.",
+		".####..###.#.###.##....##..##.####.##.##.##..",
+		"#..##.#####..#.#....#####....#.#.#..######...",
+		"........##.####...###...##.#.##....##...###.#",
+		"#######..#..##..##.##.#.###..#....###.#.#.##.",
+		"#.....#.##.#..#.#.###...#..##.###.###...###.#",
+		"#.###.#.#..##..###..#####.#...##...#######...",
+		"#.###.#.###...##..#..#..##.####....#....#.###",
+		"#.###.#.#..#...###......#.##.#...####......#.",
+		"#.....#..###..##..#.##.#....#.#.##..##.#.##..",
+		"#######.####.#..##.#.####.....##.##.####...#.",
+	}},
+}
+
+func TestQRCode_referenceSymbols(t *testing.T) {
+	for _, ref := range qrReferenceSymbols {
+		var q *qrCode
+		for _, v := range qrVersions {
+			if data, ok := v.encode([]byte(ref.content)); ok {
+				q = newQRCodeGrid(v)
+				q.drawCodewords(v.interleave(data))
+				q.applyMask(ref.mask)
+				q.drawFormat(ref.mask)
+				break
+			}
+		}
+		require.NotNil(t, q, ref.content)
+
+		modules := make([]string, q.size)
+		for y, row := range q.modules {
+			for _, dark := range row {
+				if dark {
+					modules[y] += "#"
+				} else {
+					modules[y] += "."
+				}
+			}
+		}
+
+		assert.Equal(t, ref.modules, modules, ref.content)
+	}
+}
+
+func TestQRCode_image(t *testing.T) {
+	qr, err := newQRCode("https://t.me/TestBot?start=summer_sale")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, qr.image(4)))
+
+	img, err := png.Decode(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, (29+qrQuietZone*2)*4, img.Bounds().Dx())
+
+	// top left module of the finder pattern is dark, quiet zone is light
+	r, _, _, _ := img.At(qrQuietZone*4, qrQuietZone*4).RGBA()
+	assert.Equal(t, uint32(0), r)
+	r, _, _, _ = img.At(0, 0).RGBA()
+	assert.Equal(t, uint32(0xffff), r)
+
+	assert.True(t, bytes.HasPrefix(qr.svg(), []byte("<svg")))
+}
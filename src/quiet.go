@@ -0,0 +1,139 @@
+package main
+
+import (
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	// QuietIdle is the pause between the checks for deferred messages
+	QuietIdle = time.Minute
+	// QuietLease is the time the deferred message claimed by the instance is not sent by the others
+	QuietLease = 10 * time.Minute
+)
+
+// isQuietTime reports whether the moment is within the quiet hours, the window may span midnight, t must be in the bot time
+// zone, Telegram does not pass the time zone of the customer
+func isQuietTime(from, to string, t time.Time) bool {
+	if from == "" || to == "" || from == to {
+		return false
+	}
+
+	now := t.Format("15:04")
+	if from < to {
+		return from <= now && now < to
+	}
+
+	return now >= from || now < to
+}
+
+// getQuietEnd returns the end of the quiet hours the moment belongs to
+func getQuietEnd(to string, t time.Time) time.Time {
+	end, err := time.Parse("15:04", to)
+	if err != nil {
+		return t
+	}
+
+	res := time.Date(t.Year(), t.Month(), t.Day(), end.Hour(), end.Minute(), 0, 0, t.Location())
+	if !res.After(t) {
+		res = res.AddDate(0, 0, 1)
+	}
+
+	return res
+}
+
+// silence sends the message without the sound on the customer device
+func silence(m tgbotapi.Chattable) tgbotapi.Chattable {
+	switch v := m.(type) {
+	case tgbotapi.MessageConfig:
+		v.DisableNotification = true
+		return v
+	case tgbotapi.PhotoConfig:
+		v.DisableNotification = true
+		return v
+	case tgbotapi.DocumentConfig:
+		v.DisableNotification = true
+		return v
+	case tgbotapi.InvoiceConfig:
+		v.DisableNotification = true
+		return v
+	case tgbotapi.MediaGroupConfig:
+		v.DisableNotification = true
+		return v
+	}
+
+	return m
+}
+
+// getDeferredMessage returns the deferred message to send
+func getDeferredMessage(d *DeferredMessage) tgbotapi.Chattable {
+	msg := tgbotapi.NewMessage(d.ExternalID, d.Text)
+	if d.Kind == DeferredKindRating {
+		msg.ReplyMarkup = getRatingKeyboard()
+	}
+
+	return msg
+}
+
+// deferMessage postpones the message until the end of the quiet hours of the bot
+func deferMessage(b *Bot, cid int64, kind, text string, now time.Time) error {
+	return createDeferredMessage(&DeferredMessage{
+		BotID:      b.ID,
+		ExternalID: cid,
+		Kind:       kind,
+		Text:       text,
+		SendAt:     getQuietEnd(b.QuietTo, now),
+	})
+}
+
+// quietWorker sends the deferred messages whose quiet hours are over, the messages failed to send are retried
+// on the transient errors only and dropped on the others, e.g. when the chat is not found
+func quietWorker() {
+	for {
+		messages := claimDueDeferredMessages(time.Now(), QuietLease)
+		for i := range messages {
+			err := sendDeferredMessage(&messages[i])
+			if err != nil {
+				logger.Errorf("quietWorker message: %d, err: %s", messages[i].ID, err.Error())
+			}
+
+			if isTransientError(err) {
+				continue
+			}
+
+			if err := deleteDeferredMessage(messages[i].ID); err != nil {
+				logger.Error(messages[i].ID, err)
+			}
+		}
+
+		time.Sleep(QuietIdle)
+	}
+}
+
+func sendDeferredMessage(d *DeferredMessage) error {
+	b := getBotByID(d.BotID)
+	if b.ID == 0 {
+		return nil
+	}
+
+	if chat := getChat(b.ID, d.ExternalID); chat.Blocked {
+		return nil
+	}
+
+	bot, err := tgbotapi.NewBotAPI(b.Token)
+	if err != nil {
+		return err
+	}
+
+	bot.Debug = config.Debug
+
+	_, err = bot.Send(getDeferredMessage(d))
+	if isChatUnreachableError(err) {
+		if e := setChatBlocked(b.ID, d.ExternalID, true); e != nil {
+			logger.Error(b.ID, d.ExternalID, e)
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuiet_isQuietTime(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2019, 4, 24, hour, min, 0, 0, time.UTC)
+	}
+
+	assert.False(t, isQuietTime("", "", at(3, 0)))
+	assert.False(t, isQuietTime("22:00", "22:00", at(22, 0)))
+
+	assert.True(t, isQuietTime("22:00", "08:00", at(3, 0)))
+	assert.True(t, isQuietTime("22:00", "08:00", at(22, 0)))
+	assert.False(t, isQuietTime("22:00", "08:00", at(8, 0)))
+	assert.False(t, isQuietTime("22:00", "08:00", at(12, 30)))
+
+	assert.True(t, isQuietTime("13:00", "14:00", at(13, 30)))
+	assert.False(t, isQuietTime("13:00", "14:00", at(14, 0)))
+}
+
+func TestQuiet_getQuietEnd(t *testing.T) {
+	loc := getLocation("Europe/Moscow")
+
+	assert.Equal(
+		t,
+		time.Date(2019, 4, 25, 8, 0, 0, 0, loc),
+		getQuietEnd("08:00", time.Date(2019, 4, 24, 23, 15, 0, 0, loc)),
+	)
+	assert.Equal(
+		t,
+		time.Date(2019, 4, 24, 8, 0, 0, 0, loc),
+		getQuietEnd("08:00", time.Date(2019, 4, 24, 3, 0, 0, 0, loc)),
+	)
+}
+
+func TestQuiet_silence(t *testing.T) {
+	m := silence(tgbotapi.NewMessage(1, "text"))
+	assert.True(t, m.(tgbotapi.MessageConfig).DisableNotification)
+
+	p := silence(tgbotapi.NewPhotoShare(1, "https://example.com/photo.jpg"))
+	assert.True(t, p.(tgbotapi.PhotoConfig).DisableNotification)
+
+	e := tgbotapi.NewEditMessageText(1, 2, "text")
+	assert.Equal(t, e, silence(e))
+}
+
+func TestQuiet_getDeferredMessage(t *testing.T) {
+	m := getDeferredMessage(&DeferredMessage{ExternalID: 1, Kind: DeferredKindRating, Text: "Rate us"}).(tgbotapi.MessageConfig)
+	assert.Equal(t, "Rate us", m.Text)
+	assert.Equal(t, getRatingKeyboard(), m.ReplyMarkup)
+
+	m = getDeferredMessage(&DeferredMessage{ExternalID: 1, Text: "Hi"}).(tgbotapi.MessageConfig)
+	assert.Nil(t, m.ReplyMarkup)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/retailcrm/mg-transport-api-client-go/v1"
+)
+
+const (
+	// RatingRequestCommand is sent by the operator to close the dialog and ask the customer to rate it, the MG
+	// webhooks of the transport report the messages only, not the closed dialogs
+	RatingRequestCommand = "/rate"
+	// RatingCallbackPrefix prefixes the score in the data of the rating buttons
+	RatingCallbackPrefix = "rate:"
+	// MaxRatingScore is the best customer satisfaction score
+	MaxRatingScore = 5
+)
+
+// RatingStats is the customer satisfaction of the bot
+type RatingStats struct {
+	Count  int
+	Scores [MaxRatingScore]int
+}
+
+// Average returns the average score
+func (s RatingStats) Average() string {
+	if s.Count == 0 {
+		return "—"
+	}
+
+	var sum int
+	for i, v := range s.Scores {
+		sum += (i + 1) * v
+	}
+
+	return fmt.Sprintf("%.1f", float64(sum)/float64(s.Count))
+}
+
+// Distribution returns the number of ratings per score
+func (s RatingStats) Distribution() string {
+	var res []string
+	for i, v := range s.Scores {
+		res = append(res, fmt.Sprintf("%d★ — %d", i+1, v))
+	}
+
+	return strings.Join(res, ", ")
+}
+
+// isRatingRequest reports whether the operator asks the customer to rate the dialog
+func isRatingRequest(b *Bot, cid int64, data *v1.WebhookData) bool {
+	return b.Csat &&
+		cid > 0 &&
+		data.Type == v1.MsgTypeText &&
+		strings.TrimSpace(data.Content) == RatingRequestCommand
+}
+
+// getRatingKeyboard returns the inline keyboard with the scores from 1 to 5
+func getRatingKeyboard() tgbotapi.InlineKeyboardMarkup {
+	var row []tgbotapi.InlineKeyboardButton
+	for i := 1; i <= MaxRatingScore; i++ {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(strconv.Itoa(i), fmt.Sprintf("%s%d", RatingCallbackPrefix, i)))
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(row)
+}
+
+// getRatingCallbackScore returns the score passed with the rating button
+func getRatingCallbackScore(data string) int {
+	if !strings.HasPrefix(data, RatingCallbackPrefix) {
+		return 0
+	}
+
+	score, err := strconv.Atoi(strings.TrimPrefix(data, RatingCallbackPrefix))
+	if err != nil || score < 1 || score > MaxRatingScore {
+		return 0
+	}
+
+	return score
+}
+
+// getRatingStars returns the score as stars
+func getRatingStars(score int) string {
+	return strings.Repeat("★", score) + strings.Repeat("☆", MaxRatingScore-score)
+}
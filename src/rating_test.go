@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/retailcrm/mg-transport-api-client-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRating_isRatingRequest(t *testing.T) {
+	b := &Bot{Csat: true}
+	assert.True(t, isRatingRequest(b, 1, &v1.WebhookData{Type: v1.MsgTypeText, Content: " /rate "}))
+	assert.False(t, isRatingRequest(b, 1, &v1.WebhookData{Type: v1.MsgTypeText, Content: "/rate later"}))
+	assert.False(t, isRatingRequest(b, -100, &v1.WebhookData{Type: v1.MsgTypeText, Content: "/rate"}))
+
+	b.Csat = false
+	assert.False(t, isRatingRequest(b, 1, &v1.WebhookData{Type: v1.MsgTypeText, Content: "/rate"}))
+}
+
+func TestRating_getRatingCallbackScore(t *testing.T) {
+	assert.Equal(t, 4, getRatingCallbackScore("rate:4"))
+	assert.Equal(t, 0, getRatingCallbackScore("rate:6"))
+	assert.Equal(t, 0, getRatingCallbackScore("rate:0"))
+	assert.Equal(t, 0, getRatingCallbackScore("order:4"))
+}
+
+func TestRating_getRatingKeyboard(t *testing.T) {
+	keyboard := getRatingKeyboard()
+	assert.Len(t, keyboard.InlineKeyboard, 1)
+	assert.Len(t, keyboard.InlineKeyboard[0], MaxRatingScore)
+	assert.Equal(t, "rate:5", *keyboard.InlineKeyboard[0][4].CallbackData)
+}
+
+func TestRating_RatingStats(t *testing.T) {
+	var stats RatingStats
+	assert.Equal(t, "—", stats.Average())
+
+	stats = RatingStats{Count: 4, Scores: [MaxRatingScore]int{0, 0, 1, 1, 2}}
+	assert.Equal(t, "4.2", stats.Average())
+	assert.Equal(t, "1★ — 0, 2★ — 0, 3★ — 1, 4★ — 1, 5★ — 2", stats.Distribution())
+	assert.Equal(t, "★★★☆☆", getRatingStars(3))
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/retailcrm/api-client-go/v5"
+	"github.com/retailcrm/mg-transport-api-client-go/v1"
+)
+
+// FileSource returns the MG files attached to the message, it is implemented by the MG client
+type FileSource interface {
+	GetFile(request string) (v1.FullFileResponse, int, error)
+}
+
+// OfferSource returns the CRM offers of the product the order button is attached to
+type OfferSource interface {
+	GetOffers(productID int) ([]v5.Offer, error)
+}
+
+// OrderSource returns the CRM order the invoice is issued for
+type OrderSource interface {
+	GetOrder(number string) (*crmOrder, error)
+}
+
+// RenderContext is the chat and the bot settings the MG message is rendered with
+type RenderContext struct {
+	ChatID            int64
+	Signature         string
+	SignaturePosition string
+	PaymentToken      string
+	RequestPhone      bool
+	Files             FileSource
+	Offers            OfferSource
+	Orders            OrderSource
+}
+
+// Renderer renders the MG message into the Telegram messages sent to the customer one after another
+type Renderer interface {
+	Render(data *v1.WebhookData, ctx *RenderContext) ([]tgbotapi.Chattable, error)
+}
+
+type textRenderer struct{}
+
+type productRenderer struct{}
+
+type orderRenderer struct{}
+
+type imageRenderer struct{}
+
+type fileRenderer struct{}
+
+// renderers maps the MG message type to its renderer
+var renderers = map[string]Renderer{
+	v1.MsgTypeText:    textRenderer{},
+	v1.MsgTypeProduct: productRenderer{},
+	v1.MsgTypeOrder:   orderRenderer{},
+	v1.MsgTypeImage:   imageRenderer{},
+	v1.MsgTypeFile:    fileRenderer{},
+}
+
+// getRenderer returns the renderer of the MG message type, nil if the type is not supported
+func getRenderer(msgType string) Renderer {
+	return renderers[msgType]
+}
+
+// isPhoneRequest reports whether the operator asks the customer to share the phone
+func (ctx *RenderContext) isPhoneRequest(data *v1.WebhookData) bool {
+	return ctx.RequestPhone &&
+		ctx.ChatID > 0 &&
+		data.Type == v1.MsgTypeText &&
+		strings.TrimSpace(data.Content) == PhoneRequestCommand
+}
+
+func (textRenderer) Render(data *v1.WebhookData, ctx *RenderContext) ([]tgbotapi.Chattable, error) {
+	if ctx.isPhoneRequest(data) {
+		return []tgbotapi.Chattable{getPhoneRequest(ctx.ChatID)}, nil
+	}
+
+	text := signText(data.Content, ctx.Signature, ctx.SignaturePosition, int(MaxCharsCount))
+	if text == "" {
+		return nil, nil
+	}
+
+	m, err := textMessage(ctx.ChatID, replaceMarkdownSymbols(text), data.QuoteExternalID)
+	if err != nil {
+		return nil, err
+	}
+
+	return []tgbotapi.Chattable{m}, nil
+}
+
+func (productRenderer) Render(data *v1.WebhookData, ctx *RenderContext) ([]tgbotapi.Chattable, error) {
+	if data.Product == nil {
+		return nil, nil
+	}
+
+	m, err := textMessage(ctx.ChatID, getProductMessage(data.Product), data.QuoteExternalID)
+	if err != nil {
+		return nil, err
+	}
+
+	if mc, ok := m.(tgbotapi.MessageConfig); ok && ctx.ChatID > 0 && data.Product.ID != 0 && ctx.Offers != nil {
+		offers, err := ctx.Offers.GetOffers(int(data.Product.ID))
+		if err != nil {
+			logger.Errorf("GetOffers product: %d, err: %s", data.Product.ID, err.Error())
+		}
+
+		if len(offers) > 0 {
+			mc.ReplyMarkup = getOrderKeyboard(offers)
+			m = mc
+		}
+	}
+
+	return []tgbotapi.Chattable{m}, nil
+}
+
+func (orderRenderer) Render(data *v1.WebhookData, ctx *RenderContext) ([]tgbotapi.Chattable, error) {
+	if data.Order == nil {
+		return nil, nil
+	}
+
+	if ctx.PaymentToken != "" && ctx.ChatID > 0 && ctx.Orders != nil && !isPaid(data.Order) {
+		if invoice, err := getOrderInvoice(ctx.ChatID, data.Order, ctx.Orders, ctx.PaymentToken); err == nil {
+			return []tgbotapi.Chattable{quote(invoice, data.QuoteExternalID)}, nil
+		}
+	}
+
+	m, err := textMessage(ctx.ChatID, getOrderMessage(data.Order), data.QuoteExternalID)
+	if err != nil {
+		return nil, err
+	}
+
+	return []tgbotapi.Chattable{m}, nil
+}
+
+func (imageRenderer) Render(data *v1.WebhookData, ctx *RenderContext) ([]tgbotapi.Chattable, error) {
+	if data.Items == nil {
+		return nil, nil
+	}
+
+	d := *data
+	d.Content = signText(d.Content, ctx.Signature, ctx.SignaturePosition, MaxCaptionLength)
+
+	m, err := photoMessage(d, ctx.Files, ctx.ChatID)
+	if err != nil || m == nil {
+		return nil, err
+	}
+
+	return []tgbotapi.Chattable{quote(m, data.QuoteExternalID)}, nil
+}
+
+func (fileRenderer) Render(data *v1.WebhookData, ctx *RenderContext) ([]tgbotapi.Chattable, error) {
+	if data.Items == nil {
+		return nil, nil
+	}
+
+	var res []tgbotapi.Chattable
+	for _, v := range *data.Items {
+		m, err := documentMessage(v, ctx.Files, ctx.ChatID)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(res) == 0 {
+			m = quote(m, data.QuoteExternalID)
+		}
+
+		res = append(res, m)
+	}
+
+	return res, nil
+}
+
+// quote sends the message as the reply to the quoted Telegram message, the quote of the other transport is ignored
+func quote(m tgbotapi.Chattable, quoteExternalID string) tgbotapi.Chattable {
+	qid, err := strconv.Atoi(quoteExternalID)
+	if err != nil || qid == 0 {
+		return m
+	}
+
+	switch v := m.(type) {
+	case tgbotapi.MessageConfig:
+		v.ReplyToMessageID = qid
+		return v
+	case tgbotapi.PhotoConfig:
+		v.ReplyToMessageID = qid
+		return v
+	case tgbotapi.DocumentConfig:
+		v.ReplyToMessageID = qid
+		return v
+	case tgbotapi.InvoiceConfig:
+		v.ReplyToMessageID = qid
+		return v
+	case tgbotapi.MediaGroupConfig:
+		v.ReplyToMessageID = qid
+		return v
+	}
+
+	return m
+}
+
+func getProductMessage(product *v1.MessageDataProduct) string {
+	mb := fmt.Sprintf("*%s*\n", replaceMarkdownSymbols(product.Name))
+
+	if product.Cost != nil && product.Cost.Value != 0 {
+		mb += fmt.Sprintf(
+			"\n%s: %s\n",
+			getLocalizedMessage("item_cost"),
+			getLocalizedTemplateMessage(
+				"cost_currency",
+				map[string]interface{}{
+					"Amount":   product.Cost.Value,
+					"Currency": currency[strings.ToLower(product.Cost.Currency)],
+				},
+			),
+		)
+	}
+
+	if product.Url != "" {
+		mb += replaceMarkdownSymbols(product.Url)
+	} else {
+		mb += replaceMarkdownSymbols(product.Img)
+	}
+
+	return mb
+}
+
+func getOrderMessage(dataOrder *v1.MessageDataOrder) string {
+	mb := "*" + getLocalizedMessage("order")
+
+	if dataOrder.Number != "" {
+		mb += " " + replaceMarkdownSymbols(dataOrder.Number)
+	}
+
+	if dataOrder.Date != "" {
+		mb += fmt.Sprintf(" (%s)", dataOrder.Date)
+	}
+	mb += "*\n"
+	if len(dataOrder.Items) > 0 {
+		mb += "\n"
+		for k, v := range dataOrder.Items {
+			mb += fmt.Sprintf(
+				"%d. %s",
+				k+1,
+				replaceMarkdownSymbols(v.Name),
+			)
+
+			if v.Quantity != nil {
+				if v.Quantity.Value != 0 {
+					mb += fmt.Sprintf(
+						" _%v_",
+						v.Quantity.Value,
+					)
+				}
+			}
+
+			if v.Price != nil {
+				if val, ok := currency[strings.ToLower(v.Price.Currency)]; ok {
+					mb += fmt.Sprintf(
+						" _x %s_\n",
+						getLocalizedTemplateMessage(
+							"cost_currency",
+							map[string]interface{}{
+								"Amount":   v.Price.Value,
+								"Currency": val,
+							},
+						),
+					)
+				}
+			} else {
+				mb += "\n"
+			}
+		}
+	}
+
+	if dataOrder.Delivery != nil {
+		if dataOrder.Delivery.Name != "" {
+			mb += fmt.Sprintf(
+				"\n*%s:*\n%s",
+				getLocalizedMessage("delivery"),
+				replaceMarkdownSymbols(dataOrder.Delivery.Name),
+			)
+		}
+
+		if dataOrder.Delivery.Price != nil {
+			if val, ok := currency[strings.ToLower(dataOrder.Delivery.Price.Currency)]; ok && dataOrder.Delivery.Price.Value != 0 {
+				mb += fmt.Sprintf(
+					"; %s",
+					getLocalizedTemplateMessage(
+						"cost_currency",
+						map[string]interface{}{
+							"Amount":   dataOrder.Delivery.Price.Value,
+							"Currency": val,
+						},
+					),
+				)
+			}
+		}
+
+		if dataOrder.Delivery.Address != "" {
+			mb += ";\n" + replaceMarkdownSymbols(dataOrder.Delivery.Address)
+		}
+
+		if dataOrder.Delivery.Comment != "" {
+			mb += ";\n" + replaceMarkdownSymbols(dataOrder.Delivery.Comment)
+		}
+
+		mb += "\n"
+	}
+
+	if len(dataOrder.Payments) > 0 {
+		mb += fmt.Sprintf(
+			"\n*%s:*\n",
+			getLocalizedMessage("payment"),
+		)
+		for _, v := range dataOrder.Payments {
+			mb += replaceMarkdownSymbols(v.Name)
+
+			if v.Amount != nil {
+				if val, ok := currency[strings.ToLower(v.Amount.Currency)]; ok && v.Amount.Value != 0 {
+					mb += fmt.Sprintf(
+						"; %s",
+						getLocalizedTemplateMessage(
+							"cost_currency",
+							map[string]interface{}{
+								"Amount":   v.Amount.Value,
+								"Currency": val,
+							},
+						),
+					)
+				}
+			}
+
+			if v.Status != nil && v.Status.Name != "" {
+				mb += fmt.Sprintf(
+					" (%s)",
+					replaceMarkdownSymbols(v.Status.Name),
+				)
+			}
+
+			mb += "\n"
+		}
+	}
+
+	if dataOrder.Cost != nil {
+		if val, ok := currency[strings.ToLower(dataOrder.Cost.Currency)]; ok && dataOrder.Cost.Value != 0 {
+			mb += fmt.Sprintf(
+				"\n%s: %s",
+				getLocalizedMessage("order_total"),
+				getLocalizedTemplateMessage(
+					"cost_currency",
+					map[string]interface{}{
+						"Amount":   dataOrder.Cost.Value,
+						"Currency": val,
+					},
+				),
+			)
+		}
+	}
+
+	return mb
+}
+
+func photoMessage(webhookData v1.WebhookData, files FileSource, cid int64) (chattable tgbotapi.Chattable, err error) {
+	items := *webhookData.Items
+
+	if len(items) == 1 {
+		v := items
+
+		file, _, err := files.GetFile(v[0].ID)
+		if err != nil {
+			return chattable, err
+		}
+
+		msg := tgbotapi.NewPhotoUpload(cid, nil)
+		msg.FileID = file.Url
+		msg.UseExisting = true
+		msg.Caption = webhookData.Content
+
+		chattable = msg
+	} else if len(items) > 1 {
+		var it []interface{}
+
+		for _, v := range items {
+			file, _, err := files.GetFile(v.ID)
+			if err != nil {
+				logger.Errorf(
+					"GetFile request fileID: %s, err: %s",
+					v.ID, err.Error(),
+				)
+				continue
+			}
+
+			ip := tgbotapi.NewInputMediaPhoto(file.Url)
+			ip.Caption = webhookData.Content
+			it = append(it, ip)
+		}
+
+		chattable = tgbotapi.NewMediaGroup(cid, it)
+	}
+
+	return
+}
+
+// remoteFile downloads the MG file once Telegram starts reading it, so the files of the message are fetched one by one
+// while they are sent
+type remoteFile struct {
+	url  string
+	body io.ReadCloser
+}
+
+func (f *remoteFile) Read(p []byte) (int, error) {
+	if f.body == nil {
+		res, err := http.Get(f.url)
+		if err != nil {
+			return 0, err
+		}
+
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			return 0, fmt.Errorf("file download status: %d", res.StatusCode)
+		}
+
+		f.body = res.Body
+	}
+
+	return f.body.Read(p)
+}
+
+// Close closes the download if it was started
+func (f *remoteFile) Close() error {
+	if f.body == nil {
+		return nil
+	}
+
+	return f.body.Close()
+}
+
+// closeRendered closes the download of the document, it is called once the message is sent or failed
+func closeRendered(m tgbotapi.Chattable) {
+	doc, ok := m.(tgbotapi.DocumentConfig)
+	if !ok {
+		return
+	}
+
+	if file, ok := doc.File.(tgbotapi.FileReader); ok {
+		if c, ok := file.Reader.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+func documentMessage(item v1.FileItem, files FileSource, cid int64) (chattable tgbotapi.Chattable, err error) {
+	file, _, err := files.GetFile(item.ID)
+	if err != nil {
+		return chattable, err
+	}
+
+	tt := tgbotapi.FileReader{
+		Name:   item.Caption,
+		Reader: &remoteFile{url: file.Url},
+		Size:   int64(item.Size),
+	}
+
+	chattable = tgbotapi.NewDocumentUpload(cid, tt)
+	return
+}
+
+func textMessage(cid int64, mb string, quoteExternalID string) (chattable tgbotapi.Chattable, err error) {
+	var qid int
+	m := tgbotapi.NewMessage(cid, mb)
+
+	if quoteExternalID != "" {
+		qid, err = strconv.Atoi(quoteExternalID)
+		if err != nil {
+			return
+		}
+		m.ReplyToMessageID = qid
+	}
+
+	m.ParseMode = "Markdown"
+
+	chattable = m
+	return
+}
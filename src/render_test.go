@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/h2non/gock"
+	"github.com/retailcrm/api-client-go/v5"
+	"github.com/retailcrm/mg-transport-api-client-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files of the renderers")
+
+// goldenDir is relative to the repository root the tests are run from
+const goldenDir = "src/testdata/render"
+
+type testFiles struct{}
+
+func (testFiles) GetFile(request string) (v1.FullFileResponse, int, error) {
+	return v1.FullFileResponse{ID: request, Url: "https://s3.example.com/" + request}, 200, nil
+}
+
+type testOffers struct{}
+
+func (testOffers) GetOffers(productID int) ([]v5.Offer, error) {
+	return []v5.Offer{{ID: productID*10 + 1, Name: "Red"}, {ID: productID*10 + 2, Name: "Blue"}}, nil
+}
+
+type renderCase struct {
+	name string
+	data v1.WebhookData
+	ctx  RenderContext
+}
+
+func getRenderCases() []renderCase {
+	items := []v1.FileItem{
+		{ID: "file1", Size: 7, Caption: "invoice.pdf"},
+		{ID: "file2", Size: 7, Caption: "contract.pdf"},
+	}
+
+	return []renderCase{
+		{
+			name: "text",
+			data: v1.WebhookData{Type: v1.MsgTypeText, Content: "Hello *world*", QuoteExternalID: "12"},
+			ctx:  RenderContext{ChatID: 1, Signature: "— Anna", SignaturePosition: SignatureAppend},
+		},
+		{
+			name: "phone_request",
+			data: v1.WebhookData{Type: v1.MsgTypeText, Content: "/phone"},
+			ctx:  RenderContext{ChatID: 1, RequestPhone: true},
+		},
+		{
+			name: "product",
+			data: v1.WebhookData{Type: v1.MsgTypeProduct, Product: &v1.MessageDataProduct{
+				ID:   42,
+				Name: "Phone_case",
+				Cost: &v1.MessageDataOrderCost{Value: 500, Currency: "rub"},
+				Url:  "https://example.com/phone-case",
+			}},
+			ctx: RenderContext{ChatID: 1},
+		},
+		{
+			name: "product_group",
+			data: v1.WebhookData{Type: v1.MsgTypeProduct, Product: &v1.MessageDataProduct{
+				ID:   42,
+				Name: "Phone case",
+				Img:  "https://example.com/phone-case.jpg",
+			}},
+			ctx: RenderContext{ChatID: -100},
+		},
+		{
+			name: "order",
+			data: v1.WebhookData{Type: v1.MsgTypeOrder, Order: getTestOrder()},
+			ctx:  RenderContext{ChatID: 1},
+		},
+		{
+			name: "order_invoice",
+			data: v1.WebhookData{Type: v1.MsgTypeOrder, Order: getTestOrder()},
+			ctx:  RenderContext{ChatID: 1, PaymentToken: "provider-token", Orders: testOrders{crmOrder{TotalSumm: 2350.5}}},
+		},
+		{
+			name: "order_invoice_prepaid",
+			data: v1.WebhookData{Type: v1.MsgTypeOrder, Order: getTestOrder()},
+			ctx:  RenderContext{ChatID: 1, PaymentToken: "provider-token", Orders: testOrders{crmOrder{TotalSumm: 2350.5, PrepaySum: 350.5}}},
+		},
+		{
+			name: "image",
+			data: v1.WebhookData{Type: v1.MsgTypeImage, Content: "Look", Items: &[]v1.FileItem{items[0]}},
+			ctx:  RenderContext{ChatID: 1, Signature: "— Anna", SignaturePosition: SignaturePrepend},
+		},
+		{
+			name: "album",
+			data: v1.WebhookData{Type: v1.MsgTypeImage, Items: &items},
+			ctx:  RenderContext{ChatID: 1},
+		},
+		{
+			name: "file",
+			data: v1.WebhookData{Type: v1.MsgTypeFile, Items: &items},
+			ctx:  RenderContext{ChatID: 1},
+		},
+		{
+			name: "image_quote",
+			data: v1.WebhookData{Type: v1.MsgTypeImage, Items: &[]v1.FileItem{items[0]}, QuoteExternalID: "12"},
+			ctx:  RenderContext{ChatID: 1},
+		},
+		{
+			name: "album_quote",
+			data: v1.WebhookData{Type: v1.MsgTypeImage, Items: &items, QuoteExternalID: "12"},
+			ctx:  RenderContext{ChatID: 1},
+		},
+		{
+			name: "file_quote",
+			data: v1.WebhookData{Type: v1.MsgTypeFile, Items: &items, QuoteExternalID: "12"},
+			ctx:  RenderContext{ChatID: 1},
+		},
+		{
+			name: "order_invoice_quote",
+			data: v1.WebhookData{Type: v1.MsgTypeOrder, Order: getTestOrder(), QuoteExternalID: "12"},
+			ctx:  RenderContext{ChatID: 1, PaymentToken: "provider-token", Orders: testOrders{crmOrder{TotalSumm: 2350.5}}},
+		},
+	}
+}
+
+// getGoldenOperation returns the send operation with the method name, the uploaded files are replaced with their names
+func getGoldenOperation(m tgbotapi.Chattable) map[string]interface{} {
+	if doc, ok := m.(tgbotapi.DocumentConfig); ok {
+		if file, ok := doc.File.(tgbotapi.FileReader); ok {
+			doc.File = tgbotapi.FileReader{Name: file.Name, Size: file.Size}
+			m = doc
+		}
+	}
+
+	return map[string]interface{}{"type": fmt.Sprintf("%T", m), "config": m}
+}
+
+func TestRender_golden(t *testing.T) {
+	for _, tc := range getRenderCases() {
+		t.Run(tc.name, func(t *testing.T) {
+			res := map[string][]map[string]interface{}{}
+			for _, lang := range languages {
+				setLocale(lang)
+
+				ctx := tc.ctx
+				ctx.Files = testFiles{}
+				ctx.Offers = testOffers{}
+
+				messages, err := getRenderer(tc.data.Type).Render(&tc.data, &ctx)
+				require.NoError(t, err)
+
+				for _, m := range messages {
+					res[lang] = append(res[lang], getGoldenOperation(m))
+				}
+			}
+
+			actual, err := json.MarshalIndent(res, "", "  ")
+			require.NoError(t, err)
+
+			path := filepath.Join(goldenDir, tc.name+".golden")
+			if *updateGolden {
+				require.NoError(t, ioutil.WriteFile(path, append(actual, '\n'), 0644))
+			}
+
+			expected, err := ioutil.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, string(expected), string(actual)+"\n")
+		})
+	}
+}
+
+func TestRender_getRenderer(t *testing.T) {
+	assert.Nil(t, getRenderer(v1.MsgTypeAudio))
+
+	messages, err := getRenderer(v1.MsgTypeText).Render(&v1.WebhookData{Type: v1.MsgTypeText}, &RenderContext{ChatID: 1})
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+
+	ctx := &RenderContext{ChatID: 1, RequestPhone: true}
+	assert.True(t, ctx.isPhoneRequest(&v1.WebhookData{Type: v1.MsgTypeText, Content: " /phone "}))
+	assert.False(t, ctx.isPhoneRequest(&v1.WebhookData{Type: v1.MsgTypeProduct, Content: "/phone"}))
+
+	ctx.ChatID = -100
+	assert.False(t, ctx.isPhoneRequest(&v1.WebhookData{Type: v1.MsgTypeText, Content: "/phone"}))
+}
+
+func TestRender_remoteFile(t *testing.T) {
+	defer gock.Off()
+	gock.New("https://s3.example.com").Get("/file").Reply(200).BodyString("content")
+	gock.New("https://s3.example.com").Get("/missing").Reply(404)
+
+	f := &remoteFile{url: "https://s3.example.com/file"}
+	assert.Nil(t, f.body, "nothing is downloaded before the file is read")
+
+	data, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+	assert.NoError(t, f.Close())
+
+	_, err = ioutil.ReadAll(&remoteFile{url: "https://s3.example.com/missing"})
+	assert.Error(t, err)
+}
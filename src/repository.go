@@ -1,6 +1,9 @@
 package main
 
 import (
+	"database/sql"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/jinzhu/gorm"
@@ -43,6 +46,17 @@ func (c *Connection) saveConnectionByClientID() error {
 	return orm.DB.Model(c).Where("client_id = ?", c.ClientID).Update(c).Error
 }
 
+func (c *Connection) setNotifyToken(token string) error {
+	return orm.DB.Model(c).Update("notify_token", token).Error
+}
+
+func (c *Connection) getBotByName(name string) *Bot {
+	var bot Bot
+	orm.DB.First(&bot, "connection_id = ? AND name = ?", c.ID, strings.TrimPrefix(name, "@"))
+
+	return &bot
+}
+
 func (c *Connection) createBot(b Bot) error {
 	return orm.DB.Model(c).Association("Bots").Append(&b).Error
 }
@@ -59,6 +73,13 @@ func getBotByToken(token string) (*Bot, error) {
 	return &bot, nil
 }
 
+func getBotByID(id int) *Bot {
+	var bot Bot
+	orm.DB.First(&bot, "id = ?", id)
+
+	return &bot
+}
+
 func (b *Bot) save() error {
 	return orm.DB.Save(b).Error
 }
@@ -122,3 +143,701 @@ func getUserByExternalID(eid int) *User {
 func (u *User) Expired(updateInterval int) bool {
 	return time.Now().After(u.UpdatedAt.Add(time.Hour * time.Duration(updateInterval)))
 }
+
+func getChat(botID int, externalID int64) *Chat {
+	var chat Chat
+	orm.DB.First(&chat, "bot_id = ? AND external_id = ?", botID, externalID)
+
+	return &chat
+}
+
+// upsertChat creates the chat with the given columns or updates them in the existing one
+func upsertChat(botID int, externalID int64, columns map[string]interface{}) error {
+	names := make([]string, 0, len(columns))
+	for name := range columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := []interface{}{botID, externalID}
+	set := make([]string, 0, len(names)+1)
+	for _, name := range names {
+		values = append(values, columns[name])
+		set = append(set, name+" = excluded."+name)
+	}
+	values = append(values, time.Now())
+	set = append(set, "updated_at = excluded.updated_at")
+
+	return orm.DB.Exec(
+		"INSERT INTO chat (bot_id, external_id, "+strings.Join(names, ", ")+", updated_at) "+
+			"VALUES (?"+strings.Repeat(", ?", len(names)+2)+") "+
+			"ON CONFLICT (bot_id, external_id) DO UPDATE SET "+strings.Join(set, ", "),
+		values...,
+	).Error
+}
+
+// updateChat updates the given columns of the known chat and leaves the unknown chats alone
+func updateChat(botID int, externalID int64, columns map[string]interface{}) error {
+	updates := map[string]interface{}{"updated_at": time.Now()}
+	for name, value := range columns {
+		updates[name] = value
+	}
+
+	return orm.DB.Table("chat").Where("bot_id = ? AND external_id = ?", botID, externalID).Updates(updates).Error
+}
+
+func setChatBlocked(botID int, externalID int64, blocked bool) error {
+	return upsertChat(botID, externalID, map[string]interface{}{"blocked": blocked})
+}
+
+func saveChat(botID int, externalID int64) error {
+	return orm.DB.Exec(
+		"INSERT INTO chat (bot_id, external_id) VALUES (?, ?) ON CONFLICT (bot_id, external_id) DO NOTHING",
+		botID,
+		externalID,
+	).Error
+}
+
+func setChatOptedOut(botID int, externalID int64, optedOut bool) error {
+	return upsertChat(botID, externalID, map[string]interface{}{"opted_out": optedOut})
+}
+
+func getChatByMigratedToID(botID int, migratedToID int64) *Chat {
+	var chat Chat
+	orm.DB.First(&chat, "bot_id = ? AND migrated_to_id = ?", botID, migratedToID)
+
+	return &chat
+}
+
+func getChatByCustomerExtID(botID int, customerExtID string) *Chat {
+	var chat Chat
+	orm.DB.First(&chat, "bot_id = ? AND customer_ext_id = ?", botID, customerExtID)
+
+	return &chat
+}
+
+func (c *Chat) setCustomer() error {
+	return upsertChat(c.BotID, c.ExternalID, map[string]interface{}{
+		"customer_id":     c.CustomerID,
+		"customer_ext_id": c.CustomerExtID,
+		"first_name":      c.FirstName,
+		"last_name":       c.LastName,
+		"phone":           c.Phone,
+	})
+}
+
+func setChatPhoneAskedAt(botID int, externalID int64) error {
+	return upsertChat(botID, externalID, map[string]interface{}{"phone_asked_at": time.Now()})
+}
+
+func setChatMigratedTo(botID int, externalID, migratedToID int64) error {
+	return upsertChat(botID, externalID, map[string]interface{}{"migrated_to_id": migratedToID})
+}
+
+func setChatStartPayload(botID int, externalID int64, payload string) error {
+	return upsertChat(botID, externalID, map[string]interface{}{"start_payload": payload})
+}
+
+func setChatAwaySentAt(botID int, externalID int64, sentAt time.Time) error {
+	return upsertChat(botID, externalID, map[string]interface{}{"away_sent_at": sentAt})
+}
+
+func (b *Bot) getPayloadSources() []PayloadSource {
+	var sources []PayloadSource
+	orm.DB.Where("bot_id = ?", b.ID).Order("prefix").Find(&sources)
+
+	return sources
+}
+
+func (b *Bot) createPayloadSource(ps PayloadSource) error {
+	return orm.DB.Model(b).Association("PayloadSources").Append(&ps).Error
+}
+
+func (b *Bot) deletePayloadSource(id int) error {
+	return orm.DB.Delete(PayloadSource{}, "bot_id = ? AND id = ?", b.ID, id).Error
+}
+
+func (b *Bot) getDeepLinks() []DeepLink {
+	var links []DeepLink
+	orm.DB.Where("bot_id = ?", b.ID).Order("id").Find(&links)
+	for i := range links {
+		links[i].URL = getDeepLinkURL(b.Name, links[i].Payload)
+	}
+
+	return links
+}
+
+func (b *Bot) createDeepLink(dl DeepLink) error {
+	return orm.DB.Model(b).Association("DeepLinks").Append(&dl).Error
+}
+
+func (b *Bot) deleteDeepLink(id int) error {
+	return orm.DB.Delete(DeepLink{}, "bot_id = ? AND id = ?", b.ID, id).Error
+}
+
+func (c *Connection) getDeepLink(id int) (*DeepLink, *Bot) {
+	var (
+		link DeepLink
+		bot  Bot
+	)
+
+	orm.DB.First(&link, "id = ?", id)
+	if link.ID != 0 {
+		orm.DB.First(&bot, "id = ? AND connection_id = ?", link.BotID, c.ID)
+	}
+
+	return &link, &bot
+}
+
+func (b *Bot) getCommands() []BotCommand {
+	var commands []BotCommand
+	orm.DB.Where("bot_id = ?", b.ID).Order("name, lang").Find(&commands)
+
+	return commands
+}
+
+func (b *Bot) saveCommand(cmd BotCommand) error {
+	return orm.DB.Exec(
+		"INSERT INTO bot_command (bot_id, name, lang, description, reply, forward) "+
+			"VALUES (?, ?, ?, ?, ?, ?) "+
+			"ON CONFLICT (bot_id, name, lang) DO UPDATE SET "+
+			"description = excluded.description, reply = excluded.reply, forward = excluded.forward, updated_at = ?",
+		b.ID,
+		cmd.Name,
+		cmd.Lang,
+		cmd.Description,
+		cmd.Reply,
+		cmd.Forward,
+		time.Now(),
+	).Error
+}
+
+func (b *Bot) deleteCommand(id int) error {
+	return orm.DB.Delete(BotCommand{}, "bot_id = ? AND id = ?", b.ID, id).Error
+}
+
+func (b *Bot) getBusinessHours() []BusinessHours {
+	var hours []BusinessHours
+	orm.DB.Where("bot_id = ?", b.ID).Order("weekday, opens").Find(&hours)
+
+	return hours
+}
+
+func (b *Bot) createBusinessHours(bh BusinessHours) error {
+	return orm.DB.Model(b).Association("BusinessHours").Append(&bh).Error
+}
+
+func (b *Bot) deleteBusinessHours(id int) error {
+	return orm.DB.Delete(BusinessHours{}, "bot_id = ? AND id = ?", b.ID, id).Error
+}
+
+func (b *Bot) getHolidays() []Holiday {
+	var holidays []Holiday
+	orm.DB.Where("bot_id = ?", b.ID).Order("date").Find(&holidays)
+
+	return holidays
+}
+
+func (b *Bot) createHoliday(h Holiday) error {
+	return orm.DB.Model(b).Association("Holidays").Append(&h).Error
+}
+
+func (b *Bot) deleteHoliday(id int) error {
+	return orm.DB.Delete(Holiday{}, "bot_id = ? AND id = ?", b.ID, id).Error
+}
+
+func (b *Bot) getAwayMessages() []AwayMessage {
+	var messages []AwayMessage
+	orm.DB.Where("bot_id = ?", b.ID).Order("lang").Find(&messages)
+
+	return messages
+}
+
+func (b *Bot) saveAwayMessage(am AwayMessage) error {
+	return orm.DB.Exec(
+		"INSERT INTO away_message (bot_id, lang, text) "+
+			"VALUES (?, ?, ?) "+
+			"ON CONFLICT (bot_id, lang) DO UPDATE SET "+
+			"text = excluded.text, updated_at = ?",
+		b.ID,
+		am.Lang,
+		am.Text,
+		time.Now(),
+	).Error
+}
+
+func (b *Bot) deleteAwayMessage(id int) error {
+	return orm.DB.Delete(AwayMessage{}, "bot_id = ? AND id = ?", b.ID, id).Error
+}
+
+func (b *Bot) getRules() []AutoReplyRule {
+	var rules []AutoReplyRule
+	orm.DB.Where("bot_id = ?", b.ID).Order("position, id").Find(&rules)
+
+	return rules
+}
+
+func (b *Bot) saveRule(r AutoReplyRule) error {
+	if r.ID != 0 {
+		return orm.DB.Model(&AutoReplyRule{}).Where("bot_id = ? AND id = ?", b.ID, r.ID).Updates(map[string]interface{}{
+			"type":    r.Type,
+			"pattern": r.Pattern,
+			"lang":    r.Lang,
+			"reply":   r.Reply,
+			"forward": r.Forward,
+			"active":  r.Active,
+		}).Error
+	}
+
+	var position struct{ Max int }
+	err := orm.DB.Model(&AutoReplyRule{}).Select("coalesce(max(position), 0) as max").Where("bot_id = ?", b.ID).Scan(&position).Error
+	if err != nil {
+		return err
+	}
+
+	r.Position = position.Max + 1
+
+	return orm.DB.Model(b).Association("Rules").Append(&r).Error
+}
+
+func (b *Bot) deleteRule(id int) error {
+	return orm.DB.Delete(AutoReplyRule{}, "bot_id = ? AND id = ?", b.ID, id).Error
+}
+
+func (b *Bot) setRuleActive(id int, active bool) error {
+	return orm.DB.Model(&AutoReplyRule{}).Where("bot_id = ? AND id = ?", b.ID, id).Update("active", active).Error
+}
+
+// moveRule swaps the rule with the previous or the next one and renumbers the evaluation order
+func (b *Bot) moveRule(id int, up bool) error {
+	rules := b.getRules()
+	for i := range rules {
+		if rules[i].ID != id {
+			continue
+		}
+
+		j := i + 1
+		if up {
+			j = i - 1
+		}
+
+		if j < 0 || j >= len(rules) {
+			return nil
+		}
+
+		rules[i], rules[j] = rules[j], rules[i]
+		break
+	}
+
+	tx := orm.DB.Begin()
+	for k, v := range rules {
+		err := tx.Model(&AutoReplyRule{}).Where("id = ?", v.ID).Update("position", k+1).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
+
+func incrementRuleHits(id int) error {
+	return orm.DB.Exec("UPDATE auto_reply_rule SET hits = hits + 1 WHERE id = ?", id).Error
+}
+
+func getFloodCounter(botID, userID int) *FloodCounter {
+	var counter FloodCounter
+	orm.DB.First(&counter, "bot_id = ? AND user_id = ?", botID, userID)
+
+	return &counter
+}
+
+// countUserMessage counts the message of the user during the current minute and returns the count
+func countUserMessage(botID, userID int, now time.Time) (int, error) {
+	var count int
+	err := orm.DB.Raw(
+		"INSERT INTO flood_counter (bot_id, user_id, window_at, count) "+
+			"VALUES (?, ?, ?, 1) "+
+			"ON CONFLICT (bot_id, user_id) DO UPDATE SET "+
+			"count = CASE WHEN flood_counter.window_at > ? THEN flood_counter.count + 1 ELSE 1 END, "+
+			"window_at = CASE WHEN flood_counter.window_at > ? THEN flood_counter.window_at ELSE excluded.window_at END "+
+			"RETURNING count",
+		botID,
+		userID,
+		now,
+		now.Add(-time.Minute),
+		now.Add(-time.Minute),
+	).Row().Scan(&count)
+
+	return count, err
+}
+
+func setUserMutedUntil(botID, userID int, mutedUntil time.Time) error {
+	return orm.DB.Exec(
+		"INSERT INTO flood_counter (bot_id, user_id, muted_until) "+
+			"VALUES (?, ?, ?) "+
+			"ON CONFLICT (bot_id, user_id) DO UPDATE SET "+
+			"muted_until = excluded.muted_until",
+		botID,
+		userID,
+		mutedUntil,
+	).Error
+}
+
+func incrementBlockedMessages(botID int) error {
+	return orm.DB.Exec("UPDATE bot SET blocked_messages = blocked_messages + 1 WHERE id = ?", botID).Error
+}
+
+func getBlockedUser(botID, userID int) *BlockedUser {
+	var user BlockedUser
+	orm.DB.First(&user, "bot_id = ? AND user_id = ?", botID, userID)
+
+	return &user
+}
+
+func incrementBlockedUserHits(id int) error {
+	return orm.DB.Exec("UPDATE blocked_user SET hits = hits + 1 WHERE id = ?", id).Error
+}
+
+func (b *Bot) getBlockedUsers() []BlockedUser {
+	var users []BlockedUser
+	orm.DB.Where("bot_id = ?", b.ID).Order("created_at").Find(&users)
+
+	return users
+}
+
+func (b *Bot) blockUser(bu BlockedUser) error {
+	return orm.DB.Exec(
+		"INSERT INTO blocked_user (bot_id, user_id, comment) "+
+			"VALUES (?, ?, ?) "+
+			"ON CONFLICT (bot_id, user_id) DO UPDATE SET "+
+			"comment = excluded.comment, updated_at = ?",
+		b.ID,
+		bu.UserID,
+		bu.Comment,
+		time.Now(),
+	).Error
+}
+
+func (b *Bot) unblockUser(userID int) error {
+	return orm.DB.Delete(BlockedUser{}, "bot_id = ? AND user_id = ?", b.ID, userID).Error
+}
+
+func (b *Bot) deleteBlockedUser(id int) error {
+	return orm.DB.Delete(BlockedUser{}, "bot_id = ? AND id = ?", b.ID, id).Error
+}
+
+func (b *Bot) getCampaigns() []Campaign {
+	var campaigns []Campaign
+	orm.DB.Where("bot_id = ?", b.ID).Order("id desc").Find(&campaigns)
+	for i := range campaigns {
+		campaigns[i].Stats = getCampaignStats(campaigns[i].ID)
+	}
+
+	return campaigns
+}
+
+func (b *Bot) getCampaign(id int) *Campaign {
+	var campaign Campaign
+	orm.DB.First(&campaign, "bot_id = ? AND id = ?", b.ID, id)
+
+	return &campaign
+}
+
+func (b *Bot) createCampaign(c Campaign) error {
+	c.Status = CampaignStatusDraft
+
+	return orm.DB.Model(b).Association("Campaigns").Append(&c).Error
+}
+
+func (b *Bot) deleteCampaign(id int) error {
+	return orm.DB.Delete(Campaign{}, "bot_id = ? AND id = ? AND status <> ?", b.ID, id, CampaignStatusRunning).Error
+}
+
+// start adds the private chats of the bot which have not opted out to the draft campaign and runs it,
+// the chats are known since the customer has written to the bot after the campaigns were deployed
+func (c *Campaign) start() error {
+	tx := orm.DB.Begin()
+	if c.Status == CampaignStatusDraft {
+		err := tx.Exec(
+			"INSERT INTO campaign_recipient (campaign_id, external_id) "+
+				"SELECT ?, external_id FROM chat "+
+				"WHERE bot_id = ? AND external_id > 0 AND NOT blocked AND NOT opted_out AND migrated_to_id IS NULL",
+			c.ID,
+			c.BotID,
+		).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		err = tx.Model(c).Update("started_at", time.Now()).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	err := tx.Model(c).Update("status", CampaignStatusRunning).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+func (c *Campaign) setStatus(status string) error {
+	update := map[string]interface{}{"status": status}
+	if status == CampaignStatusFinished {
+		update["finished_at"] = time.Now()
+	}
+
+	return orm.DB.Model(c).Updates(update).Error
+}
+
+func getRunningCampaigns() []Campaign {
+	var campaigns []Campaign
+	orm.DB.Where("status = ?", CampaignStatusRunning).Order("id").Find(&campaigns)
+
+	return campaigns
+}
+
+func getCampaignStatus(id int) string {
+	var campaign Campaign
+	orm.DB.Select("status").First(&campaign, "id = ?", id)
+
+	return campaign.Status
+}
+
+// claimPendingRecipients marks the pending recipients as being sent by this instance and returns them,
+// the recipients claimed by the instance which has stopped before the lease ended are claimed again
+func (c *Campaign) claimPendingRecipients(limit int, now time.Time, lease time.Duration) []CampaignRecipient {
+	var recipients []CampaignRecipient
+	orm.DB.Raw(
+		"UPDATE campaign_recipient SET status = ?, updated_at = ? WHERE id IN ("+
+			"SELECT id FROM campaign_recipient WHERE campaign_id = ? "+
+			"AND (status = ? OR (status = ? AND updated_at < ?)) "+
+			"ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED"+
+			") RETURNING *",
+		RecipientStatusSending,
+		now,
+		c.ID,
+		RecipientStatusPending,
+		RecipientStatusSending,
+		now.Add(-lease),
+		limit,
+	).Scan(&recipients)
+
+	return recipients
+}
+
+// hasUnsentRecipients reports whether some recipients are still pending or being sent by another instance
+func (c *Campaign) hasUnsentRecipients() bool {
+	var count int
+	orm.DB.Model(&CampaignRecipient{}).
+		Where("campaign_id = ? AND status IN (?)", c.ID, []string{RecipientStatusPending, RecipientStatusSending}).
+		Count(&count)
+
+	return count > 0
+}
+
+func (r *CampaignRecipient) setStatus(status, e string) error {
+	if len(e) > 255 {
+		e = e[:255]
+	}
+
+	return orm.DB.Model(r).Updates(map[string]interface{}{
+		"status":  status,
+		"error":   e,
+		"sent_at": time.Now(),
+	}).Error
+}
+
+func getCampaignStats(id int) map[string]int {
+	stats := map[string]int{}
+	rows, err := orm.DB.Raw(
+		"SELECT status, count(*) FROM campaign_recipient WHERE campaign_id = ? GROUP BY status", id,
+	).Rows()
+	if err != nil {
+		logger.Error(err)
+		return stats
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			status string
+			count  int
+		)
+
+		if err := rows.Scan(&status, &count); err == nil {
+			if status == RecipientStatusSending {
+				status = RecipientStatusPending
+			}
+
+			stats[status] += count
+			stats["total"] += count
+		}
+	}
+
+	return stats
+}
+
+// createRating stores the score tapped on the rating keyboard, the repeated taps on the same keyboard are ignored
+// and reported with created set to false
+func createRating(r *Rating) (created bool, err error) {
+	err = orm.DB.Raw(
+		"INSERT INTO rating (bot_id, external_id, message_id, score) "+
+			"VALUES (?, ?, ?, ?) "+
+			"ON CONFLICT (bot_id, external_id, message_id) WHERE message_id <> 0 DO NOTHING "+
+			"RETURNING id",
+		r.BotID,
+		r.ExternalID,
+		r.MessageID,
+		r.Score,
+	).Row().Scan(&r.ID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func setRatingComment(id int, comment string) error {
+	return orm.DB.Model(&Rating{ID: id}).Update("comment", comment).Error
+}
+
+func setChatLang(botID int, externalID int64, lang string) error {
+	return upsertChat(botID, externalID, map[string]interface{}{"lang": lang})
+}
+
+func setChatRatingID(botID int, externalID int64, ratingID int) error {
+	return upsertChat(botID, externalID, map[string]interface{}{"rating_id": ratingID})
+}
+
+func (b *Bot) getRatingStats() RatingStats {
+	var stats RatingStats
+	rows, err := orm.DB.Raw(
+		"SELECT score, count(*) FROM rating WHERE bot_id = ? GROUP BY score", b.ID,
+	).Rows()
+	if err != nil {
+		logger.Error(err)
+		return stats
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var score, count int
+
+		if err := rows.Scan(&score, &count); err == nil && score >= 1 && score <= MaxRatingScore {
+			stats.Scores[score-1] = count
+			stats.Count += count
+		}
+	}
+
+	return stats
+}
+
+// setChatInbound stores the customer message and reports whether it starts a new dialog
+func setChatInbound(botID int, externalID int64, name, text string) (bool, error) {
+	now := time.Now()
+	isNew := isNewDialog(getChat(botID, externalID), now)
+
+	err := orm.DB.Exec(
+		"INSERT INTO chat (bot_id, external_id, name, last_message, last_inbound_at) "+
+			"VALUES (?, ?, ?, ?, ?) "+
+			"ON CONFLICT (bot_id, external_id) DO UPDATE SET "+
+			"name = excluded.name, last_message = excluded.last_message, "+
+			"last_inbound_at = excluded.last_inbound_at, dialog_closed = false, "+
+			"alert_sent_at = CASE WHEN ? THEN NULL ELSE chat.alert_sent_at END, updated_at = ?",
+		botID,
+		externalID,
+		name,
+		text,
+		now,
+		isNew,
+		now,
+	).Error
+
+	return isNew, err
+}
+
+func setChatOutbound(botID int, externalID int64) error {
+	return updateChat(botID, externalID, map[string]interface{}{"last_reply_at": time.Now()})
+}
+
+func setChatDialogClosed(botID int, externalID int64) error {
+	return updateChat(botID, externalID, map[string]interface{}{"dialog_closed": true, "operator": ""})
+}
+
+func setChatOperator(botID int, externalID int64, name string) error {
+	return upsertChat(botID, externalID, map[string]interface{}{"operator": name})
+}
+
+func setChatAlertSentAt(botID int, externalID int64) error {
+	return updateChat(botID, externalID, map[string]interface{}{"alert_sent_at": time.Now()})
+}
+
+// claimUnansweredChats returns the open chats whose last customer message got no reply in time, one alert per wait,
+// the messages received before the alerts were turned on are skipped,
+// the chats are locked for the lease so that the other instances do not alert about them at the same time
+func claimUnansweredChats(now time.Time, lease time.Duration) []Chat {
+	var chats []Chat
+	orm.DB.Raw(
+		"UPDATE chat SET alert_locked_at = ? WHERE id IN ("+
+			"SELECT chat.id FROM chat JOIN bot ON bot.id = chat.bot_id "+
+			"JOIN connection ON connection.id = bot.connection_id "+
+			"WHERE connection.active AND bot.staff_chat_id <> 0 AND bot.unanswered_minutes > 0 "+
+			"AND NOT chat.dialog_closed AND chat.last_inbound_at > bot.staff_since "+
+			"AND chat.last_inbound_at < now() - bot.unanswered_minutes * interval '1 minute' "+
+			"AND (chat.last_reply_at IS NULL OR chat.last_reply_at < chat.last_inbound_at) "+
+			"AND (chat.alert_sent_at IS NULL OR chat.alert_sent_at < coalesce(chat.last_reply_at, '-infinity')) "+
+			"AND (chat.alert_locked_at IS NULL OR chat.alert_locked_at < ?) "+
+			"ORDER BY chat.id FOR UPDATE OF chat SKIP LOCKED"+
+			") RETURNING *",
+		now,
+		now.Add(-lease),
+	).Scan(&chats)
+
+	return chats
+}
+
+func createDeferredMessage(d *DeferredMessage) error {
+	return orm.DB.Create(d).Error
+}
+
+// claimDueDeferredMessages returns the deferred messages to send, their sending is postponed for the lease
+// so that the other instances skip them and the message failed to send is retried after the lease
+func claimDueDeferredMessages(now time.Time, lease time.Duration) []DeferredMessage {
+	var messages []DeferredMessage
+	orm.DB.Raw(
+		"UPDATE deferred_message SET send_at = ? WHERE id IN ("+
+			"SELECT id FROM deferred_message WHERE send_at <= ? ORDER BY id FOR UPDATE SKIP LOCKED"+
+			") RETURNING *",
+		now.Add(lease),
+		now,
+	).Scan(&messages)
+
+	return messages
+}
+
+func deleteDeferredMessage(id int) error {
+	return orm.DB.Delete(&DeferredMessage{ID: id}).Error
+}
+
+func createAlbumMessage(m *AlbumMessage) error {
+	return orm.DB.Create(m).Error
+}
+
+// getQuotedMessageID returns the message known to MG for the quoted Telegram message
+func getQuotedMessageID(botID int, externalID int64, messageID int) int {
+	var m AlbumMessage
+	orm.DB.First(&m, "bot_id = ? AND external_id = ? AND message_id = ?", botID, externalID, messageID)
+
+	if m.ID == 0 {
+		return messageID
+	}
+
+	return m.AlbumMessageID
+}
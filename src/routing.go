@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"image/png"
@@ -13,6 +14,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/h2non/filetype"
 	filetypes "github.com/h2non/filetype/matchers"
@@ -83,6 +85,7 @@ func addBotHandler(c *gin.Context) {
 
 	b.Channel = data.ChannelID
 	b.Lang = "en"
+	b.GroupPolicy = GroupPolicyIgnore
 
 	hashSettings, err := getChannelSettingsHash()
 	if err != nil {
@@ -139,169 +142,1768 @@ func settingsHandler(c *gin.Context) {
 	}
 
 	bots := p.getBotsByClientID()
+	for i := range bots {
+		bots[i].PayloadSources = bots[i].getPayloadSources()
+		bots[i].DeepLinks = bots[i].getDeepLinks()
+		bots[i].Commands = bots[i].getCommands()
+		bots[i].BusinessHours = bots[i].getBusinessHours()
+		bots[i].Holidays = bots[i].getHolidays()
+		bots[i].AwayMessages = bots[i].getAwayMessages()
+		bots[i].Rules = bots[i].getRules()
+		bots[i].BlockedUsers = bots[i].getBlockedUsers()
+		bots[i].Campaigns = bots[i].getCampaigns()
+		bots[i].RatingStats = bots[i].getRatingStats()
+	}
+
+	res := struct {
+		Conn          *Connection
+		Bots          Bots
+		Locale        map[string]interface{}
+		Year          int
+		LangCode      []string
+		GroupPolicies map[string]string
+		Weekdays      []string
+		RuleTypes     map[string]string
+		Statuses      map[string]string
+		Signatures    map[string]string
+		EditFallbacks map[string]string
+		DelFallbacks  map[string]string
+	}{
+		p,
+		bots,
+		getLocale(),
+		time.Now().Year(),
+		languages,
+		getGroupPolicies(),
+		getWeekdays(),
+		getRuleTypes(),
+		getCampaignStatuses(),
+		getSignaturePositions(),
+		getEditFallbacks(),
+		getDeleteFallbacks(),
+	}
+
+	c.HTML(http.StatusOK, "form", &res)
+}
+
+func saveHandler(c *gin.Context) {
+	conn := c.MustGet("connection").(Connection)
+	_, err, code := getAPIClient(conn.APIURL, conn.APIKEY)
+	if err != nil {
+		if code == http.StatusInternalServerError {
+			c.Error(err)
+		} else {
+			c.AbortWithStatusJSON(code, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	err = conn.saveConnectionByClientID()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func createHandler(c *gin.Context) {
+	conn := c.MustGet("connection").(Connection)
+
+	cl := getConnectionByURL(conn.APIURL)
+	if cl.ID != 0 {
+		c.AbortWithStatusJSON(BadRequest("connection_already_created"))
+		return
+	}
+
+	client, err, code := getAPIClient(conn.APIURL, conn.APIKEY)
+	if err != nil {
+		if code == http.StatusInternalServerError {
+			c.Error(err)
+		} else {
+			c.AbortWithStatusJSON(code, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	conn.ClientID = GenerateToken()
+	data, status, errr := client.IntegrationModuleEdit(getIntegrationModule(conn.ClientID))
+	if errr.RuntimeErr != nil {
+		c.Error(errr.RuntimeErr)
+		return
+	}
+
+	if status == http.StatusPaymentRequired {
+		c.AbortWithStatusJSON(BadRequest("error_payment_mg"))
+		logger.Error(conn.APIURL, status, errr.ApiErr, data)
+		return
+	}
+
+	if status >= http.StatusBadRequest {
+		c.AbortWithStatusJSON(BadRequest("error_activity_mg"))
+		logger.Error(conn.APIURL, status, errr.ApiErr, data)
+		return
+	}
+
+	conn.MGURL = data.Info.MgTransportInfo.EndpointUrl
+	conn.MGToken = data.Info.MgTransportInfo.Token
+	conn.Active = true
+
+	err = conn.createConnection()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(
+		http.StatusCreated,
+		gin.H{
+			"url":     "/settings/" + conn.ClientID,
+			"message": getLocalizedMessage("successful"),
+		},
+	)
+}
+
+func activityHandler(c *gin.Context) {
+	var (
+		activity  v5.Activity
+		systemUrl = c.PostForm("systemUrl")
+		clientId  = c.PostForm("clientId")
+	)
+
+	conn := getConnection(clientId)
+	if conn.ID == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest,
+			gin.H{
+				"success": false,
+				"error":   "Wrong data",
+			},
+		)
+		return
+	}
+
+	err := json.Unmarshal([]byte(c.PostForm("activity")), &activity)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest,
+			gin.H{
+				"success": false,
+				"error":   "Wrong data",
+			},
+		)
+		return
+	}
+
+	conn.Active = activity.Active && !activity.Freeze
+
+	if systemUrl != "" {
+		conn.APIURL = systemUrl
+	}
+
+	hashSettings, err := getChannelSettingsHash()
+	if err != nil {
+		logger.Errorf("activityHandler hashSettings apiURl: %s, err: %s", conn.APIURL, err.Error())
+	} else {
+		updateBots(conn, hashSettings)
+	}
+
+	if err := conn.saveConnection(); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func setLangBotHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+	cl, err := getBotByToken(b.Token)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	cl.Lang = b.Lang
+
+	err = cl.save()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if err := registerBotCommands(cl); err != nil {
+		logger.Error(cl.ID, err.Error())
+		c.AbortWithStatusJSON(BadRequest("error_registering_commands"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func setGroupPolicyBotHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+	if b.GroupPolicy != GroupPolicyIgnore && b.GroupPolicy != GroupPolicyBridge {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	cl, err := getBotByToken(b.Token)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	cl.GroupPolicy = b.GroupPolicy
+
+	err = cl.save()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// checkBotCredentials responds with the error if the API key of the bot connection lacks the credentials the feature needs
+func checkBotCredentials(c *gin.Context, b *Bot, required []string) bool {
+	conn := getConnectionById(b.ConnectionID)
+
+	_, err, code := getAPIClientWithCredentials(conn.APIURL, conn.APIKEY, required)
+	if err != nil {
+		if code == http.StatusInternalServerError {
+			c.Error(err)
+		} else {
+			c.AbortWithStatusJSON(code, gin.H{"error": err.Error()})
+		}
+
+		return false
+	}
+
+	return true
+}
+
+func addPayloadSourceHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var ps PayloadSource
+	if err := c.ShouldBindBodyWith(&ps, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	if !checkBotCredentials(c, &b, credentialsSources) {
+		return
+	}
+
+	err := b.createPayloadSource(ps)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func deletePayloadSourceHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		ID int `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	err := b.deletePayloadSource(req.ID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func addDeepLinkHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var dl DeepLink
+	if err := c.ShouldBindBodyWith(&dl, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("incorrect_payload"))
+		return
+	}
+
+	err := b.createDeepLink(dl)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(
+		http.StatusOK,
+		gin.H{
+			"url":     getDeepLinkURL(b.Name, dl.Payload),
+			"message": getLocalizedMessage("successful"),
+		},
+	)
+}
+
+func deleteDeepLinkHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		ID int `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	err := b.deleteDeepLink(req.ID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func addCommandHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var cmd BotCommand
+	if err := c.ShouldBindBodyWith(&cmd, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("incorrect_command"))
+		return
+	}
+
+	if !isLanguage(cmd.Lang) || !cmd.Forward && cmd.Reply == "" {
+		c.AbortWithStatusJSON(BadRequest("incorrect_command"))
+		return
+	}
+
+	err := b.saveCommand(cmd)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if err := registerBotCommands(&b); err != nil {
+		logger.Error(b.ID, err.Error())
+		c.AbortWithStatusJSON(BadRequest("error_registering_commands"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func deleteCommandHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		ID int `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	err := b.deleteCommand(req.ID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if err := registerBotCommands(&b); err != nil {
+		logger.Error(b.ID, err.Error())
+		c.AbortWithStatusJSON(BadRequest("error_registering_commands"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func setScheduleHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		Timezone     string `json:"timezone" binding:"max=50"`
+		AwayInterval int    `json:"awayInterval" binding:"min=0,max=720"`
+		AwayNote     bool   `json:"awayNote"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	if _, err := time.LoadLocation(req.Timezone); err != nil {
+		c.AbortWithStatusJSON(BadRequest("incorrect_timezone"))
+		return
+	}
+
+	b.Timezone = req.Timezone
+	b.AwayInterval = req.AwayInterval
+	b.AwayNote = req.AwayNote
+
+	err := b.save()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func addBusinessHoursHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var bh BusinessHours
+	if err := c.ShouldBindBodyWith(&bh, binding.JSON); err != nil || bh.Opens >= bh.Closes {
+		c.AbortWithStatusJSON(BadRequest("incorrect_business_hours"))
+		return
+	}
+
+	err := b.createBusinessHours(bh)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func deleteBusinessHoursHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		ID int `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	err := b.deleteBusinessHours(req.ID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func addHolidayHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		Date string `json:"date" binding:"required"`
+		Name string `json:"name" binding:"max=100"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("incorrect_holiday"))
+		return
+	}
+
+	date, err := time.Parse("2006-01-02", req.Date)
+	if err != nil {
+		c.AbortWithStatusJSON(BadRequest("incorrect_holiday"))
+		return
+	}
+
+	err = b.createHoliday(Holiday{Date: date, Name: req.Name})
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func deleteHolidayHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		ID int `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	err := b.deleteHoliday(req.ID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func addAwayMessageHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var am AwayMessage
+	if err := c.ShouldBindBodyWith(&am, binding.JSON); err != nil || !isLanguage(am.Lang) {
+		c.AbortWithStatusJSON(BadRequest("incorrect_away_message"))
+		return
+	}
+
+	err := b.saveAwayMessage(am)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func deleteAwayMessageHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		ID int `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	err := b.deleteAwayMessage(req.ID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func saveRuleHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var r AutoReplyRule
+	if err := c.ShouldBindBodyWith(&r, binding.JSON); err != nil || !r.isValid() || r.Lang != "" && !isLanguage(r.Lang) {
+		c.AbortWithStatusJSON(BadRequest("incorrect_rule"))
+		return
+	}
+
+	err := b.saveRule(r)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func deleteRuleHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		ID int `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	err := b.deleteRule(req.ID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func setRuleActiveHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		ID     int  `json:"id" binding:"required"`
+		Active bool `json:"active"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	err := b.setRuleActive(req.ID, req.Active)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func moveRuleHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		ID int  `json:"id" binding:"required"`
+		Up bool `json:"up"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	err := b.moveRule(req.ID, req.Up)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func setSpamFilterHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		FloodLimit int `json:"floodLimit" binding:"min=0,max=1000"`
+		FloodMute  int `json:"floodMute" binding:"min=0,max=10080"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	b.FloodLimit = req.FloodLimit
+	b.FloodMute = req.FloodMute
+
+	err := b.save()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func setRequestPhoneHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		RequestPhone bool `json:"requestPhone"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	if req.RequestPhone && !checkBotCredentials(c, &b, credentialsCustomers) {
+		return
+	}
+
+	b.RequestPhone = req.RequestPhone
+
+	err := b.save()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func setOrdersHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		OrderButton bool   `json:"orderButton"`
+		OrderStatus string `json:"orderStatus" binding:"max=255"`
+		OrderMethod string `json:"orderMethod" binding:"max=255"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	if req.OrderButton && !checkBotCredentials(c, &b, credentialsOrders) {
+		return
+	}
+
+	b.OrderButton = req.OrderButton
+	b.OrderStatus = strings.TrimSpace(req.OrderStatus)
+	b.OrderMethod = strings.TrimSpace(req.OrderMethod)
+
+	err := b.save()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func setPaymentsHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		PaymentToken  string `json:"paymentToken" binding:"max=255"`
+		PaymentType   string `json:"paymentType" binding:"max=255"`
+		PaymentStatus string `json:"paymentStatus" binding:"max=255"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	if req.PaymentToken != "" && req.PaymentType == "" {
+		c.AbortWithStatusJSON(BadRequest("incorrect_payments"))
+		return
+	}
+
+	if req.PaymentToken != "" && !checkBotCredentials(c, &b, credentialsPayments) {
+		return
+	}
+
+	b.PaymentToken = strings.TrimSpace(req.PaymentToken)
+	b.PaymentType = strings.TrimSpace(req.PaymentType)
+	b.PaymentStatus = strings.TrimSpace(req.PaymentStatus)
+
+	err := b.save()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func setOperatorNotificationsHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		OperatorJoined     bool   `json:"operatorJoined"`
+		OperatorJoinedText string `json:"operatorJoinedText" binding:"max=255"`
+		ChatActions        bool   `json:"chatActions"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	text := strings.TrimSpace(req.OperatorJoinedText)
+	if _, err := renderOperatorTemplate(text, "Name"); err != nil {
+		c.AbortWithStatusJSON(BadRequest("incorrect_operator_template"))
+		return
+	}
+
+	b.OperatorJoined = req.OperatorJoined
+	b.OperatorJoinedText = text
+	b.ChatActions = req.ChatActions
+
+	err := b.save()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func setSignatureHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		SignaturePosition string `json:"signaturePosition" binding:"max=10"`
+		SignatureFormat   string `json:"signatureFormat" binding:"max=255"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	if _, ok := getSignaturePositions()[req.SignaturePosition]; !ok && req.SignaturePosition != "" {
+		c.AbortWithStatusJSON(BadRequest("incorrect_signature"))
+		return
+	}
+
+	format := strings.TrimSpace(req.SignatureFormat)
+	if _, err := renderOperatorTemplate(format, "Name"); err != nil {
+		c.AbortWithStatusJSON(BadRequest("incorrect_signature"))
+		return
+	}
+
+	b.SignaturePosition = req.SignaturePosition
+	b.SignatureFormat = format
+
+	err := b.save()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func setFallbacksHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		EditFallback   string `json:"editFallback"`
+		DeleteFallback string `json:"deleteFallback"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	if _, ok := getEditFallbacks()[req.EditFallback]; !ok && req.EditFallback != "" {
+		c.AbortWithStatusJSON(BadRequest("incorrect_fallback"))
+		return
+	}
+
+	if _, ok := getDeleteFallbacks()[req.DeleteFallback]; !ok && req.DeleteFallback != "" {
+		c.AbortWithStatusJSON(BadRequest("incorrect_fallback"))
+		return
+	}
+
+	b.EditFallback = req.EditFallback
+	b.DeleteFallback = req.DeleteFallback
+
+	err := b.save()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func setQuietHoursHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		QuietFrom  string `json:"quietFrom" binding:"omitempty,validatetime"`
+		QuietTo    string `json:"quietTo" binding:"omitempty,validatetime"`
+		QuietDefer bool   `json:"quietDefer"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil || (req.QuietFrom == "") != (req.QuietTo == "") {
+		c.AbortWithStatusJSON(BadRequest("incorrect_quiet_hours"))
+		return
+	}
+
+	b.QuietFrom = req.QuietFrom
+	b.QuietTo = req.QuietTo
+	b.QuietDefer = req.QuietDefer
+
+	err := b.save()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func setStaffNotificationsHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		StaffChatID       int64 `json:"staffChatId"`
+		UnansweredMinutes int   `json:"unansweredMinutes" binding:"min=0,max=10080"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	if req.StaffChatID != 0 {
+		bot, err := tgbotapi.NewBotAPI(b.Token)
+		if err != nil {
+			logger.Error(b.Token, err.Error())
+			c.AbortWithStatusJSON(BadRequest("incorrect_token"))
+			return
+		}
+
+		if _, err := bot.GetChat(tgbotapi.ChatConfig{ChatID: req.StaffChatID}); err != nil {
+			logger.Error(b.ID, req.StaffChatID, err)
+			c.AbortWithStatusJSON(BadRequest("incorrect_staff_chat"))
+			return
+		}
+	}
+
+	if b.StaffChatID == 0 || b.UnansweredMinutes == 0 {
+		now := time.Now()
+		b.StaffSince = &now
+	}
+
+	b.StaffChatID = req.StaffChatID
+	b.UnansweredMinutes = req.UnansweredMinutes
+
+	err := b.save()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func setCsatHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		Csat bool `json:"csat"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	b.Csat = req.Csat
+
+	err := b.save()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func setInlineSearchHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		InlineSearch bool `json:"inlineSearch"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	if req.InlineSearch && !checkBotCredentials(c, &b, credentialsInline) {
+		return
+	}
+
+	b.InlineSearch = req.InlineSearch
+
+	err := b.save()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func addBlockedUserHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var bu BlockedUser
+	if err := c.ShouldBindBodyWith(&bu, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("incorrect_user_id"))
+		return
+	}
+
+	err := b.blockUser(bu)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func deleteBlockedUserHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		ID int `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	err := b.deleteBlockedUser(req.ID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func addCampaignHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var cmp Campaign
+	if err := c.ShouldBindBodyWith(&cmp, binding.JSON); err != nil || !cmp.isValid() {
+		c.AbortWithStatusJSON(BadRequest("incorrect_campaign"))
+		return
+	}
+
+	err := b.createCampaign(cmp)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func startCampaignHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+	cmp := getCampaignForRequest(c, &b)
+	if cmp == nil {
+		return
+	}
+
+	if cmp.Status != CampaignStatusDraft && cmp.Status != CampaignStatusPaused {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	err := cmp.start()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func pauseCampaignHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+	cmp := getCampaignForRequest(c, &b)
+	if cmp == nil {
+		return
+	}
+
+	if cmp.Status != CampaignStatusRunning {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	err := cmp.setStatus(CampaignStatusPaused)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func deleteCampaignHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	var req struct {
+		ID int `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	err := b.deleteCampaign(req.ID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+}
+
+func getCampaignForRequest(c *gin.Context, b *Bot) *Campaign {
+	var req struct {
+		ID int `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return nil
+	}
+
+	cmp := b.getCampaign(req.ID)
+	if cmp.ID == 0 {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return nil
+	}
+
+	return cmp
+}
+
+func generateNotifyTokenHandler(c *gin.Context) {
+	var req struct {
+		ClientID string `json:"clientId" binding:"required"`
+		APIKEY   string `json:"apiKey" binding:"required"`
+		Rotate   bool   `json:"rotate"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(BadRequest("incorrect_key"))
+		return
+	}
+
+	conn := getConnection(req.ClientID)
+	if conn.ID == 0 {
+		c.AbortWithStatusJSON(BadRequest("not_found_account"))
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(conn.APIKEY), []byte(req.APIKEY)) != 1 {
+		c.AbortWithStatusJSON(BadRequest("incorrect_key"))
+		return
+	}
+
+	if conn.NotifyToken != "" && !req.Rotate {
+		c.AbortWithStatusJSON(BadRequest("notify_token_exists"))
+		return
+	}
+
+	token := GenerateToken()
+	err := conn.setNotifyToken(token)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token, "message": getLocalizedMessage("successful")})
+}
+
+func notifyHandler(c *gin.Context) {
+	conn := c.MustGet("connection").(Connection)
+
+	var req NotifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(BadRequest("wrong_data"))
+		return
+	}
+
+	b := conn.getBotByName(req.Bot)
+	if b.ID == 0 {
+		c.AbortWithStatusJSON(BadRequest("error_bot_not_found"))
+		return
+	}
+
+	userID, err := req.getUserID()
+	if err != nil && req.CustomerExternalID != "" {
+		if chat := getChatByCustomerExtID(b.ID, req.CustomerExternalID); chat.ID != 0 {
+			userID, err = int(chat.ExternalID), nil
+		}
+	}
+
+	if err != nil {
+		c.AbortWithStatusJSON(BadRequest("error_customer_not_found"))
+		return
+	}
+
+	text, err := req.render()
+	if err != nil {
+		logger.Error(conn.ClientID, err.Error())
+		c.AbortWithStatusJSON(BadRequest("incorrect_template"))
+		return
+	}
+
+	cid := int64(userID)
+	chat := getChat(b.ID, cid)
+	if chat.Blocked {
+		c.AbortWithStatusJSON(BadRequest("error_customer_blocked_bot"))
+		return
+	}
+
+	bot, err := tgbotapi.NewBotAPI(b.Token)
+	if err != nil {
+		logger.Error(b, err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	bot.Debug = config.Debug
+
+	msgSend, err := bot.Send(tgbotapi.NewMessage(cid, text))
+	if err != nil {
+		abortWithSendError(c, b, cid, err)
+		return
+	}
+
+	setLocale(b.Lang)
+	snd := v1.SendData{
+		Message: v1.Message{
+			ExternalID: strconv.Itoa(msgSend.MessageID),
+			Type:       v1.MsgTypeText,
+			Text:       text,
+			Note:       getLocalizedMessage("notification_note"),
+		},
+		Originator: v1.OriginatorChannel,
+		Customer: v1.Customer{
+			ExternalID: strconv.Itoa(userID),
+			Nickname:   getNotifyNickname(bot, cid),
+		},
+		Channel:        b.Channel,
+		ExternalChatID: strconv.FormatInt(cid, 10),
+	}
+
+	chat.setMGCustomer(&snd.Customer)
+
+	client := v1.New(conn.MGURL, conn.MGToken)
+	client.Debug = config.Debug
+
+	data, st, err := client.Messages(snd)
+	if err != nil {
+		logger.Error(b.Token, err.Error(), st, data)
+	}
+
+	if config.Debug {
+		logger.Debugf("notifyHandler Bot: %v, Message: %+v, Response: %+v", b.ID, snd, data)
+	}
+
+	c.JSON(
+		http.StatusOK,
+		gin.H{
+			"external_message_id": strconv.Itoa(msgSend.MessageID),
+			"mirrored":            err == nil,
+		},
+	)
+}
+
+// linkCustomer links the private chat to the CRM customer with the Telegram external ID on the first message, the
+// shared phone is an extra match, the chat is linked again when the user shares a new phone
+func linkCustomer(conn *Connection, b *Bot, cid int64, user *tgbotapi.User, phone string) *Chat {
+	chat := getChat(b.ID, cid)
+	if chat.CustomerID != 0 && (phone == "" || phone == chat.Phone) {
+		return chat
+	}
+
+	customer, err := getCRMCustomer(conn, user, phone)
+	if err != nil {
+		logger.Errorf("getCRMCustomer apiURL: %s, user: %d, err: %s", conn.APIURL, user.ID, err.Error())
+		return chat
+	}
+
+	chat.BotID = b.ID
+	chat.ExternalID = cid
+	chat.setLinkedCustomer(customer, phone)
+
+	if err := chat.setCustomer(); err != nil {
+		logger.Error(b.ID, cid, err)
+	}
+
+	return chat
+}
+
+// askPhone asks the customer for the phone on the first contact and removes the keyboard once the phone is shared
+func askPhone(b *Bot, chat *Chat, message *tgbotapi.Message) {
+	if !b.RequestPhone {
+		return
+	}
+
+	setLocale(getCustomerLanguage(chat, message.From))
+
+	var msg tgbotapi.MessageConfig
+	switch {
+	case getSharedPhone(message) != "":
+		if chat.PhoneAskedAt == nil {
+			return
+		}
+
+		msg = getPhoneReceived(message.Chat.ID)
+	case chat.Phone == "" && chat.PhoneAskedAt == nil:
+		msg = getPhoneRequest(message.Chat.ID)
+
+		if err := setChatPhoneAskedAt(b.ID, message.Chat.ID); err != nil {
+			logger.Error(b.ID, message.Chat.ID, err)
+			return
+		}
+	default:
+		return
+	}
+
+	bot, err := tgbotapi.NewBotAPI(b.Token)
+	if err != nil {
+		logger.Error(b.ID, err)
+		return
+	}
+
+	bot.Debug = config.Debug
+
+	if _, err := bot.Send(msg); err != nil {
+		logger.Error(b.ID, message.Chat.ID, err)
+	}
+}
+
+// orderOffer creates the CRM order of the offer from the order button and posts the confirmation into the MG dialog
+func orderOffer(conn *Connection, b *Bot, client *v1.MgClient, query *tgbotapi.CallbackQuery, offerID int) {
+	cid := query.Message.Chat.ID
+	chat := getChat(b.ID, cid)
+
+	setLocale(getCustomerLanguage(chat, query.From))
+	answer := tgbotapi.NewCallback(query.ID, getLocalizedMessage("order_created"))
+
+	var id int
+	err := errOrdersDisabled
+	if b.OrderButton {
+		chat = linkCustomer(conn, b, cid, query.From, "")
+		err = errCustomerNotLinked
+	}
+
+	if b.OrderButton && chat.CustomerID != 0 {
+		id, err = createCRMOrder(conn, b, chat.CustomerID, offerID)
+	}
+
+	if err != nil {
+		logger.Errorf("createCRMOrder apiURL: %s, offer: %d, err: %s", conn.APIURL, offerID, err.Error())
+		answer.Text = getLocalizedMessage("error_creating_order")
+	}
+
+	bot, botErr := tgbotapi.NewBotAPI(b.Token)
+	if botErr != nil {
+		logger.Error(b.ID, botErr)
+		return
+	}
+
+	bot.Debug = config.Debug
+
+	if _, botErr := bot.AnswerCallbackQuery(answer); botErr != nil {
+		logger.Error(b.ID, cid, botErr)
+	}
+
+	if err != nil {
+		return
+	}
+
+	setLocale(b.Lang)
+	snd := v1.SendData{
+		Message: v1.Message{
+			ExternalID: "order_" + query.ID,
+			Type:       v1.MsgTypeText,
+			Text:       getLocalizedTemplateMessage("order_created_note", map[string]interface{}{"URL": getCRMOrderURL(conn, id)}),
+		},
+		Originator: v1.OriginatorChannel,
+		Customer: v1.Customer{
+			ExternalID: strconv.Itoa(query.From.ID),
+			Nickname:   query.From.UserName,
+			Firstname:  query.From.FirstName,
+			Lastname:   query.From.LastName,
+		},
+		Channel:        b.Channel,
+		ExternalChatID: strconv.FormatInt(cid, 10),
+	}
+	chat.setMGCustomer(&snd.Customer)
+
+	data, st, err := client.Messages(snd)
+	if err != nil {
+		logger.Error(b.Token, err.Error(), st, data)
+	}
+}
+
+func sendLanguageKeyboard(b *Bot, cid int64) error {
+	bot, err := tgbotapi.NewBotAPI(b.Token)
+	if err != nil {
+		return err
+	}
+
+	bot.Debug = config.Debug
+
+	msg := tgbotapi.NewMessage(cid, getLocalizedMessage("language_select"))
+	msg.ReplyMarkup = getLanguageKeyboard()
+
+	_, err = bot.Send(msg)
+
+	return err
+}
+
+// selectLanguage stores the language chosen by the customer and confirms it in the chosen language
+func selectLanguage(b *Bot, query *tgbotapi.CallbackQuery, lang string) {
+	cid := query.Message.Chat.ID
+	if err := setChatLang(b.ID, cid, lang); err != nil {
+		logger.Error(b.ID, cid, err)
+		return
+	}
+
+	bot, err := tgbotapi.NewBotAPI(b.Token)
+	if err != nil {
+		logger.Error(b.ID, err)
+		return
+	}
+
+	bot.Debug = config.Debug
+	setLocale(lang)
+
+	text := getLocalizedMessage("language_selected")
+	if _, err := bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, text)); err != nil {
+		logger.Error(b.ID, cid, err)
+	}
+
+	if _, err := bot.Send(tgbotapi.NewEditMessageText(cid, query.Message.MessageID, text)); err != nil {
+		logger.Error(b.ID, cid, err)
+	}
+}
+
+// answerInlineQuery answers the inline query with the products found in the CRM catalog
+func answerInlineQuery(conn *Connection, b *Bot, query *tgbotapi.InlineQuery) {
+	products, err := findProducts(conn, query.Query)
+	if err != nil {
+		logger.Errorf("findProducts apiURL: %s, query: %s, err: %s", conn.APIURL, query.Query, err.Error())
+	}
+
+	bot, err := tgbotapi.NewBotAPI(b.Token)
+	if err != nil {
+		logger.Error(b.ID, err)
+		return
+	}
+
+	bot.Debug = config.Debug
+	setLocale(getCustomerLanguage(getChat(b.ID, int64(query.From.ID)), query.From))
+
+	answer := tgbotapi.InlineConfig{
+		InlineQueryID: query.ID,
+		Results:       getInlineResults(products, getCatalogCurrency(conn)),
+		CacheTime:     int(ProductCacheTTL.Seconds()),
+		IsPersonal:    true,
+	}
+
+	if _, err := bot.AnswerInlineQuery(answer); err != nil {
+		logger.Error(b.ID, query.ID, err)
+	}
+}
+
+// reportChosenProduct posts the product the customer has sent in inline mode into the MG dialog with the customer,
+// the transport API has no product messages so the product is posted as the text. The customers who have never
+// written to the bot are skipped, the product was sent to another chat and there is no dialog to post it into
+func reportChosenProduct(conn *Connection, b *Bot, client *v1.MgClient, result *tgbotapi.ChosenInlineResult) {
+	id, err := strconv.Atoi(result.ResultID)
+	if err != nil {
+		return
+	}
+
+	cid := int64(result.From.ID)
+	chat := getChat(b.ID, cid)
+	if chat.ID == 0 {
+		return
+	}
+
+	product, err := findProduct(conn, result.Query, id)
+	if err != nil {
+		logger.Errorf("findProduct apiURL: %s, product: %d, err: %s", conn.APIURL, id, err.Error())
+		return
+	}
+
+	setLocale(b.Lang)
+	snd := v1.SendData{
+		Message: v1.Message{
+			ExternalID: "inline_" + result.ResultID + "_" + strconv.FormatInt(time.Now().UnixNano(), 10),
+			Type:       v1.MsgTypeText,
+			Text:       getChosenProductText(product, getCatalogCurrency(conn)),
+			Note:       getLocalizedMessage("inline_product_note"),
+		},
+		Originator: v1.OriginatorCustomer,
+		Customer: v1.Customer{
+			ExternalID: strconv.Itoa(result.From.ID),
+			Nickname:   result.From.UserName,
+			Firstname:  result.From.FirstName,
+			Lastname:   result.From.LastName,
+		},
+		Channel:        b.Channel,
+		ExternalChatID: strconv.FormatInt(cid, 10),
+	}
+	chat.setMGCustomer(&snd.Customer)
+
+	data, st, err := client.Messages(snd)
+	if err != nil {
+		logger.Error(b.Token, err.Error(), st, data)
+	}
+}
+
+// answerPreCheckout confirms the checkout of the invoice if the CRM order exists, is not paid and still has the invoice amount
+func answerPreCheckout(conn *Connection, b *Bot, query *tgbotapi.PreCheckoutQuery) {
+	answer := tgbotapi.PreCheckoutConfig{PreCheckoutQueryID: query.ID, OK: true}
+
+	errKey := "error_order_not_found"
+	if number := getInvoiceOrderNumber(query.InvoicePayload); number != "" {
+		order, err := getCRMOrder(conn, number)
+		if err != nil {
+			logger.Errorf("getCRMOrder apiURL: %s, number: %s, err: %s", conn.APIURL, number, err.Error())
+		} else {
+			errKey = getPreCheckoutError(order, query.TotalAmount, query.Currency)
+		}
+	}
+
+	if errKey != "" {
+		setLocale(getCustomerLanguage(getChat(b.ID, int64(query.From.ID)), query.From))
+		answer.OK = false
+		answer.ErrorMessage = getLocalizedMessage(errKey)
+	}
+
+	bot, err := tgbotapi.NewBotAPI(b.Token)
+	if err != nil {
+		logger.Error(b.ID, err)
+		return
+	}
 
-	res := struct {
-		Conn     *Connection
-		Bots     Bots
-		Locale   map[string]interface{}
-		Year     int
-		LangCode []string
-	}{
-		p,
-		bots,
-		getLocale(),
-		time.Now().Year(),
-		[]string{"en", "ru", "es"},
+	bot.Debug = config.Debug
+
+	if _, err := bot.AnswerPreCheckoutQuery(answer); err != nil {
+		logger.Error(b.ID, query.ID, err)
+	}
+}
+
+// recordPayment records the successful payment against the CRM order and posts it into the MG dialog
+func recordPayment(conn *Connection, b *Bot, client *v1.MgClient, message *tgbotapi.Message) {
+	payment := message.SuccessfulPayment
+	number := getInvoiceOrderNumber(payment.InvoicePayload)
+
+	id, err := getCRMOrderID(conn, number)
+	if err == nil {
+		err = createCRMPayment(conn, b, id, payment)
 	}
 
-	c.HTML(http.StatusOK, "form", &res)
+	setLocale(b.Lang)
+	snd := v1.SendData{
+		Message: v1.Message{
+			ExternalID: strconv.Itoa(message.MessageID),
+			Type:       v1.MsgTypeText,
+			Text: getLocalizedTemplateMessage("payment_received", map[string]interface{}{
+				"Number":   number,
+				"Amount":   getMajorUnits(payment.TotalAmount, payment.Currency),
+				"Currency": payment.Currency,
+			}),
+		},
+		Originator: v1.OriginatorCustomer,
+		Customer: v1.Customer{
+			ExternalID: strconv.Itoa(message.From.ID),
+			Nickname:   message.From.UserName,
+			Firstname:  message.From.FirstName,
+			Lastname:   message.From.LastName,
+		},
+		Channel:        b.Channel,
+		ExternalChatID: strconv.FormatInt(message.Chat.ID, 10),
+	}
+	getChat(b.ID, message.Chat.ID).setMGCustomer(&snd.Customer)
+
+	if err != nil {
+		logger.Errorf("createCRMPayment apiURL: %s, number: %s, err: %s", conn.APIURL, number, err.Error())
+		snd.Message.Note = getLocalizedMessage("payment_not_recorded_note")
+	}
+
+	data, st, err := client.Messages(snd)
+	if err != nil {
+		logger.Error(b.Token, err.Error(), st, data)
+	}
 }
 
-func saveHandler(c *gin.Context) {
-	conn := c.MustGet("connection").(Connection)
-	_, err, code := getAPIClient(conn.APIURL, conn.APIKEY)
+// rate records the score tapped by the customer, asks for the comment and posts the score into the MG dialog
+func rate(b *Bot, client *v1.MgClient, query *tgbotapi.CallbackQuery, score int) {
+	cid := query.Message.Chat.ID
+	rating := Rating{BotID: b.ID, ExternalID: cid, MessageID: query.Message.MessageID, Score: score}
+
+	bot, err := tgbotapi.NewBotAPI(b.Token)
 	if err != nil {
-		if code == http.StatusInternalServerError {
-			c.Error(err)
-		} else {
-			c.AbortWithStatusJSON(code, gin.H{"error": err.Error()})
-		}
+		logger.Error(b.ID, err)
 		return
 	}
 
-	err = conn.saveConnectionByClientID()
-	if err != nil {
-		c.Error(err)
+	bot.Debug = config.Debug
+	setLocale(getCustomerLanguage(getChat(b.ID, cid), query.From))
+
+	created, err := createRating(&rating)
+	if err != nil || !created {
+		text := getLocalizedMessage("csat_rated")
+		if err != nil {
+			logger.Error(b.ID, cid, err)
+			text = ""
+		}
+
+		if _, err := bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, text)); err != nil {
+			logger.Error(b.ID, cid, err)
+		}
+
+		setLocale(b.Lang)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": getLocalizedMessage("successful")})
+	if err := setChatRatingID(b.ID, cid, rating.ID); err != nil {
+		logger.Error(b.ID, cid, err)
+	}
+
+	if _, err := bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, getRatingStars(score))); err != nil {
+		logger.Error(b.ID, cid, err)
+	}
+
+	thanks := getLocalizedMessage("csat_thanks")
+	if _, err := bot.Send(tgbotapi.NewEditMessageText(cid, query.Message.MessageID, thanks)); err != nil {
+		logger.Error(b.ID, cid, err)
+	}
+
+	setLocale(b.Lang)
+	snd := v1.SendData{
+		Message: v1.Message{
+			ExternalID: "rate_" + query.ID,
+			Type:       v1.MsgTypeText,
+			Text: getLocalizedTemplateMessage("csat_rating", map[string]interface{}{
+				"Stars": getRatingStars(score),
+				"Score": score,
+			}),
+		},
+		Originator: v1.OriginatorCustomer,
+		Customer: v1.Customer{
+			ExternalID: strconv.Itoa(query.From.ID),
+			Nickname:   query.From.UserName,
+			Firstname:  query.From.FirstName,
+			Lastname:   query.From.LastName,
+		},
+		Channel:        b.Channel,
+		ExternalChatID: strconv.FormatInt(cid, 10),
+	}
+	getChat(b.ID, cid).setMGCustomer(&snd.Customer)
+
+	data, st, err := client.Messages(snd)
+	if err != nil {
+		logger.Error(b.Token, err.Error(), st, data)
+	}
 }
 
-func createHandler(c *gin.Context) {
-	conn := c.MustGet("connection").(Connection)
+// notifyOperatorJoined tells the customer about the operator replying in the dialog for the first time, the MG webhooks
+// of the transport have no assignment event, so the operator is taken from the sent messages
+func notifyOperatorJoined(bot *tgbotapi.BotAPI, b *Bot, cid int64, name string, quiet bool) {
+	if !b.OperatorJoined || name == "" || getChat(b.ID, cid).Operator == name {
+		return
+	}
 
-	cl := getConnectionByURL(conn.APIURL)
-	if cl.ID != 0 {
-		c.AbortWithStatusJSON(BadRequest("connection_already_created"))
+	if err := setChatOperator(b.ID, cid, name); err != nil {
+		logger.Error(b.ID, cid, err)
 		return
 	}
 
-	client, err, code := getAPIClient(conn.APIURL, conn.APIKEY)
+	text, err := getOperatorJoinedText(b, name)
 	if err != nil {
-		if code == http.StatusInternalServerError {
-			c.Error(err)
-		} else {
-			c.AbortWithStatusJSON(code, gin.H{"error": err.Error()})
-		}
+		logger.Error(b.ID, err)
 		return
 	}
 
-	conn.ClientID = GenerateToken()
-	data, status, errr := client.IntegrationModuleEdit(getIntegrationModule(conn.ClientID))
-	if errr.RuntimeErr != nil {
-		c.Error(errr.RuntimeErr)
-		return
+	m := tgbotapi.NewMessage(cid, text)
+	m.DisableNotification = quiet
+
+	if _, err := bot.Send(m); err != nil {
+		logger.Error(b.ID, cid, err)
+	}
+}
+
+// requestRating closes the dialog and asks the customer to rate it, the request is postponed during the quiet hours
+// if the bot defers the messages
+func requestRating(c *gin.Context, bot *tgbotapi.BotAPI, b *Bot, cid int64, quiet bool, now time.Time) {
+	if err := setChatDialogClosed(b.ID, cid); err != nil {
+		logger.Error(b.ID, cid, err)
 	}
 
-	if status == http.StatusPaymentRequired {
-		c.AbortWithStatusJSON(BadRequest("error_payment_mg"))
-		logger.Error(conn.APIURL, status, errr.ApiErr, data)
+	if getChat(b.ID, cid).Blocked {
+		c.JSON(http.StatusOK, gin.H{})
 		return
 	}
 
-	if status >= http.StatusBadRequest {
-		c.AbortWithStatusJSON(BadRequest("error_activity_mg"))
-		logger.Error(conn.APIURL, status, errr.ApiErr, data)
+	if quiet && b.QuietDefer {
+		if err := deferMessage(b, cid, DeferredKindRating, getLocalizedMessage("csat_request"), now); err != nil {
+			logger.Error(b.ID, cid, err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{})
 		return
 	}
 
-	conn.MGURL = data.Info.MgTransportInfo.EndpointUrl
-	conn.MGToken = data.Info.MgTransportInfo.Token
-	conn.Active = true
+	m := tgbotapi.NewMessage(cid, getLocalizedMessage("csat_request"))
+	m.ReplyMarkup = getRatingKeyboard()
+	m.DisableNotification = quiet
 
-	err = conn.createConnection()
+	msgSend, err := bot.Send(m)
 	if err != nil {
-		c.Error(err)
+		abortWithSendError(c, b, cid, err)
 		return
 	}
 
-	c.JSON(
-		http.StatusCreated,
-		gin.H{
-			"url":     "/settings/" + conn.ClientID,
-			"message": getLocalizedMessage("successful"),
-		},
-	)
+	if config.Debug {
+		logger.Debugf("mgWebhookHandler rating request %+v", msgSend)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"external_message_id": strconv.Itoa(msgSend.MessageID)})
 }
 
-func activityHandler(c *gin.Context) {
-	var (
-		activity  v5.Activity
-		systemUrl = c.PostForm("systemUrl")
-		clientId  = c.PostForm("clientId")
-	)
+// trackInbound stores the customer message for the staff alerts and alerts the staff about the new dialog
+func trackInbound(b *Bot, message *tgbotapi.Message, text string) {
+	name := getUserName(message.From)
+	if !message.Chat.IsPrivate() {
+		name = message.Chat.Title
+	}
 
-	conn := getConnection(clientId)
-	if conn.ID == 0 {
-		c.AbortWithStatusJSON(http.StatusBadRequest,
-			gin.H{
-				"success": false,
-				"error":   "Wrong data",
-			},
-		)
-		return
+	if text == "" {
+		text = getLocalizedMessage(getMessageID(message))
 	}
 
-	err := json.Unmarshal([]byte(c.PostForm("activity")), &activity)
+	isNew, err := setChatInbound(b.ID, message.Chat.ID, name, getStaffText(text))
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest,
-			gin.H{
-				"success": false,
-				"error":   "Wrong data",
-			},
-		)
+		logger.Error(b.ID, message.Chat.ID, err)
 		return
 	}
 
-	conn.Active = activity.Active && !activity.Freeze
-
-	if systemUrl != "" {
-		conn.APIURL = systemUrl
+	if isNew && b.StaffChatID != 0 {
+		if err := sendStaffAlert("staff_new_dialog", b, getChat(b.ID, message.Chat.ID)); err != nil {
+			logger.Error(b.ID, b.StaffChatID, err)
+		}
 	}
+}
 
-	hashSettings, err := getChannelSettingsHash()
+// getNotifyNickname returns the name of the customer known to Telegram
+func getNotifyNickname(bot *tgbotapi.BotAPI, cid int64) string {
+	chat, err := bot.GetChat(tgbotapi.ChatConfig{ChatID: cid})
 	if err != nil {
-		logger.Errorf("activityHandler hashSettings apiURl: %s, err: %s", conn.APIURL, err.Error())
-	} else {
-		updateBots(conn, hashSettings)
+		return strconv.FormatInt(cid, 10)
 	}
 
-	if err := conn.saveConnection(); err != nil {
-		c.Error(err)
-		return
+	if chat.UserName != "" {
+		return chat.UserName
 	}
 
-	c.JSON(http.StatusOK, gin.H{"success": true})
+	return strings.TrimSpace(chat.FirstName + " " + chat.LastName)
 }
 
-func setLangBotHandler(c *gin.Context) {
-	b := c.MustGet("bot").(Bot)
-	cl, err := getBotByToken(b.Token)
+func registerBotCommands(b *Bot) error {
+	bot, err := tgbotapi.NewBotAPI(b.Token)
 	if err != nil {
-		c.Error(err)
+		return err
+	}
+
+	bot.Debug = config.Debug
+
+	return setMyCommands(bot, b.getCommands(), b.Lang)
+}
+
+func deepLinkQRCodeHandler(c *gin.Context) {
+	conn := getConnection(c.Param("uid"))
+	if conn.ID == 0 {
+		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 
-	cl.Lang = b.Lang
+	id, _ := strconv.Atoi(c.Param("id"))
+	link, b := conn.getDeepLink(id)
+	if link.ID == 0 || b.ID == 0 {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
 
-	err = cl.save()
+	qr, err := newQRCode(getDeepLinkURL(b.Name, link.Payload))
 	if err != nil {
 		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{})
+	switch c.Param("file") {
+	case "qr.png":
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, qr.image(8)); err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s_%s.png"`, b.Name, link.Payload))
+		c.Data(http.StatusOK, "image/png", buf.Bytes())
+	case "qr.svg":
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s_%s.svg"`, b.Name, link.Payload))
+		c.Data(http.StatusOK, "image/svg+xml", qr.svg())
+	default:
+		c.AbortWithStatus(http.StatusNotFound)
+	}
 }
 
 func getIntegrationModule(clientId string) v5.IntegrationModule {
@@ -478,45 +2080,211 @@ func deactivateChannels(client *v1.MgClient, channelIDs []uint64) {
 		}
 	}
 
-	if len(channelListItems) > 0 {
-		for _, ch := range channelListItems {
-			channelListItems, status, err := client.DeactivateTransportChannel(ch.ID)
-			if config.Debug {
-				logger.Debugf(
-					"DeactivateTransportChannel  ChannelListItems: %+v, Status: %d, err: %v",
-					channelListItems, status, err,
-				)
-			}
+	if len(channelListItems) > 0 {
+		for _, ch := range channelListItems {
+			channelListItems, status, err := client.DeactivateTransportChannel(ch.ID)
+			if config.Debug {
+				logger.Debugf(
+					"DeactivateTransportChannel  ChannelListItems: %+v, Status: %d, err: %v",
+					channelListItems, status, err,
+				)
+			}
+		}
+	}
+}
+
+func telegramWebhookHandler(c *gin.Context) {
+	b := c.MustGet("bot").(Bot)
+
+	conn := getConnectionById(b.ConnectionID)
+	if !conn.Active {
+		c.AbortWithStatus(http.StatusOK)
+		return
+	}
+
+	var update Update
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.Error(err)
+		return
+	}
+
+	if config.Debug {
+		logger.Debugf(
+			"mgWebhookHandler request:\nUpdateID: %v,\nMessage: %+v,\nEditedMessage: %+v",
+			update.UpdateID, update.Message, update.EditedMessage,
+		)
+	}
+
+	message := update.Message
+	if message == nil {
+		message = update.EditedMessage
+	}
+
+	if message != nil && b.StaffChatID != 0 && message.Chat.ID == b.StaffChatID {
+		c.JSON(http.StatusOK, gin.H{})
+		return
+	}
+
+	if message != nil && (message.Chat.IsPrivate() || b.GroupPolicy == GroupPolicyBridge) {
+		reason, err := getSpamReason(&b, message, update.Message != nil)
+		if err != nil {
+			logger.Error(b.ID, message.Chat.ID, err)
+		}
+
+		if reason != "" {
+			logger.Infof(
+				"telegramWebhookHandler blocked message Bot: %v, Chat: %v, User: %v, Reason: %s",
+				b.ID, message.Chat.ID, message.From.ID, reason,
+			)
+
+			if err := incrementBlockedMessages(b.ID); err != nil {
+				logger.Error(b.ID, err)
+			}
+
+			c.JSON(http.StatusOK, gin.H{})
+			return
+		}
+	}
+
+	if update.Message != nil && update.Message.Chat.IsPrivate() {
+		if err := saveChat(b.ID, update.Message.Chat.ID); err != nil {
+			logger.Error(b.ID, update.Message.Chat.ID, err)
+		}
+
+		switch getCommand(update.Message, b.Name) {
+		case "stop":
+			err := setChatOptedOut(b.ID, update.Message.Chat.ID, true)
+			if err != nil {
+				c.Error(err)
+				return
+			}
+
+			setLocale(getCustomerLanguage(getChat(b.ID, update.Message.Chat.ID), update.Message.From))
+			if err := sendReply(&b, update.Message.Chat.ID, getLocalizedMessage("broadcast_stopped")); err != nil {
+				logger.Error(b.ID, update.Message.Chat.ID, err)
+			}
+
+			c.JSON(http.StatusOK, gin.H{})
+			return
+		case "language":
+			setLocale(getCustomerLanguage(getChat(b.ID, update.Message.Chat.ID), update.Message.From))
+			if err := sendLanguageKeyboard(&b, update.Message.Chat.ID); err != nil {
+				logger.Error(b.ID, update.Message.Chat.ID, err)
+			}
+
+			c.JSON(http.StatusOK, gin.H{})
+			return
+		case "start":
+			if chat := getChat(b.ID, update.Message.Chat.ID); chat.OptedOut {
+				if err := setChatOptedOut(b.ID, update.Message.Chat.ID, false); err != nil {
+					logger.Error(b.ID, update.Message.Chat.ID, err)
+				}
+			}
+		}
+	}
+
+	if update.Message != nil && (update.Message.Chat.IsPrivate() || b.GroupPolicy == GroupPolicyBridge) {
+		if !applyRules(&b, update.Message) {
+			c.JSON(http.StatusOK, gin.H{})
+			return
+		}
+	}
+
+	var client = v1.New(conn.MGURL, conn.MGToken)
+	client.Debug = config.Debug
+
+	if update.MyChatMember != nil {
+		if update.MyChatMember.Chat.IsPrivate() {
+			err := setChatBlocked(b.ID, update.MyChatMember.Chat.ID, update.MyChatMember.NewChatMember.WasKicked())
+			if err != nil {
+				c.Error(err)
+				return
+			}
+		}
+
+		if config.Debug {
+			logger.Debugf("telegramWebhookHandler Type: MyChatMember, Bot: %v, Update: %+v", b.ID, update.MyChatMember)
+		}
+	}
+
+	if update.CallbackQuery != nil && update.CallbackQuery.Message != nil {
+		if offerID := getOrderCallbackOffer(update.CallbackQuery.Data); offerID != 0 {
+			orderOffer(conn, &b, client, update.CallbackQuery, offerID)
+		}
+	}
+
+	if update.CallbackQuery != nil && update.CallbackQuery.Message != nil {
+		if score := getRatingCallbackScore(update.CallbackQuery.Data); score != 0 {
+			rate(&b, client, update.CallbackQuery, score)
+		}
+
+		if lang := getLanguageCallback(update.CallbackQuery.Data); lang != "" {
+			selectLanguage(&b, update.CallbackQuery, lang)
 		}
 	}
-}
 
-func telegramWebhookHandler(c *gin.Context) {
-	b := c.MustGet("bot").(Bot)
+	if update.PreCheckoutQuery != nil {
+		answerPreCheckout(conn, &b, update.PreCheckoutQuery)
+	}
 
-	conn := getConnectionById(b.ConnectionID)
-	if !conn.Active {
-		c.AbortWithStatus(http.StatusOK)
+	if update.InlineQuery != nil {
+		if b.InlineSearch {
+			answerInlineQuery(conn, &b, update.InlineQuery)
+		}
+
+		c.JSON(http.StatusOK, gin.H{})
 		return
 	}
 
-	var update tgbotapi.Update
-	if err := c.ShouldBindJSON(&update); err != nil {
-		c.Error(err)
+	if update.ChosenInlineResult != nil {
+		if b.InlineSearch {
+			reportChosenProduct(conn, &b, client, update.ChosenInlineResult)
+		}
+
+		c.JSON(http.StatusOK, gin.H{})
 		return
 	}
 
-	if config.Debug {
-		logger.Debugf(
-			"mgWebhookHandler request:\nUpdateID: %v,\nMessage: %+v,\nEditedMessage: %+v",
-			update.UpdateID, update.Message, update.EditedMessage,
-		)
+	if update.Message != nil && update.Message.SuccessfulPayment != nil {
+		recordPayment(conn, &b, client, update.Message)
+
+		c.JSON(http.StatusOK, gin.H{})
+		return
 	}
 
-	var client = v1.New(conn.MGURL, conn.MGToken)
-	client.Debug = config.Debug
+	if update.Message != nil && !update.Message.Chat.IsPrivate() {
+		if update.Message.MigrateToChatID != 0 || update.Message.MigrateFromChatID != 0 {
+			from, to := update.Message.Chat.ID, update.Message.MigrateToChatID
+			if update.Message.MigrateFromChatID != 0 {
+				from, to = update.Message.MigrateFromChatID, update.Message.Chat.ID
+			}
+
+			err := setChatMigratedTo(b.ID, getMGChatID(b.ID, from), to)
+			if err != nil {
+				c.Error(err)
+				return
+			}
+
+			if config.Debug {
+				logger.Debugf("telegramWebhookHandler Type: ChatMigration, Bot: %v, From: %d, To: %d", b.ID, from, to)
+			}
+		}
+
+		if b.GroupPolicy != GroupPolicyBridge || isServiceMessage(update.Message) {
+			c.JSON(http.StatusOK, gin.H{})
+			return
+		}
+	}
 
 	if update.Message != nil {
+		if chat := getChat(b.ID, update.Message.Chat.ID); chat.Blocked {
+			err := setChatBlocked(b.ID, update.Message.Chat.ID, false)
+			if err != nil {
+				c.Error(err)
+				return
+			}
+		}
+
 		nickname := update.Message.From.UserName
 		user := getUserByExternalID(update.Message.From.ID)
 
@@ -524,7 +2292,7 @@ func telegramWebhookHandler(c *gin.Context) {
 			nickname = update.Message.From.FirstName
 		}
 
-		if user.Expired(config.UpdateInterval) || user.ID == 0 {
+		if update.Message.Chat.IsPrivate() && (user.Expired(config.UpdateInterval) || user.ID == 0) {
 			fileID, fileURL, err := GetFileIDAndURL(b.Token, update.Message.From.ID)
 			if err != nil {
 				c.Error(err)
@@ -553,11 +2321,7 @@ func telegramWebhookHandler(c *gin.Context) {
 			}
 		}
 
-		lang := update.Message.From.LanguageCode
-
-		if len(update.Message.From.LanguageCode) > 2 {
-			lang = update.Message.From.LanguageCode[:2]
-		}
+		lang := getCustomerLanguage(getChat(b.ID, update.Message.Chat.ID), update.Message.From)
 
 		if config.Debug {
 			logger.Debugf("telegramWebhookHandler user %+v", user)
@@ -567,7 +2331,7 @@ func telegramWebhookHandler(c *gin.Context) {
 			Message: v1.Message{
 				ExternalID: strconv.Itoa(update.Message.MessageID),
 				Type:       "text",
-				Text:       update.Message.Text,
+				Text:       getEntitiesText(update.Message.Text, update.Message.Entities),
 			},
 			Originator: v1.OriginatorCustomer,
 			Customer: v1.Customer{
@@ -582,12 +2346,57 @@ func telegramWebhookHandler(c *gin.Context) {
 			ExternalChatID: strconv.FormatInt(update.Message.Chat.ID, 10),
 		}
 
+		if !update.Message.Chat.IsPrivate() {
+			mgChatID := strconv.FormatInt(getMGChatID(b.ID, update.Message.Chat.ID), 10)
+			snd.ExternalChatID = mgChatID
+			snd.Customer = v1.Customer{
+				ExternalID: mgChatID,
+				Nickname:   update.Message.Chat.Title,
+				Firstname:  update.Message.Chat.Title,
+				Language:   lang,
+			}
+		}
+
+		var chat *Chat
+		if update.Message.Chat.IsPrivate() {
+			chat = getChat(b.ID, update.Message.Chat.ID)
+			phone := getSharedPhone(update.Message)
+			if phone != "" && b.RequestPhone {
+				chat = linkCustomer(conn, &b, update.Message.Chat.ID, update.Message.From, phone)
+			} else if chat.CustomerID == 0 && chat.LastInboundAt == nil && hasCRMCredentials(conn, credentialsCustomers) {
+				chat = linkCustomer(conn, &b, update.Message.Chat.ID, update.Message.From, "")
+			}
+
+			chat.setMGCustomer(&snd.Customer)
+
+			if phone != "" {
+				snd.Customer.Phone = phone
+			}
+
+			if chat.RatingID != 0 {
+				if update.Message.Text != "" && getCommand(update.Message, b.Name) == "" {
+					if err := setRatingComment(chat.RatingID, update.Message.Text); err != nil {
+						logger.Error(b.ID, update.Message.Chat.ID, err)
+					}
+
+					setLocale(b.Lang)
+					snd.Message.Note = getLocalizedMessage("csat_comment_note")
+				}
+
+				if err := setChatRatingID(b.ID, update.Message.Chat.ID, 0); err != nil {
+					logger.Error(b.ID, update.Message.Chat.ID, err)
+				}
+			}
+		}
+
 		if update.Message.ReplyToMessage != nil {
-			snd.Quote = &v1.SendMessageRequestQuote{ExternalID: strconv.Itoa(update.Message.ReplyToMessage.MessageID)}
+			quoteID := getQuotedMessageID(b.ID, update.Message.Chat.ID, update.Message.ReplyToMessage.MessageID)
+			snd.Quote = &v1.SendMessageRequestQuote{ExternalID: strconv.Itoa(quoteID)}
 		}
 
 		if snd.Message.Text == "" {
-			setLocale(update.Message.From.LanguageCode)
+			setLocale(getCustomerLanguage(chat, update.Message.From))
+			update.Message.Caption = getEntitiesText(update.Message.Caption, update.CaptionEntities)
 
 			err := setAttachment(update.Message, client, &snd, b.Token)
 			if err != nil {
@@ -597,7 +2406,83 @@ func telegramWebhookHandler(c *gin.Context) {
 			}
 		}
 
+		if !update.Message.Chat.IsPrivate() {
+			snd.Message.Text = strings.TrimSpace(getUserName(update.Message.From) + ": " + snd.Message.Text)
+		}
+
+		if payload := getStartPayload(update.Message); payload != "" {
+			err := setChatStartPayload(b.ID, update.Message.Chat.ID, payload)
+			if err != nil {
+				logger.Error(b.ID, update.Message.Chat.ID, err)
+			}
+
+			setLocale(b.Lang)
+			snd.Message.Note = strings.TrimSpace(snd.Message.Note + "\n" + getLocalizedTemplateMessage(
+				"start_payload_note",
+				map[string]interface{}{"Payload": payload},
+			))
+
+			if source := getPayloadSource(b.getPayloadSources(), payload); source != nil {
+				err = setCustomerSource(conn, update.Message.From, source)
+				if err != nil {
+					logger.Errorf("setCustomerSource apiURL: %s, payload: %s, err: %s", conn.APIURL, payload, err.Error())
+				}
+			}
+		}
+
+		if name := getCommand(update.Message, b.Name); name != "" {
+			if cmd := getBotCommand(b.getCommands(), name, lang, b.Lang); cmd != nil {
+				if cmd.Reply != "" {
+					err := sendReply(&b, update.Message.Chat.ID, cmd.Reply)
+					if err != nil {
+						logger.Error(b.ID, update.Message.Chat.ID, err)
+					}
+				}
+
+				if !cmd.Forward {
+					if chat != nil {
+						askPhone(&b, chat, update.Message)
+					}
+
+					c.JSON(http.StatusOK, gin.H{})
+					return
+				}
+			}
+		}
+
+		if origin := getForwardOrigin(update.Message, update.ForwardSenderName); origin != "" {
+			setLocale(b.Lang)
+			snd.Message.Note = strings.TrimSpace(snd.Message.Note + "\n" + getLocalizedTemplateMessage(
+				"forwarded_note",
+				map[string]interface{}{
+					"From": origin,
+					"Date": time.Unix(int64(update.Message.ForwardDate), 0).In(getLocation(b.Timezone)).Format("2006-01-02 15:04"),
+				},
+			))
+		}
+
+		if hours := b.getBusinessHours(); len(hours) > 0 {
+			now := time.Now().In(getLocation(b.Timezone))
+			if isOutOfHours(hours, b.getHolidays(), now) {
+				if b.AwayNote {
+					setLocale(b.Lang)
+					snd.Message.Note = strings.TrimSpace(snd.Message.Note + "\n" + getLocalizedMessage("out_of_hours_note"))
+				}
+
+				if err := sendAwayMessage(&b, update.Message.Chat.ID, lang, now); err != nil {
+					logger.Error(b.ID, update.Message.Chat.ID, err)
+				}
+			}
+		}
+
 		data, st, err := client.Messages(snd)
+		if err != nil && snd.Quote != nil {
+			logger.Error(b.Token, err.Error(), st, data)
+
+			snd.Quote = nil
+			data, st, err = client.Messages(snd)
+		}
+
 		if err != nil {
 			logger.Error(b.Token, err.Error(), st, data)
 
@@ -613,12 +2498,23 @@ func telegramWebhookHandler(c *gin.Context) {
 			return
 		}
 
+		if chat != nil {
+			askPhone(&b, chat, update.Message)
+		}
+
+		trackInbound(&b, update.Message, snd.Message.Text)
+
 		if config.Debug {
 			logger.Debugf("telegramWebhookHandler Type: SendMessage, Bot: %v, Message: %+v, Response: %+v", b.ID, snd, data)
 		}
 	}
 
 	if update.EditedMessage != nil {
+		if !update.EditedMessage.Chat.IsPrivate() && b.GroupPolicy != GroupPolicyBridge {
+			c.JSON(http.StatusOK, gin.H{})
+			return
+		}
+
 		if update.EditedMessage.Text == "" {
 			if getMessageID(update.EditedMessage) != "undefined" {
 				if config.Debug {
@@ -629,14 +2525,19 @@ func telegramWebhookHandler(c *gin.Context) {
 				return
 			}
 
-			setLocale(update.EditedMessage.From.LanguageCode)
-			update.EditedMessage.Text = getLocalizedMessage(getMessageID(update.Message))
+			setLocale(getCustomerLanguage(getChat(b.ID, update.EditedMessage.Chat.ID), update.EditedMessage.From))
+			update.EditedMessage.Text = getLocalizedMessage(getMessageID(update.EditedMessage))
+		}
+
+		text := getEntitiesText(update.EditedMessage.Text, update.EditedMessage.Entities)
+		if !update.EditedMessage.Chat.IsPrivate() {
+			text = getUserName(update.EditedMessage.From) + ": " + text
 		}
 
 		snd := v1.EditMessageRequest{
 			Message: v1.EditMessageRequestMessage{
 				ExternalID: strconv.Itoa(update.EditedMessage.MessageID),
-				Text:       update.EditedMessage.Text,
+				Text:       text,
 			},
 			Channel: b.Channel,
 		}
@@ -678,6 +2579,8 @@ func mgWebhookHandler(c *gin.Context) {
 		return
 	}
 
+	cid = getTelegramChatID(b.ID, cid)
+
 	bot, err := tgbotapi.NewBotAPI(b.Token)
 	if err != nil {
 		logger.Error(b, err)
@@ -687,78 +2590,94 @@ func mgWebhookHandler(c *gin.Context) {
 
 	bot.Debug = config.Debug
 	setLocale(b.Lang)
+	if lang := getChat(b.ID, cid).Lang; lang != "" {
+		setLocale(lang)
+	}
 	mgClient := v1.New(conn.MGURL, conn.MGToken)
 
+	signature, err := getOperatorSignature(b, getOperatorName(msg.Data.User))
+	if err != nil {
+		logger.Error(b.ID, err)
+	}
+
+	now := time.Now().In(getLocation(b.Timezone))
+	quiet := isQuietTime(b.QuietFrom, b.QuietTo, now)
+
 	switch msg.Type {
 	case "message_sent":
-		var mb string
-		var m tgbotapi.Chattable
-
-		switch msg.Data.Type {
-		case v1.MsgTypeProduct:
-			mb = fmt.Sprintf("*%s*\n", replaceMarkdownSymbols(msg.Data.Product.Name))
-
-			if msg.Data.Product.Cost != nil && msg.Data.Product.Cost.Value != 0 {
-				mb += fmt.Sprintf(
-					"\n%s: %s\n",
-					getLocalizedMessage("item_cost"),
-					getLocalizedTemplateMessage(
-						"cost_currency",
-						map[string]interface{}{
-							"Amount":   msg.Data.Product.Cost.Value,
-							"Currency": currency[strings.ToLower(msg.Data.Product.Cost.Currency)],
-						},
-					),
-				)
-			}
+		if isRatingRequest(b, cid, &msg.Data) {
+			requestRating(c, bot, b, cid, quiet, now)
+			return
+		}
 
-			if msg.Data.Product.Url != "" {
-				mb += replaceMarkdownSymbols(msg.Data.Product.Url)
-			} else {
-				mb += replaceMarkdownSymbols(msg.Data.Product.Img)
-			}
-		case v1.MsgTypeOrder:
-			mb = getOrderMessage(msg.Data.Order)
-		case v1.MsgTypeText:
-			mb = replaceMarkdownSymbols(msg.Data.Content)
-		case v1.MsgTypeImage:
-			m, err = photoMessage(msg.Data, mgClient, cid)
-			if err != nil {
-				logger.Errorf(
-					"GetFile request apiURL: %s, clientID: %s, err: %s",
-					conn.APIURL, conn.ClientID, err.Error(),
-				)
-				return
+		renderer := getRenderer(msg.Data.Type)
+		if renderer == nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		ctx := &RenderContext{
+			ChatID:            cid,
+			Signature:         signature,
+			SignaturePosition: b.SignaturePosition,
+			PaymentToken:      b.PaymentToken,
+			RequestPhone:      b.RequestPhone,
+			Files:             mgClient,
+		}
+
+		if b.OrderButton {
+			ctx.Offers = crmCatalog{conn: &conn}
+		}
+
+		if b.PaymentToken != "" {
+			ctx.Orders = crmOrders{conn: &conn}
+		}
+
+		messages, err := renderer.Render(&msg.Data, ctx)
+		if err != nil {
+			logger.Errorf(
+				"Render apiURL: %s, clientID: %s, type: %s, err: %s",
+				conn.APIURL, conn.ClientID, msg.Data.Type, err.Error(),
+			)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		if len(messages) == 0 {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		if ctx.isPhoneRequest(&msg.Data) {
+			if err := setChatPhoneAskedAt(b.ID, cid); err != nil {
+				logger.Error(b.ID, cid, err)
 			}
-		case v1.MsgTypeFile:
-			items := *msg.Data.Items
-			if len(items) > 0 {
-				m, err = documentMessage(items[0], mgClient, cid)
-				if err != nil {
-					logger.Errorf(
-						"GetFile request apiURL: %s, clientID: %s, err: %s",
-						conn.APIURL, conn.ClientID, err.Error(),
-					)
-					return
-				}
+		}
+
+		if action := getChatAction(msg.Data.Type); b.ChatActions && action != "" {
+			if _, err := bot.Send(tgbotapi.NewChatAction(cid, action)); err != nil {
+				logger.Error(b.ID, cid, err)
 			}
 		}
 
-		if mb != "" {
-			m, err = textMessage(cid, mb, msg.Data.QuoteExternalID)
-			if err != nil {
-				c.Error(err)
-				return
+		notifyOperatorJoined(bot, b, cid, getOperatorName(msg.Data.User), quiet)
+
+		if quiet {
+			for i := range messages {
+				messages[i] = silence(messages[i])
 			}
 		}
 
-		msgSend, err := bot.Send(m)
+		msgSend, err := sendRendered(bot, b, cid, messages)
 		if err != nil {
-			logger.Error(err)
-			c.AbortWithStatus(http.StatusBadRequest)
+			abortWithSendError(c, b, cid, err)
 			return
 		}
 
+		if err := setChatOutbound(b.ID, cid); err != nil {
+			logger.Error(b.ID, cid, err)
+		}
+
 		if config.Debug {
 			logger.Debugf("mgWebhookHandler sent %+v", msgSend)
 		}
@@ -766,10 +2685,20 @@ func mgWebhookHandler(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"external_message_id": strconv.Itoa(msgSend.MessageID)})
 
 	case "message_updated":
-		msgSend, err := bot.Send(tgbotapi.NewEditMessageText(cid, uid, replaceMarkdownSymbols(msg.Data.Content)))
+		text := signText(msg.Data.Content, signature, b.SignaturePosition, int(MaxCharsCount))
+		msgSend, err := bot.Send(tgbotapi.NewEditMessageText(cid, uid, replaceMarkdownSymbols(text)))
+		if isTelegramError(err, MessageNotEditableError) && b.EditFallback == EditFallbackResend {
+			m := getEditedMessage(cid, uid, text)
+			m.DisableNotification = quiet
+
+			msgSend, err = bot.Send(m)
+			if err == nil {
+				reportFallback(b, mgClient, msg.Data.ExternalChatID, cid, "message_resent_note")
+			}
+		}
+
 		if err != nil {
-			logger.Error(err)
-			c.AbortWithStatus(http.StatusBadRequest)
+			abortWithSendError(c, b, cid, err)
 			return
 		}
 
@@ -781,9 +2710,25 @@ func mgWebhookHandler(c *gin.Context) {
 
 	case "message_deleted":
 		msgSend, err := bot.Send(tgbotapi.NewDeleteMessage(cid, uid))
+		if isTelegramError(err, MessageNotDeletableError) {
+			switch b.DeleteFallback {
+			case DeleteFallbackNotice:
+				m := tgbotapi.NewMessage(cid, getLocalizedMessage("message_withdrawn"))
+				m.ReplyToMessageID = uid
+				m.DisableNotification = quiet
+
+				msgSend, err = bot.Send(m)
+				if err == nil {
+					reportFallback(b, mgClient, msg.Data.ExternalChatID, cid, "message_withdrawn_note")
+				}
+			case DeleteFallbackIgnore:
+				err = nil
+				reportFallback(b, mgClient, msg.Data.ExternalChatID, cid, "message_not_deleted_note")
+			}
+		}
+
 		if err != nil {
-			logger.Error(err)
-			c.AbortWithStatus(http.StatusBadRequest)
+			abortWithSendError(c, b, cid, err)
 			return
 		}
 
@@ -795,220 +2740,185 @@ func mgWebhookHandler(c *gin.Context) {
 	}
 }
 
-func getOrderMessage(dataOrder *v1.MessageDataOrder) string {
-	mb := "*" + getLocalizedMessage("order")
+// sendRendered sends the rendered messages and maps them to the first one, MG knows the message by the first Telegram message.
+// If a part fails the parts already sent are withdrawn and the error is returned, so that MG does not take the message
+// as delivered and the resent one is not doubled
+func sendRendered(bot *tgbotapi.BotAPI, b *Bot, cid int64, messages []tgbotapi.Chattable) (tgbotapi.Message, error) {
+	var sent []tgbotapi.Message
+	for i, m := range messages {
+		var (
+			res []tgbotapi.Message
+			err error
+		)
 
-	if dataOrder.Number != "" {
-		mb += " " + replaceMarkdownSymbols(dataOrder.Number)
-	}
+		if album, ok := m.(tgbotapi.MediaGroupConfig); ok {
+			res, err = sendMediaGroup(bot, album)
+		} else {
+			var msg tgbotapi.Message
+			msg, err = bot.Send(m)
+			res = []tgbotapi.Message{msg}
+		}
 
-	if dataOrder.Date != "" {
-		mb += fmt.Sprintf(" (%s)", dataOrder.Date)
-	}
-	mb += "*\n"
-	if len(dataOrder.Items) > 0 {
-		mb += "\n"
-		for k, v := range dataOrder.Items {
-			mb += fmt.Sprintf(
-				"%d. %s",
-				k+1,
-				replaceMarkdownSymbols(v.Name),
-			)
+		closeRendered(m)
 
-			if v.Quantity != nil {
-				if v.Quantity.Value != 0 {
-					mb += fmt.Sprintf(
-						" _%v_",
-						v.Quantity.Value,
-					)
-				}
+		if err != nil {
+			for _, v := range messages[i+1:] {
+				closeRendered(v)
 			}
 
-			if v.Price != nil {
-				if val, ok := currency[strings.ToLower(v.Price.Currency)]; ok {
-					mb += fmt.Sprintf(
-						" _x %s_\n",
-						getLocalizedTemplateMessage(
-							"cost_currency",
-							map[string]interface{}{
-								"Amount":   v.Price.Value,
-								"Currency": val,
-							},
-						),
-					)
-				}
-			} else {
-				mb += "\n"
+			if len(sent) > 0 {
+				logger.Errorf("sendRendered bot: %d, chat: %d, sent: %d of %d, err: %s", b.ID, cid, i, len(messages), err.Error())
+				withdrawSent(bot, b, cid, sent)
 			}
+
+			return tgbotapi.Message{}, err
 		}
+
+		sent = append(sent, res...)
 	}
 
-	if dataOrder.Delivery != nil {
-		if dataOrder.Delivery.Name != "" {
-			mb += fmt.Sprintf(
-				"\n*%s:*\n%s",
-				getLocalizedMessage("delivery"),
-				replaceMarkdownSymbols(dataOrder.Delivery.Name),
-			)
-		}
+	if len(sent) == 0 {
+		return tgbotapi.Message{}, nil
+	}
 
-		if dataOrder.Delivery.Price != nil {
-			if val, ok := currency[strings.ToLower(dataOrder.Delivery.Price.Currency)]; ok && dataOrder.Delivery.Price.Value != 0 {
-				mb += fmt.Sprintf(
-					"; %s",
-					getLocalizedTemplateMessage(
-						"cost_currency",
-						map[string]interface{}{
-							"Amount":   dataOrder.Delivery.Price.Value,
-							"Currency": val,
-						},
-					),
-				)
-			}
+	for _, v := range sent[1:] {
+		err := createAlbumMessage(&AlbumMessage{
+			BotID:          b.ID,
+			ExternalID:     cid,
+			MessageID:      v.MessageID,
+			AlbumMessageID: sent[0].MessageID,
+		})
+		if err != nil {
+			logger.Error(b.ID, cid, err)
 		}
+	}
 
-		if dataOrder.Delivery.Address != "" {
-			mb += ";\n" + replaceMarkdownSymbols(dataOrder.Delivery.Address)
-		}
+	return sent[0], nil
+}
 
-		if dataOrder.Delivery.Comment != "" {
-			mb += ";\n" + replaceMarkdownSymbols(dataOrder.Delivery.Comment)
+// withdrawSent deletes the parts of the message sent before the failed one
+func withdrawSent(bot *tgbotapi.BotAPI, b *Bot, cid int64, sent []tgbotapi.Message) {
+	for _, v := range sent {
+		if _, err := bot.Send(tgbotapi.NewDeleteMessage(cid, v.MessageID)); err != nil {
+			logger.Error(b.ID, cid, err)
 		}
-
-		mb += "\n"
 	}
+}
 
-	if len(dataOrder.Payments) > 0 {
-		mb += fmt.Sprintf(
-			"\n*%s:*\n",
-			getLocalizedMessage("payment"),
-		)
-		for _, v := range dataOrder.Payments {
-			mb += replaceMarkdownSymbols(v.Name)
-
-			if v.Amount != nil {
-				if val, ok := currency[strings.ToLower(v.Amount.Currency)]; ok && v.Amount.Value != 0 {
-					mb += fmt.Sprintf(
-						"; %s",
-						getLocalizedTemplateMessage(
-							"cost_currency",
-							map[string]interface{}{
-								"Amount":   v.Amount.Value,
-								"Currency": val,
-							},
-						),
-					)
-				}
-			}
-
-			if v.Status != nil && v.Status.Name != "" {
-				mb += fmt.Sprintf(
-					" (%s)",
-					replaceMarkdownSymbols(v.Status.Name),
-				)
-			}
+// reportFallback posts the note about the operator change Telegram refused into the MG dialog
+func reportFallback(b *Bot, client *v1.MgClient, mgChatID string, cid int64, note string) {
+	setLocale(b.Lang)
 
-			mb += "\n"
-		}
+	snd := v1.SendData{
+		Message: v1.Message{
+			ExternalID: fmt.Sprintf("fallback_%s_%d", mgChatID, time.Now().UnixNano()),
+			Type:       v1.MsgTypeText,
+			Text:       getLocalizedMessage(note),
+		},
+		Originator:     v1.OriginatorChannel,
+		Customer:       v1.Customer{ExternalID: mgChatID},
+		Channel:        b.Channel,
+		ExternalChatID: mgChatID,
 	}
 
-	if dataOrder.Cost != nil {
-		if val, ok := currency[strings.ToLower(dataOrder.Cost.Currency)]; ok && dataOrder.Cost.Value != 0 {
-			mb += fmt.Sprintf(
-				"\n%s: %s",
-				getLocalizedMessage("order_total"),
-				getLocalizedTemplateMessage(
-					"cost_currency",
-					map[string]interface{}{
-						"Amount":   dataOrder.Cost.Value,
-						"Currency": val,
-					},
-				),
-			)
-		}
+	if cid > 0 {
+		getChat(b.ID, cid).setMGCustomer(&snd.Customer)
 	}
 
-	return mb
+	data, st, err := client.Messages(snd)
+	if err != nil {
+		logger.Error(b.Token, err.Error(), st, data)
+	}
 }
 
-func photoMessage(webhookData v1.WebhookData, mgClient *v1.MgClient, cid int64) (chattable tgbotapi.Chattable, err error) {
-	items := *webhookData.Items
+func sendReply(b *Bot, cid int64, text string) error {
+	bot, err := tgbotapi.NewBotAPI(b.Token)
+	if err != nil {
+		return err
+	}
 
-	if len(items) == 1 {
-		v := items
+	bot.Debug = config.Debug
 
-		file, _, err := mgClient.GetFile(v[0].ID)
-		if err != nil {
-			return chattable, err
-		}
+	msgSend, err := bot.Send(tgbotapi.NewMessage(cid, text))
+	if err != nil {
+		return err
+	}
 
-		msg := tgbotapi.NewPhotoUpload(cid, nil)
-		msg.FileID = file.Url
-		msg.UseExisting = true
-		msg.Caption = webhookData.Content
+	if config.Debug {
+		logger.Debugf("sendReply Bot: %v, Message: %+v", b.ID, msgSend)
+	}
 
-		chattable = msg
-	} else if len(items) > 1 {
-		var it []interface{}
+	return nil
+}
 
-		for _, v := range items {
-			file, _, err := mgClient.GetFile(v.ID)
-			if err != nil {
-				logger.Errorf(
-					"GetFile request fileID: %s, err: %s",
-					v.ID, err.Error(),
-				)
-				continue
-			}
+// applyRules sends the reply of the matching auto-reply rule and reports whether the message should be forwarded to MG
+func applyRules(b *Bot, message *tgbotapi.Message) bool {
+	text := message.Text
+	if text == "" {
+		text = message.Caption
+	}
 
-			ip := tgbotapi.NewInputMediaPhoto(file.Url)
-			ip.Caption = webhookData.Content
-			it = append(it, ip)
-		}
+	lang := getCustomerLanguage(getChat(b.ID, message.Chat.ID), message.From)
+	if !isLanguage(lang) {
+		lang = b.Lang
+	}
 
-		chattable = tgbotapi.NewMediaGroup(cid, it)
+	rule := matchRule(b.getRules(), text, getCommand(message, b.Name), lang)
+	if rule == nil {
+		return true
 	}
 
-	return
+	if config.Debug {
+		logger.Debugf("applyRules Bot: %v, Chat: %v, Rule: %+v", b.ID, message.Chat.ID, rule)
+	}
+
+	if err := incrementRuleHits(rule.ID); err != nil {
+		logger.Error(b.ID, rule.ID, err)
+	}
+
+	if err := sendReply(b, message.Chat.ID, rule.Reply); err != nil {
+		logger.Error(b.ID, message.Chat.ID, err)
+	}
+
+	return rule.Forward
 }
 
-func documentMessage(item v1.FileItem, mgClient *v1.MgClient, cid int64) (chattable tgbotapi.Chattable, err error) {
-	file, _, err := mgClient.GetFile(item.ID)
-	if err != nil {
-		return chattable, err
+func sendAwayMessage(b *Bot, cid int64, lang string, now time.Time) error {
+	if !isAwayMessageDue(getChat(b.ID, cid), b.AwayInterval, now) {
+		return nil
 	}
 
-	data, err := http.Get(file.Url)
-	if err != nil {
-		return chattable, err
+	am := getAwayMessage(b.getAwayMessages(), lang, b.Lang)
+	if am == nil {
+		return nil
 	}
 
-	tt := tgbotapi.FileReader{
-		Name:   item.Caption,
-		Reader: data.Body,
-		Size:   int64(item.Size),
+	if err := sendReply(b, cid, am.Text); err != nil {
+		return err
 	}
 
-	chattable = tgbotapi.NewDocumentUpload(cid, tt)
-	return
+	return setChatAwaySentAt(b.ID, cid, now)
 }
 
-func textMessage(cid int64, mb string, quoteExternalID string) (chattable tgbotapi.Chattable, err error) {
-	var qid int
-	m := tgbotapi.NewMessage(cid, mb)
+func abortWithSendError(c *gin.Context, b *Bot, cid int64, err error) {
+	logger.Error(b.ID, cid, err)
 
-	if quoteExternalID != "" {
-		qid, err = strconv.Atoi(quoteExternalID)
-		if err != nil {
-			return
+	if e, ok := err.(tgbotapi.Error); ok && e.MigrateToChatID != 0 {
+		if e := setChatMigratedTo(b.ID, getMGChatID(b.ID, cid), e.MigrateToChatID); e != nil {
+			logger.Error(b.ID, cid, e)
 		}
-		m.ReplyToMessageID = qid
 	}
 
-	m.ParseMode = "Markdown"
+	if isChatUnreachableError(err) {
+		if e := setChatBlocked(b.ID, cid, true); e != nil {
+			logger.Error(b.ID, cid, e)
+		}
+
+		c.AbortWithStatusJSON(BadRequest("error_customer_blocked_bot"))
+		return
+	}
 
-	chattable = m
-	return
+	c.AbortWithStatus(http.StatusBadRequest)
 }
 
 func setAttachment(attachments *tgbotapi.Message, client *v1.MgClient, snd *v1.SendData, botToken string) error {
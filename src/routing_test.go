@@ -10,8 +10,10 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/h2non/gock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -110,6 +112,51 @@ func TestRouting_addBotHandler(t *testing.T) {
 	assert.Equal(t, "123123:Qwerty", res["token"])
 }
 
+func TestRouting_setLangBotHandler(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("https://api.telegram.org").
+		Post("/bot123123:Qwerty/getMe").
+		Times(2).
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":123,"is_bot":true,"first_name":"Test","username":"TestBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot123123:Qwerty/setMyCommands").
+		Times(len(languages) + 1).
+		Reply(200).
+		BodyString(`{"ok":true,"result":true}`)
+
+	req, err := http.NewRequest("POST", "/set-lang/", strings.NewReader(`{"token": "123123:Qwerty", "lang": "es"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code,
+		fmt.Sprintf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK))
+
+	gock.New("https://api.telegram.org").
+		Post("/bot123123:Qwerty/setMyCommands").
+		Reply(400).
+		BodyString(`{"ok":false,"error_code":400,"description":"Bad Request: BOT_COMMAND_INVALID"}`)
+
+	req, err = http.NewRequest("POST", "/set-lang/", strings.NewReader(`{"token": "123123:Qwerty", "lang": "en"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code,
+		fmt.Sprintf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest))
+	assert.Contains(t, rr.Body.String(), getLocalizedMessage("error_registering_commands"))
+	assert.True(t, gock.IsDone())
+}
+
 func TestRouting_deleteBotHandler(t *testing.T) {
 	defer gock.Off()
 
@@ -189,3 +236,1357 @@ func TestRouting_activityHandler(t *testing.T) {
 	assert.Equal(t, http.StatusOK, rr.Code,
 		fmt.Sprintf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK))
 }
+
+func TestRouting_notifyHandlerUnauthorized(t *testing.T) {
+	req, err := http.NewRequest("POST", "/api/notify", strings.NewReader(`{"bot":"TestBot","userId":1,"template":"Hi"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Clientid", "123123")
+	req.Header.Set("X-Notify-Token", "wrong-token")
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+type testCloser struct {
+	strings.Reader
+	closed int
+}
+
+func (c *testCloser) Close() error {
+	c.closed++
+	return nil
+}
+
+func TestRouting_sendRenderedPartialFailure(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("https://api.telegram.org").
+		Post("/bot123123:Qwerty/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":123,"is_bot":true,"first_name":"Test","username":"TestBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot123123:Qwerty/sendMessage").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"message_id":5,"date":0,"chat":{"id":1,"type":"private"}}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot123123:Qwerty/sendMessage").
+		Reply(400).
+		BodyString(`{"ok":false,"error_code":400,"description":"Bad Request: message is too long"}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot123123:Qwerty/deleteMessage").
+		BodyString(`message_id=5`).
+		Reply(200).
+		BodyString(`{"ok":true,"result":true}`)
+
+	bot, err := tgbotapi.NewBotAPI("123123:Qwerty")
+	require.NoError(t, err)
+
+	file := &testCloser{}
+	messages := []tgbotapi.Chattable{
+		tgbotapi.NewMessage(1, "first"),
+		tgbotapi.NewMessage(1, "second"),
+		tgbotapi.NewDocumentUpload(1, tgbotapi.FileReader{Name: "file.txt", Reader: file, Size: -1}),
+	}
+
+	_, err = sendRendered(bot, &Bot{ID: 1}, 1, messages)
+	assert.Error(t, err)
+	assert.Equal(t, 1, file.closed)
+	assert.True(t, gock.IsDone())
+}
+
+func TestRouting_sendCampaignBatch(t *testing.T) {
+	defer gock.Off()
+
+	b := Bot{ConnectionID: 1, Channel: 1234, Token: "123124:Campaign", Lang: "en"}
+	require.NoError(t, orm.DB.Create(&b).Error)
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	var campaigns []Campaign
+	for i := 1; i <= 2; i++ {
+		c := Campaign{BotID: b.ID, Name: fmt.Sprintf("Campaign %d", i), Text: "Sale", Status: CampaignStatusRunning}
+		require.NoError(t, orm.DB.Create(&c).Error)
+		require.NoError(t, orm.DB.Create(&CampaignRecipient{CampaignID: c.ID, ExternalID: int64(i), Status: RecipientStatusPending}).Error)
+		campaigns = append(campaigns, c)
+	}
+
+	defer orm.DB.Delete(Campaign{}, "bot_id = ?", b.ID)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot123124:Campaign/getMe").
+		Times(2).
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":124,"is_bot":true,"first_name":"Test","username":"CampaignBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot123124:Campaign/sendMessage").
+		Times(2).
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"message_id":1,"date":1560000000,"chat":{"id":1,"type":"private"}}}`)
+
+	for i := range campaigns {
+		n, err := sendCampaignBatch(&campaigns[i])
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.Equal(t, CampaignStatusRunning, getCampaignStatus(campaigns[i].ID))
+	}
+
+	assert.True(t, gock.IsDone())
+
+	for i := range campaigns {
+		assert.Equal(t, map[string]int{RecipientStatusSent: 1}, getCampaignStats(campaigns[i].ID))
+
+		n, err := sendCampaignBatch(&campaigns[i])
+		require.NoError(t, err)
+		assert.Equal(t, 0, n)
+		assert.Equal(t, CampaignStatusFinished, getCampaignStatus(campaigns[i].ID))
+	}
+}
+
+// createTestBot stores the bot of the test connection
+func createTestBot(t *testing.T, b Bot) *Bot {
+	b.ConnectionID = 1
+	require.NoError(t, orm.DB.Create(&b).Error)
+
+	return &b
+}
+
+// serveJSON posts the JSON body to the router and returns the response
+func serveJSON(t *testing.T, path, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	return rr
+}
+
+func TestRouting_telegramWebhookMyChatMember(t *testing.T) {
+	b := createTestBot(t, Bot{Channel: 2601, Token: "2601:Blocked", Name: "BlockedBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	update := `{"update_id":1,"my_chat_member":{"chat":{"id":26,"type":"private"},"from":{"id":26,"first_name":"John"},"date":1,` +
+		`"old_chat_member":{"user":{"id":2601},"status":"%s"},"new_chat_member":{"user":{"id":2601},"status":"%s"}}}`
+
+	rr := serveJSON(t, "/telegram/2601:Blocked", fmt.Sprintf(update, "member", "kicked"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, getChat(b.ID, 26).Blocked)
+
+	rr = serveJSON(t, "/telegram/2601:Blocked", fmt.Sprintf(update, "kicked", "member"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.False(t, getChat(b.ID, 26).Blocked)
+}
+
+func TestRouting_mgWebhookBlockedBot(t *testing.T) {
+	defer gock.Off()
+
+	b := createTestBot(t, Bot{Channel: 2602, Token: "2602:Blocked", Name: "BlockedBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot2602:Blocked/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":2602,"is_bot":true,"first_name":"Test","username":"BlockedBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot2602:Blocked/sendMessage").
+		Reply(403).
+		BodyString(`{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`)
+
+	req, err := http.NewRequest("POST", "/webhook/", strings.NewReader(
+		`{"type":"message_sent","data":{"external_user_id":"26","external_chat_id":"26","channel_id":2602,"content":"Hello","type":"text"}}`,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Clientid", "123123")
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), getLocalizedMessage("error_customer_blocked_bot"))
+	assert.True(t, getChat(b.ID, 26).Blocked)
+	assert.True(t, gock.IsDone())
+}
+
+func TestRouting_setGroupPolicyBotHandler(t *testing.T) {
+	b := createTestBot(t, Bot{Channel: 2701, Token: "2701:Group", Name: "GroupBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	rr := serveJSON(t, "/set-group-policy/", `{"token": "2701:Group", "groupPolicy": "all"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = serveJSON(t, "/set-group-policy/", `{"token": "2701:Group", "groupPolicy": "bridge"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, GroupPolicyBridge, getBotByID(b.ID).GroupPolicy)
+}
+
+func TestRouting_telegramWebhookGroup(t *testing.T) {
+	defer gock.Off()
+
+	b := createTestBot(t, Bot{Channel: 2702, Token: "2702:Group", Name: "GroupBot", Lang: "en", GroupPolicy: GroupPolicyIgnore})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	gock.New("https://test.retailcrm.pro").
+		Post("/api/transport/v1/messages").
+		Reply(200).
+		BodyString(`{"message_id":1,"time":"2019-06-01T10:00:00Z"}`)
+
+	rr := serveJSON(t, "/telegram/2702:Group",
+		`{"update_id":1,"message":{"message_id":1,"from":{"id":27,"first_name":"John"},"chat":{"id":-27,"type":"group","title":"Team"},"date":1,"text":"Hello"}}`,
+	)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, gock.IsPending(), "the group messages are ignored")
+
+	b.GroupPolicy = GroupPolicyBridge
+	require.NoError(t, b.save())
+
+	rr = serveJSON(t, "/telegram/2702:Group",
+		`{"update_id":2,"message":{"message_id":2,"from":{"id":27,"first_name":"John"},"chat":{"id":-27,"type":"group","title":"Team"},"date":1,"migrate_to_chat_id":-1000027}}`,
+	)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, int64(-1000027), getChat(b.ID, -27).MigratedToID)
+	assert.Equal(t, int64(-1000027), getTelegramChatID(b.ID, -27))
+	assert.Equal(t, int64(-27), getMGChatID(b.ID, -1000027))
+	assert.True(t, gock.IsPending(), "the service messages are not forwarded")
+}
+
+func TestRouting_addPayloadSourceHandler(t *testing.T) {
+	defer gock.Off()
+
+	b := createTestBot(t, Bot{Channel: 2801, Token: "2801:Payload", Name: "PayloadBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/credentials").
+		Reply(200).
+		BodyString(`{"success": true, "credentials": ["/api/customers/{externalId}/edit"]}`)
+
+	rr := serveJSON(t, "/add-payload-source/", `{"token": "2801:Payload", "prefix": "fb", "source": "facebook"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "/api/customers/create")
+	assert.Empty(t, b.getPayloadSources())
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/credentials").
+		Reply(200).
+		BodyString(`{"success": true, "credentials": ["/api/customers/{externalId}/edit", "/api/customers/create"]}`)
+
+	rr = serveJSON(t, "/add-payload-source/", `{"token": "2801:Payload", "prefix": "fb", "source": "facebook"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, gock.IsDone())
+
+	sources := b.getPayloadSources()
+	require.Len(t, sources, 1)
+	assert.Equal(t, "facebook", sources[0].Source)
+
+	rr = serveJSON(t, "/delete-payload-source/", fmt.Sprintf(`{"token": "2801:Payload", "id": %d}`, sources[0].ID))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, b.getPayloadSources())
+}
+
+func TestRouting_telegramWebhookStartPayload(t *testing.T) {
+	defer gock.Off()
+
+	b := createTestBot(t, Bot{Channel: 2802, Token: "2802:Payload", Name: "PayloadBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	require.NoError(t, b.createPayloadSource(PayloadSource{Prefix: "fb", Source: "facebook"}))
+
+	now := time.Now()
+	require.NoError(t, orm.DB.Create(&Chat{BotID: b.ID, ExternalID: 28, LastInboundAt: &now}).Error)
+	orm.DB.Delete(User{}, "external_id = ?", 28)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot2802:Payload/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":2802,"is_bot":true,"first_name":"Test","username":"PayloadBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot2802:Payload/getUserProfilePhotos").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"total_count":0,"photos":[]}}`)
+
+	gock.New("https://test.retailcrm.ru").
+		Post("/api/v5/customers/telegram_28/edit").
+		BodyString(`facebook`).
+		Reply(200).
+		BodyString(`{"success":true,"id":28}`)
+
+	gock.New("https://test.retailcrm.pro").
+		Post("/api/transport/v1/messages").
+		BodyString(`fb_summer`).
+		Reply(200).
+		BodyString(`{"message_id":1,"time":"2019-06-01T10:00:00Z"}`)
+
+	rr := serveJSON(t, "/telegram/2802:Payload",
+		`{"update_id":1,"message":{"message_id":1,"from":{"id":28,"first_name":"John"},"chat":{"id":28,"type":"private"},"date":1,`+
+			`"text":"/start fb_summer","entities":[{"type":"bot_command","offset":0,"length":6}]}}`,
+	)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "fb_summer", getChat(b.ID, 28).StartPayload)
+	assert.True(t, gock.IsDone())
+}
+
+func TestRouting_deepLinkHandlers(t *testing.T) {
+	b := createTestBot(t, Bot{Channel: 2901, Token: "2901:Link", Name: "LinkBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	rr := serveJSON(t, "/add-deep-link/", `{"token": "2901:Link", "name": "Summer", "payload": "summer sale"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = serveJSON(t, "/add-deep-link/", `{"token": "2901:Link", "name": "Summer", "payload": "summer_sale"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var res map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+	assert.Equal(t, "https://t.me/LinkBot?start=summer_sale", res["url"])
+
+	links := b.getDeepLinks()
+	require.Len(t, links, 1)
+
+	for file, contentType := range map[string]string{"qr.png": "image/png", "qr.svg": "image/svg+xml"} {
+		req, err := http.NewRequest("GET", fmt.Sprintf("/deep-link/123123/%d/%s", links[0].ID, file), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, contentType, rr.Header().Get("Content-Type"))
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/deep-link/123123/%d/qr.gif", links[0].ID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = serveJSON(t, "/delete-deep-link/", fmt.Sprintf(`{"token": "2901:Link", "id": %d}`, links[0].ID))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, b.getDeepLinks())
+}
+
+// createTestChat stores the private chat which has already written to the bot, so the webhook does not link it to the
+// CRM customer, and forgets the user so that the profile photos are requested again
+func createTestChat(t *testing.T, b *Bot, id int64) {
+	now := time.Now()
+	require.NoError(t, orm.DB.Create(&Chat{BotID: b.ID, ExternalID: id, LastInboundAt: &now}).Error)
+	orm.DB.Delete(User{}, "external_id = ?", id)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot" + b.Token + "/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"Test","username":"` + b.Name + `"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot" + b.Token + "/getUserProfilePhotos").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"total_count":0,"photos":[]}}`)
+}
+
+func TestRouting_commandHandlers(t *testing.T) {
+	defer gock.Off()
+
+	b := createTestBot(t, Bot{Channel: 3001, Token: "3001:Command", Name: "CommandBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	rr := serveJSON(t, "/add-command/", `{"token": "3001:Command", "name": "help", "lang": "en", "description": "Help"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), getLocalizedMessage("incorrect_command"))
+
+	gock.New("https://api.telegram.org").
+		Post("/bot3001:Command/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":3001,"is_bot":true,"first_name":"Test","username":"CommandBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot3001:Command/setMyCommands").
+		Times(len(languages) + 1).
+		Reply(200).
+		BodyString(`{"ok":true,"result":true}`)
+
+	rr = serveJSON(t, "/add-command/", `{"token": "3001:Command", "name": "help", "lang": "en", "description": "Help", "reply": "Hello"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, gock.IsDone())
+
+	commands := b.getCommands()
+	require.Len(t, commands, 1)
+
+	createTestChat(t, b, 30)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot3001:Command/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":3001,"is_bot":true,"first_name":"Test","username":"CommandBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot3001:Command/sendMessage").
+		BodyString(`text=Hello`).
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"message_id":2,"date":1,"chat":{"id":30,"type":"private"}}}`)
+
+	rr = serveJSON(t, "/telegram/3001:Command",
+		`{"update_id":1,"message":{"message_id":1,"from":{"id":30,"first_name":"John"},"chat":{"id":30,"type":"private"},"date":1,`+
+			`"text":"/help","entities":[{"type":"bot_command","offset":0,"length":5}]}}`,
+	)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, gock.IsDone(), "the command is answered by the bot and is not forwarded")
+
+	gock.New("https://api.telegram.org").
+		Post("/bot3001:Command/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":3001,"is_bot":true,"first_name":"Test","username":"CommandBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot3001:Command/setMyCommands").
+		Times(len(languages) + 1).
+		Reply(200).
+		BodyString(`{"ok":true,"result":true}`)
+
+	rr = serveJSON(t, "/delete-command/", fmt.Sprintf(`{"token": "3001:Command", "id": %d}`, commands[0].ID))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, b.getCommands())
+	assert.True(t, gock.IsDone())
+}
+
+func TestRouting_scheduleHandlers(t *testing.T) {
+	defer gock.Off()
+
+	b := createTestBot(t, Bot{Channel: 3101, Token: "3101:Schedule", Name: "ScheduleBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	rr := serveJSON(t, "/set-schedule/", `{"token": "3101:Schedule", "timezone": "Mars/Olympus", "awayInterval": 1}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = serveJSON(t, "/set-schedule/", `{"token": "3101:Schedule", "timezone": "UTC", "awayInterval": 1, "awayNote": true}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, getBotByID(b.ID).AwayNote)
+
+	rr = serveJSON(t, "/add-business-hours/", `{"token": "3101:Schedule", "weekday": 1, "opens": "18:00", "closes": "09:00"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	weekday := (int(time.Now().UTC().Weekday()) + 1) % 7
+	rr = serveJSON(t, "/add-business-hours/", fmt.Sprintf(`{"token": "3101:Schedule", "weekday": %d, "opens": "00:00", "closes": "23:59"}`, weekday))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	require.Len(t, b.getBusinessHours(), 1)
+
+	rr = serveJSON(t, "/add-holiday/", `{"token": "3101:Schedule", "date": "31.12.2019"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = serveJSON(t, "/add-holiday/", `{"token": "3101:Schedule", "date": "2019-12-31", "name": "New Year"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	holidays := b.getHolidays()
+	require.Len(t, holidays, 1)
+
+	rr = serveJSON(t, "/delete-holiday/", fmt.Sprintf(`{"token": "3101:Schedule", "id": %d}`, holidays[0].ID))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, b.getHolidays())
+
+	rr = serveJSON(t, "/add-away-message/", `{"token": "3101:Schedule", "lang": "xx", "text": "Closed"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = serveJSON(t, "/add-away-message/", `{"token": "3101:Schedule", "lang": "en", "text": "Closed"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	createTestChat(t, b, 31)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot3101:Schedule/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":3101,"is_bot":true,"first_name":"Test","username":"ScheduleBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot3101:Schedule/sendMessage").
+		BodyString(`text=Closed`).
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"message_id":2,"date":1,"chat":{"id":31,"type":"private"}}}`)
+
+	gock.New("https://test.retailcrm.pro").
+		Post("/api/transport/v1/messages").
+		BodyString(`out of business hours`).
+		Reply(200).
+		BodyString(`{"message_id":1,"time":"2019-06-01T10:00:00Z"}`)
+
+	rr = serveJSON(t, "/telegram/3101:Schedule",
+		`{"update_id":1,"message":{"message_id":1,"from":{"id":31,"first_name":"John"},"chat":{"id":31,"type":"private"},"date":1,"text":"Hello"}}`,
+	)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotNil(t, getChat(b.ID, 31).AwaySentAt)
+	assert.True(t, gock.IsDone())
+
+	messages := b.getAwayMessages()
+	require.Len(t, messages, 1)
+
+	rr = serveJSON(t, "/delete-away-message/", fmt.Sprintf(`{"token": "3101:Schedule", "id": %d}`, messages[0].ID))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = serveJSON(t, "/delete-business-hours/", fmt.Sprintf(`{"token": "3101:Schedule", "id": %d}`, b.getBusinessHours()[0].ID))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, b.getBusinessHours())
+}
+
+func TestRouting_ruleHandlers(t *testing.T) {
+	defer gock.Off()
+
+	b := createTestBot(t, Bot{Channel: 3201, Token: "3201:Rule", Name: "RuleBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	rr := serveJSON(t, "/save-rule/", `{"token": "3201:Rule", "type": "regex", "pattern": "(", "reply": "Oops", "active": true}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = serveJSON(t, "/save-rule/", `{"token": "3201:Rule", "type": "keyword", "pattern": "price, cost", "reply": "From 10$", "active": true}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = serveJSON(t, "/save-rule/", `{"token": "3201:Rule", "type": "regex", "pattern": "^order", "reply": "Wait", "active": true}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rules := b.getRules()
+	require.Len(t, rules, 2)
+
+	rr = serveJSON(t, "/move-rule/", fmt.Sprintf(`{"token": "3201:Rule", "id": %d, "up": true}`, rules[1].ID))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = serveJSON(t, "/set-rule-active/", fmt.Sprintf(`{"token": "3201:Rule", "id": %d, "active": false}`, rules[1].ID))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	moved := b.getRules()
+	require.Len(t, moved, 2)
+	assert.Equal(t, rules[1].ID, moved[0].ID)
+	assert.False(t, moved[0].Active)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot3201:Rule/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":3201,"is_bot":true,"first_name":"Test","username":"RuleBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot3201:Rule/sendMessage").
+		BodyString(`From`).
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"message_id":2,"date":1,"chat":{"id":32,"type":"private"}}}`)
+
+	rr = serveJSON(t, "/telegram/3201:Rule",
+		`{"update_id":1,"message":{"message_id":1,"from":{"id":32,"first_name":"John"},"chat":{"id":32,"type":"private"},"date":1,"text":"What is the price?"}}`,
+	)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, gock.IsDone(), "the message is answered by the rule and is not forwarded")
+	assert.Equal(t, 1, b.getRules()[1].Hits)
+
+	rr = serveJSON(t, "/delete-rule/", fmt.Sprintf(`{"token": "3201:Rule", "id": %d}`, rules[0].ID))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, b.getRules(), 1)
+}
+
+func TestRouting_spamFilterHandlers(t *testing.T) {
+	b := createTestBot(t, Bot{Channel: 3301, Token: "3301:Spam", Name: "SpamBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	rr := serveJSON(t, "/set-spam-filter/", `{"token": "3301:Spam", "floodLimit": -1, "floodMute": 10}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = serveJSON(t, "/set-spam-filter/", `{"token": "3301:Spam", "floodLimit": 20, "floodMute": 10}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 20, getBotByID(b.ID).FloodLimit)
+
+	rr = serveJSON(t, "/add-blocked-user/", `{"token": "3301:Spam", "userId": 0}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = serveJSON(t, "/add-blocked-user/", `{"token": "3301:Spam", "userId": 33, "comment": "Spammer"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = serveJSON(t, "/telegram/3301:Spam",
+		`{"update_id":1,"message":{"message_id":1,"from":{"id":33,"first_name":"John"},"chat":{"id":33,"type":"private"},"date":1,"text":"Buy now"}}`,
+	)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = serveJSON(t, "/telegram/3301:Spam",
+		`{"update_id":2,"message":{"message_id":2,"from":{"id":34,"is_bot":true,"first_name":"Bot"},"chat":{"id":34,"type":"private"},"date":1,"text":"Buy now"}}`,
+	)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	bu := getBlockedUser(b.ID, 33)
+	assert.Equal(t, 1, bu.Hits)
+	assert.Equal(t, 2, getBotByID(b.ID).BlockedMessages)
+	assert.Equal(t, int64(0), getChat(b.ID, 33).ExternalID, "the blocked messages do not start the chat")
+
+	rr = serveJSON(t, "/delete-blocked-user/", fmt.Sprintf(`{"token": "3301:Spam", "id": %d}`, bu.ID))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, b.getBlockedUsers())
+}
+
+func TestRouting_campaignHandlers(t *testing.T) {
+	defer gock.Off()
+
+	b := createTestBot(t, Bot{Channel: 3401, Token: "3401:Campaign", Name: "CampaignBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	require.NoError(t, saveChat(b.ID, 34))
+	require.NoError(t, saveChat(b.ID, 35))
+
+	gock.New("https://api.telegram.org").
+		Post("/bot3401:Campaign/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":3401,"is_bot":true,"first_name":"Test","username":"CampaignBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot3401:Campaign/sendMessage").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"message_id":2,"date":1,"chat":{"id":35,"type":"private"}}}`)
+
+	rr := serveJSON(t, "/telegram/3401:Campaign",
+		`{"update_id":1,"message":{"message_id":1,"from":{"id":35,"first_name":"John"},"chat":{"id":35,"type":"private"},"date":1,`+
+			`"text":"/stop","entities":[{"type":"bot_command","offset":0,"length":5}]}}`,
+	)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, getChat(b.ID, 35).OptedOut)
+	assert.True(t, gock.IsDone(), "the customer is told the campaigns are stopped and the command is not forwarded")
+
+	rr = serveJSON(t, "/add-campaign/", `{"token": "3401:Campaign", "name": "Sale", "text": "Sale", "buttons": "Shop"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = serveJSON(t, "/add-campaign/", `{"token": "3401:Campaign", "name": "Sale", "text": "Sale", "buttons": "Shop | https://example.com"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	campaigns := b.getCampaigns()
+	require.Len(t, campaigns, 1)
+	assert.Equal(t, CampaignStatusDraft, campaigns[0].Status)
+
+	id := fmt.Sprintf(`{"token": "3401:Campaign", "id": %d}`, campaigns[0].ID)
+
+	rr = serveJSON(t, "/pause-campaign/", id)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = serveJSON(t, "/start-campaign/", id)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, CampaignStatusRunning, getCampaignStatus(campaigns[0].ID))
+	assert.Equal(t, map[string]int{RecipientStatusPending: 1}, getCampaignStats(campaigns[0].ID), "the opted out chat is skipped")
+
+	rr = serveJSON(t, "/delete-campaign/", id)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, b.getCampaigns(), 1, "the running campaign is not deleted")
+
+	rr = serveJSON(t, "/pause-campaign/", id)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, CampaignStatusPaused, getCampaignStatus(campaigns[0].ID))
+
+	rr = serveJSON(t, "/delete-campaign/", id)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, b.getCampaigns())
+}
+
+func TestRouting_notifyHandler(t *testing.T) {
+	defer gock.Off()
+
+	b := createTestBot(t, Bot{Channel: 3501, Token: "3501:Notify", Name: "NotifyBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	rr := serveJSON(t, "/generate-notify-token/", `{"clientId": "123123", "apiKey": "wrong", "rotate": true}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = serveJSON(t, "/generate-notify-token/", `{"clientId": "123123", "apiKey": "test", "rotate": true}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var res map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+
+	token, _ := res["token"].(string)
+	require.NotEmpty(t, token)
+
+	rr = serveJSON(t, "/generate-notify-token/", `{"clientId": "123123", "apiKey": "test"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "the existing token is replaced only on rotation")
+
+	gock.New("https://api.telegram.org").
+		Post("/bot3501:Notify/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":3501,"is_bot":true,"first_name":"Test","username":"NotifyBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot3501:Notify/sendMessage").
+		BodyString(`Order\+25C\+is\+shipped`).
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"message_id":7,"date":1,"chat":{"id":35,"type":"private"}}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot3501:Notify/getChat").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":35,"type":"private","username":"john"}}`)
+
+	gock.New("https://test.retailcrm.pro").
+		Post("/api/transport/v1/messages").
+		BodyString(`"external_id":"7"`).
+		Reply(200).
+		BodyString(`{"message_id":1,"time":"2019-06-01T10:00:00Z"}`)
+
+	req, err := http.NewRequest("POST", "/api/notify",
+		strings.NewReader(`{"bot":"@NotifyBot","userId":35,"template":"Order {{.Number}} is shipped","data":{"Number":"25C"}}`),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Clientid", "123123")
+	req.Header.Set("X-Notify-Token", token)
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"external_message_id":"7","mirrored":true}`, rr.Body.String())
+	assert.True(t, gock.IsDone())
+}
+
+func TestRouting_telegramWebhookLinkCustomer(t *testing.T) {
+	defer gock.Off()
+
+	b := createTestBot(t, Bot{Channel: 3601, Token: "3601:Link", Name: "LinkBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	orm.DB.Delete(User{}, "external_id = ?", 36)
+	credentialsCache.Lock()
+	delete(credentialsCache.entries, 1)
+	credentialsCache.Unlock()
+
+	gock.New("https://api.telegram.org").
+		Post("/bot3601:Link/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":3601,"is_bot":true,"first_name":"Test","username":"LinkBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot3601:Link/getUserProfilePhotos").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"total_count":0,"photos":[]}}`)
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/credentials").
+		Reply(200).
+		BodyString(`{"success": true, "credentials": ["/api/customers", "/api/customers/{externalId}", "/api/customers/{externalId}/edit", "/api/customers/create"]}`)
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/v5/customers/telegram_36").
+		Reply(200).
+		BodyString(`{"success":true,"customer":{"id":360,"externalId":"telegram_36","firstName":"Ivan","phones":[{"number":"+79990000036"}]}}`)
+
+	gock.New("https://test.retailcrm.pro").
+		Post("/api/transport/v1/messages").
+		BodyString(`"customer":\{"external_id":"36","nickname":"John","first_name":"Ivan","phone":"\+79990000036"\}`).
+		Reply(200).
+		BodyString(`{"message_id":1,"time":"2019-06-01T10:00:00Z"}`)
+
+	rr := serveJSON(t, "/telegram/3601:Link",
+		`{"update_id":1,"message":{"message_id":1,"from":{"id":36,"first_name":"John"},"chat":{"id":36,"type":"private"},"date":1,"text":"Hello"}}`,
+	)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, gock.IsDone())
+
+	chat := getChat(b.ID, 36)
+	assert.Equal(t, 360, chat.CustomerID)
+	assert.Equal(t, "telegram_36", chat.CustomerExtID)
+	assert.NotNil(t, chat.LastInboundAt)
+}
+
+func TestRouting_setRequestPhoneHandler(t *testing.T) {
+	defer gock.Off()
+
+	b := createTestBot(t, Bot{Channel: 3701, Token: "3701:Phone", Name: "PhoneBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/credentials").
+		Reply(200).
+		BodyString(`{"success": true, "credentials": ["/api/customers"]}`)
+
+	rr := serveJSON(t, "/set-request-phone/", `{"token": "3701:Phone", "requestPhone": true}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.False(t, getBotByID(b.ID).RequestPhone)
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/credentials").
+		Reply(200).
+		BodyString(`{"success": true, "credentials": ["/api/customers", "/api/customers/{externalId}", "/api/customers/{externalId}/edit", "/api/customers/create"]}`)
+
+	rr = serveJSON(t, "/set-request-phone/", `{"token": "3701:Phone", "requestPhone": true}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, getBotByID(b.ID).RequestPhone)
+	assert.True(t, gock.IsDone())
+
+	rr = serveJSON(t, "/set-request-phone/", `{"token": "3701:Phone", "requestPhone": false}`)
+	assert.Equal(t, http.StatusOK, rr.Code, "the credentials are not checked when the feature is turned off")
+	assert.False(t, getBotByID(b.ID).RequestPhone)
+}
+
+func TestRouting_setOrdersHandler(t *testing.T) {
+	defer gock.Off()
+
+	b := createTestBot(t, Bot{Channel: 3801, Token: "3801:Order", Name: "OrderBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/credentials").
+		Reply(200).
+		BodyString(`{"success": true, "credentials": ["/api/store/products"]}`)
+
+	rr := serveJSON(t, "/set-orders/", `{"token": "3801:Order", "orderButton": true, "orderStatus": "draft"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "/api/orders/create")
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/credentials").
+		Reply(200).
+		BodyString(`{"success": true, "credentials": ["/api/customers/{externalId}", "/api/customers/create", "/api/store/products", "/api/orders/create"]}`)
+
+	rr = serveJSON(t, "/set-orders/", `{"token": "3801:Order", "orderButton": true, "orderStatus": " draft ", "orderMethod": "messenger"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, gock.IsDone())
+
+	saved := getBotByID(b.ID)
+	assert.True(t, saved.OrderButton)
+	assert.Equal(t, "draft", saved.OrderStatus)
+	assert.Equal(t, "messenger", saved.OrderMethod)
+}
+
+func TestRouting_setPaymentsHandler(t *testing.T) {
+	defer gock.Off()
+
+	b := createTestBot(t, Bot{Channel: 3901, Token: "3901:Payment", Name: "PaymentBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	rr := serveJSON(t, "/set-payments/", `{"token": "3901:Payment", "paymentToken": "provider-token"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), getLocalizedMessage("incorrect_payments"))
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/credentials").
+		Reply(200).
+		BodyString(`{"success": true, "credentials": ["/api/orders", "/api/orders/payments/create"]}`)
+
+	rr = serveJSON(t, "/set-payments/", `{"token": "3901:Payment", "paymentToken": "provider-token", "paymentType": "card", "paymentStatus": "paid"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "provider-token", getBotByID(b.ID).PaymentToken)
+	assert.True(t, gock.IsDone())
+}
+
+func TestRouting_telegramWebhookPreCheckout(t *testing.T) {
+	defer gock.Off()
+
+	b := createTestBot(t, Bot{Channel: 3902, Token: "3902:Payment", Name: "PaymentBot", Lang: "en", PaymentToken: "provider-token", PaymentType: "card"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	query := `{"update_id":%d,"pre_checkout_query":{"id":"q%d","from":{"id":39,"first_name":"John"},"currency":"RUB",` +
+		`"total_amount":%d,"invoice_payload":"order:1234C"}}`
+
+	for i, v := range []struct {
+		amount int
+		ok     string
+	}{
+		{235050, "ok=true"},
+		{100000, "ok=false"},
+	} {
+		gock.New("https://test.retailcrm.ru").
+			Get("/api/v5/orders").
+			MatchParam("filter[numbers][]", "1234C").
+			Reply(200).
+			BodyString(`{"success":true,"orders":[{"id":25,"number":"1234C","totalSumm":2350.5,"currency":"RUB"}]}`)
+
+		gock.New("https://api.telegram.org").
+			Post("/bot3902:Payment/getMe").
+			Reply(200).
+			BodyString(`{"ok":true,"result":{"id":3902,"is_bot":true,"first_name":"Test","username":"PaymentBot"}}`)
+
+		gock.New("https://api.telegram.org").
+			Post("/bot3902:Payment/answerPreCheckoutQuery").
+			BodyString(v.ok).
+			Reply(200).
+			BodyString(`{"ok":true,"result":true}`)
+
+		rr := serveJSON(t, "/telegram/3902:Payment", fmt.Sprintf(query, i+1, i+1, v.amount))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.True(t, gock.IsDone(), v.ok)
+	}
+}
+
+func TestRouting_rating(t *testing.T) {
+	defer gock.Off()
+
+	b := createTestBot(t, Bot{Channel: 4001, Token: "4001:Rating", Name: "RatingBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	rr := serveJSON(t, "/set-csat/", `{"token": "4001:Rating", "csat": true}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, getBotByID(b.ID).Csat)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot4001:Rating/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":4001,"is_bot":true,"first_name":"Test","username":"RatingBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot4001:Rating/sendMessage").
+		BodyString(`rate%3A5`).
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"message_id":9,"date":1,"chat":{"id":40,"type":"private"}}}`)
+
+	req, err := http.NewRequest("POST", "/webhook/", strings.NewReader(
+		`{"type":"message_sent","data":{"external_user_id":"40","external_chat_id":"40","channel_id":4001,"content":"/rate","type":"text"}}`,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Clientid", "123123")
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"external_message_id":"9"}`, rr.Body.String())
+	assert.True(t, getChat(b.ID, 40).DialogClosed)
+	assert.True(t, gock.IsDone())
+
+	callback := `{"update_id":%d,"callback_query":{"id":"cb%d","from":{"id":40,"first_name":"John"},` +
+		`"message":{"message_id":9,"chat":{"id":40,"type":"private"},"date":1},"data":"rate:5"}}`
+
+	gock.New("https://api.telegram.org").
+		Post("/bot4001:Rating/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":4001,"is_bot":true,"first_name":"Test","username":"RatingBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot4001:Rating/answerCallbackQuery").
+		Reply(200).
+		BodyString(`{"ok":true,"result":true}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot4001:Rating/editMessageText").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"message_id":9,"date":1,"chat":{"id":40,"type":"private"}}}`)
+
+	gock.New("https://test.retailcrm.pro").
+		Post("/api/transport/v1/messages").
+		BodyString(`rate_cb1`).
+		Reply(200).
+		BodyString(`{"message_id":1,"time":"2019-06-01T10:00:00Z"}`)
+
+	rr = serveJSON(t, "/telegram/4001:Rating", fmt.Sprintf(callback, 1, 1))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, gock.IsDone())
+	assert.NotZero(t, getChat(b.ID, 40).RatingID)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot4001:Rating/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":4001,"is_bot":true,"first_name":"Test","username":"RatingBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot4001:Rating/answerCallbackQuery").
+		BodyString(`callback_query_id=cb2`).
+		Reply(200).
+		BodyString(`{"ok":true,"result":true}`)
+
+	rr = serveJSON(t, "/telegram/4001:Rating", fmt.Sprintf(callback, 2, 2))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, gock.IsDone(), "the dialog is rated once")
+
+	stats := b.getRatingStats()
+	assert.Equal(t, 1, stats.Count)
+	assert.Equal(t, 1, stats.Scores[4])
+}
+
+func TestRouting_operatorNotifications(t *testing.T) {
+	defer gock.Off()
+
+	b := createTestBot(t, Bot{Channel: 4101, Token: "4101:Operator", Name: "OperatorBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	rr := serveJSON(t, "/set-operator-notifications/", `{"token": "4101:Operator", "operatorJoined": true, "operatorJoinedText": "{{.Nam"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = serveJSON(t, "/set-operator-notifications/",
+		`{"token": "4101:Operator", "operatorJoined": true, "operatorJoinedText": "{{.Name}} will help you", "chatActions": true}`,
+	)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, getBotByID(b.ID).ChatActions)
+
+	for i := 1; i <= 2; i++ {
+		gock.New("https://api.telegram.org").
+			Post("/bot4101:Operator/getMe").
+			Reply(200).
+			BodyString(`{"ok":true,"result":{"id":4101,"is_bot":true,"first_name":"Test","username":"OperatorBot"}}`)
+
+		if i == 1 {
+			gock.New("https://api.telegram.org").
+				Post("/bot4101:Operator/sendMessage").
+				BodyString(`text=Ann\+will\+help\+you`).
+				Reply(200).
+				BodyString(`{"ok":true,"result":{"message_id":10,"date":1,"chat":{"id":41,"type":"private"}}}`)
+		}
+
+		gock.New("https://api.telegram.org").
+			Post("/bot4101:Operator/sendMessage").
+			BodyString(`text=Hello`).
+			Reply(200).
+			BodyString(fmt.Sprintf(`{"ok":true,"result":{"message_id":%d,"date":1,"chat":{"id":41,"type":"private"}}}`, 10+i))
+
+		req, err := http.NewRequest("POST", "/webhook/", strings.NewReader(
+			`{"type":"message_sent","data":{"external_user_id":"41","external_chat_id":"41","channel_id":4101,"content":"Hello","type":"text",`+
+				`"user":{"first_name":"Ann"}}}`,
+		))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("Clientid", "123123")
+
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, fmt.Sprintf(`{"external_message_id":"%d"}`, 10+i), rr.Body.String())
+		assert.True(t, gock.IsDone(), "the customer is told about the operator once")
+	}
+
+	assert.Equal(t, "Ann", getChat(b.ID, 41).Operator)
+}
+
+func TestRouting_setSignatureHandler(t *testing.T) {
+	defer gock.Off()
+
+	b := createTestBot(t, Bot{Channel: 4201, Token: "4201:Signature", Name: "SignatureBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	rr := serveJSON(t, "/set-signature/", `{"token": "4201:Signature", "signaturePosition": "middle"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), getLocalizedMessage("incorrect_signature"))
+
+	rr = serveJSON(t, "/set-signature/", `{"token": "4201:Signature", "signaturePosition": "append", "signatureFormat": "{{.Nam"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = serveJSON(t, "/set-signature/", `{"token": "4201:Signature", "signaturePosition": "append", "signatureFormat": " — {{.Name}} "}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	sb := getBotByID(b.ID)
+	assert.Equal(t, SignatureAppend, sb.SignaturePosition)
+	assert.Equal(t, "— {{.Name}}", sb.SignatureFormat)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot4201:Signature/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":4201,"is_bot":true,"first_name":"Test","username":"SignatureBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot4201:Signature/sendMessage").
+		BodyString(`text=Hello%0A%0A%E2%80%94\+Ann`).
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"message_id":11,"date":1,"chat":{"id":42,"type":"private"}}}`)
+
+	req, err := http.NewRequest("POST", "/webhook/", strings.NewReader(
+		`{"type":"message_sent","data":{"external_user_id":"42","external_chat_id":"42","channel_id":4201,"content":"Hello","type":"text",`+
+			`"user":{"first_name":"Ann"}}}`,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Clientid", "123123")
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, gock.IsDone(), "the text is signed by the operator")
+}
+
+func TestRouting_setStaffNotificationsHandler(t *testing.T) {
+	defer gock.Off()
+
+	b := createTestBot(t, Bot{Channel: 4301, Token: "4301:Staff", Name: "StaffBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	for _, ok := range []bool{false, true} {
+		gock.New("https://api.telegram.org").
+			Post("/bot4301:Staff/getMe").
+			Reply(200).
+			BodyString(`{"ok":true,"result":{"id":4301,"is_bot":true,"first_name":"Test","username":"StaffBot"}}`)
+
+		if ok {
+			gock.New("https://api.telegram.org").
+				Post("/bot4301:Staff/getChat").
+				Reply(200).
+				BodyString(`{"ok":true,"result":{"id":-1004301,"type":"supergroup","title":"Staff"}}`)
+		} else {
+			gock.New("https://api.telegram.org").
+				Post("/bot4301:Staff/getChat").
+				Reply(400).
+				BodyString(`{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`)
+		}
+
+		rr := serveJSON(t, "/set-staff-notifications/", `{"token": "4301:Staff", "staffChatId": -1004301, "unansweredMinutes": 15}`)
+
+		if ok {
+			assert.Equal(t, http.StatusOK, rr.Code)
+		} else {
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			assert.Contains(t, rr.Body.String(), getLocalizedMessage("incorrect_staff_chat"))
+		}
+
+		assert.True(t, gock.IsDone())
+	}
+
+	sb := getBotByID(b.ID)
+	assert.Equal(t, int64(-1004301), sb.StaffChatID)
+	assert.Equal(t, 15, sb.UnansweredMinutes)
+	assert.NotNil(t, sb.StaffSince)
+
+	rr := serveJSON(t, "/set-staff-notifications/", `{"token": "4301:Staff", "unansweredMinutes": 20000}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestRouting_setQuietHoursHandler(t *testing.T) {
+	defer gock.Off()
+
+	b := createTestBot(t, Bot{Channel: 4401, Token: "4401:Quiet", Name: "QuietBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	rr := serveJSON(t, "/set-quiet-hours/", `{"token": "4401:Quiet", "quietFrom": "22:00"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), getLocalizedMessage("incorrect_quiet_hours"))
+
+	rr = serveJSON(t, "/set-quiet-hours/", `{"token": "4401:Quiet", "quietFrom": "25:00", "quietTo": "08:00"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	now := time.Now().UTC()
+	rr = serveJSON(t, "/set-quiet-hours/", fmt.Sprintf(
+		`{"token": "4401:Quiet", "quietFrom": "%s", "quietTo": "%s"}`,
+		now.Add(-time.Hour).Format("15:04"), now.Add(time.Hour).Format("15:04"),
+	))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot4401:Quiet/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":4401,"is_bot":true,"first_name":"Test","username":"QuietBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot4401:Quiet/sendMessage").
+		BodyString(`disable_notification=true`).
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"message_id":11,"date":1,"chat":{"id":44,"type":"private"}}}`)
+
+	req, err := http.NewRequest("POST", "/webhook/", strings.NewReader(
+		`{"type":"message_sent","data":{"external_user_id":"44","external_chat_id":"44","channel_id":4401,"content":"Hello","type":"text"}}`,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Clientid", "123123")
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, gock.IsDone(), "the message is sent silently during the quiet hours")
+}
+
+func TestRouting_setFallbacksHandler(t *testing.T) {
+	defer gock.Off()
+
+	b := createTestBot(t, Bot{Channel: 4501, Token: "4501:Fallback", Name: "FallbackBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	rr := serveJSON(t, "/set-fallbacks/", `{"token": "4501:Fallback", "editFallback": "notice"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), getLocalizedMessage("incorrect_fallback"))
+
+	rr = serveJSON(t, "/set-fallbacks/", `{"token": "4501:Fallback", "editFallback": "resend", "deleteFallback": "ignore"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	webhook := func(body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/webhook/", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("Clientid", "123123")
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	gock.New("https://api.telegram.org").
+		Post("/bot4501:Fallback/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":4501,"is_bot":true,"first_name":"Test","username":"FallbackBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot4501:Fallback/editMessageText").
+		Reply(400).
+		BodyString(`{"ok":false,"error_code":400,"description":"Bad Request: message can't be edited"}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot4501:Fallback/sendMessage").
+		BodyString(`text=Edited`).
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"message_id":12,"date":1,"chat":{"id":45,"type":"private"}}}`)
+
+	gock.New("https://test.retailcrm.pro").
+		Post("/api/transport/v1/messages").
+		BodyString(`edited text was sent as a new message`).
+		Reply(200).
+		BodyString(`{"message_id":1,"time":"2019-06-01T10:00:00Z"}`)
+
+	rr = webhook(`{"type":"message_updated","data":{"external_user_id":"45","external_chat_id":"45","external_message_id":"11",` +
+		`"channel_id":4501,"content":"Edited","type":"text"}}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, gock.IsDone(), "the edited text is resent")
+
+	gock.New("https://api.telegram.org").
+		Post("/bot4501:Fallback/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":4501,"is_bot":true,"first_name":"Test","username":"FallbackBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot4501:Fallback/deleteMessage").
+		Reply(400).
+		BodyString(`{"ok":false,"error_code":400,"description":"Bad Request: message can't be deleted"}`)
+
+	gock.New("https://test.retailcrm.pro").
+		Post("/api/transport/v1/messages").
+		BodyString(`customer can still see it`).
+		Reply(200).
+		BodyString(`{"message_id":2,"time":"2019-06-01T10:00:00Z"}`)
+
+	rr = webhook(`{"type":"message_deleted","data":{"external_user_id":"45","external_chat_id":"45","external_message_id":"11",` +
+		`"channel_id":4501,"type":"text"}}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, gock.IsDone(), "the operator is told the message was not deleted")
+}
+
+func TestRouting_telegramWebhookLanguage(t *testing.T) {
+	defer gock.Off()
+
+	b := createTestBot(t, Bot{Channel: 4601, Token: "4601:Language", Name: "LanguageBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot4601:Language/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":4601,"is_bot":true,"first_name":"Test","username":"LanguageBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot4601:Language/sendMessage").
+		BodyString(`lang%3Aes`).
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"message_id":2,"date":1,"chat":{"id":46,"type":"private"}}}`)
+
+	rr := serveJSON(t, "/telegram/4601:Language",
+		`{"update_id":1,"message":{"message_id":1,"from":{"id":46,"first_name":"John","language_code":"ru"},`+
+			`"chat":{"id":46,"type":"private"},"date":1,"text":"/language","entities":[{"type":"bot_command","offset":0,"length":9}]}}`,
+	)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, gock.IsDone(), "the language keyboard is sent and the command is not forwarded")
+
+	gock.New("https://api.telegram.org").
+		Post("/bot4601:Language/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":4601,"is_bot":true,"first_name":"Test","username":"LanguageBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot4601:Language/answerCallbackQuery").
+		Reply(200).
+		BodyString(`{"ok":true,"result":true}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot4601:Language/editMessageText").
+		BodyString(`espa%C3%B1ol`).
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"message_id":2,"date":1,"chat":{"id":46,"type":"private"}}}`)
+
+	rr = serveJSON(t, "/telegram/4601:Language",
+		`{"update_id":2,"callback_query":{"id":"cb1","from":{"id":46,"first_name":"John"},`+
+			`"message":{"message_id":2,"chat":{"id":46,"type":"private"},"date":1},"data":"lang:es"}}`,
+	)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "es", getChat(b.ID, 46).Lang)
+	assert.True(t, gock.IsDone())
+}
+
+func TestRouting_inlineSearch(t *testing.T) {
+	defer gock.Off()
+
+	b := createTestBot(t, Bot{Channel: 5001, Token: "5001:Inline", Name: "InlineBot", Lang: "en"})
+	defer orm.DB.Delete(Bot{}, "id = ?", b.ID)
+
+	credentialsCache.Lock()
+	delete(credentialsCache.entries, 1)
+	credentialsCache.Unlock()
+
+	productCache.Lock()
+	productCache.entries = map[string]productCacheEntry{}
+	productCache.Unlock()
+
+	currencyCache.Lock()
+	delete(currencyCache.entries, 1)
+	currencyCache.Unlock()
+
+	query := `{"update_id":%d,"inline_query":{"id":"iq%d","from":{"id":50,"first_name":"John"},"query":"case","offset":""}}`
+
+	rr := serveJSON(t, "/telegram/5001:Inline", fmt.Sprintf(query, 1, 1))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, gock.IsDone(), "the inline queries are ignored while the search is off")
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/credentials").
+		Reply(200).
+		BodyString(`{"success": true, "credentials": ["/api/store/products"]}`)
+
+	rr = serveJSON(t, "/set-inline-search/", `{"token": "5001:Inline", "inlineSearch": true}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, getBotByID(b.ID).InlineSearch)
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/v5/store/products").
+		MatchParam("filter[name]", "case").
+		Reply(200).
+		BodyString(`{"success":true,"products":[{"id":42,"name":"Phone case","minPrice":10,"maxPrice":10,"offers":[{"id":420,"name":"Red"}]}]}`)
+
+	gock.New("https://test.retailcrm.ru").
+		Get("/api/v5/settings").
+		Reply(200).
+		BodyString(`{"success":true,"settings":{"default_currency":{"value":"RUB"}}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot5001:Inline/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":5001,"is_bot":true,"first_name":"Test","username":"InlineBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot5001:Inline/answerInlineQuery").
+		BodyString(`Phone\+case`).
+		Reply(200).
+		BodyString(`{"ok":true,"result":true}`)
+
+	rr = serveJSON(t, "/telegram/5001:Inline", fmt.Sprintf(query, 2, 2))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, gock.IsDone(), "the inline query is answered from the catalog")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// isValid reports whether the rule pattern can be matched
+func (r *AutoReplyRule) isValid() bool {
+	switch r.Type {
+	case RuleTypeKeyword:
+		return len(getKeywords(r.Pattern)) > 0
+	case RuleTypeRegex:
+		_, err := regexp.Compile(r.Pattern)
+		return err == nil
+	case RuleTypeCommand:
+		return regBotCommand.MatchString(r.Pattern)
+	}
+
+	return false
+}
+
+func (r *AutoReplyRule) match(text, command string) bool {
+	switch r.Type {
+	case RuleTypeKeyword:
+		text = strings.ToLower(text)
+		for _, v := range getKeywords(r.Pattern) {
+			if strings.Contains(text, v) {
+				return true
+			}
+		}
+	case RuleTypeRegex:
+		if re, err := regexp.Compile(r.Pattern); err == nil {
+			return re.MatchString(text)
+		}
+	case RuleTypeCommand:
+		return command != "" && command == r.Pattern
+	}
+
+	return false
+}
+
+func getKeywords(pattern string) []string {
+	var keywords []string
+	for _, v := range strings.Split(pattern, ",") {
+		if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+			keywords = append(keywords, v)
+		}
+	}
+
+	return keywords
+}
+
+// matchRule returns the first active rule in the customer language or for any language matching the message
+func matchRule(rules []AutoReplyRule, text, command, lang string) *AutoReplyRule {
+	for i := range rules {
+		if !rules[i].Active || rules[i].Lang != "" && rules[i].Lang != lang {
+			continue
+		}
+
+		if rules[i].match(text, command) {
+			return &rules[i]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRules_isValid(t *testing.T) {
+	assert.True(t, (&AutoReplyRule{Type: RuleTypeKeyword, Pattern: "delivery, shipping"}).isValid())
+	assert.False(t, (&AutoReplyRule{Type: RuleTypeKeyword, Pattern: " , "}).isValid())
+	assert.True(t, (&AutoReplyRule{Type: RuleTypeRegex, Pattern: `(?i)^when.*open`}).isValid())
+	assert.False(t, (&AutoReplyRule{Type: RuleTypeRegex, Pattern: `(`}).isValid())
+	assert.True(t, (&AutoReplyRule{Type: RuleTypeCommand, Pattern: "hours"}).isValid())
+	assert.False(t, (&AutoReplyRule{Type: RuleTypeCommand, Pattern: "/hours"}).isValid())
+	assert.False(t, (&AutoReplyRule{Type: "unknown", Pattern: "hours"}).isValid())
+}
+
+func TestRules_matchRule(t *testing.T) {
+	rules := []AutoReplyRule{
+		{ID: 1, Type: RuleTypeKeyword, Pattern: "delivery, shipping", Lang: "en", Active: true},
+		{ID: 2, Type: RuleTypeKeyword, Pattern: "доставка", Lang: "ru", Active: true},
+		{ID: 3, Type: RuleTypeRegex, Pattern: `(?i)opening hours`, Active: false},
+		{ID: 4, Type: RuleTypeRegex, Pattern: `(?i)hours`, Active: true},
+		{ID: 5, Type: RuleTypeCommand, Pattern: "schedule", Active: true},
+	}
+
+	assert.Equal(t, 1, matchRule(rules, "How long is Shipping?", "", "en").ID)
+	assert.Nil(t, matchRule(rules, "How long is Shipping?", "", "ru"))
+	assert.Equal(t, 2, matchRule(rules, "Сколько идёт ДОСТАВКА?", "", "ru").ID)
+	assert.Equal(t, 4, matchRule(rules, "What are your opening hours?", "", "es").ID)
+	assert.Equal(t, 5, matchRule(rules, "/schedule", "schedule", "en").ID)
+	assert.Nil(t, matchRule(rules, "hello", "", "en"))
+}
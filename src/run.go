@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/subtle"
 	"net/http"
 	"os"
 	"os/signal"
@@ -9,6 +10,7 @@ import (
 	"github.com/getsentry/raven-go"
 	"github.com/gin-contrib/multitemplate"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	_ "github.com/golang-migrate/migrate/database/postgres"
 	_ "github.com/golang-migrate/migrate/source/file"
 )
@@ -48,6 +50,9 @@ func (x *RunCommand) Execute(args []string) error {
 
 func start() {
 	routing := setup()
+	go broadcastWorker()
+	go staffWorker()
+	go quietWorker()
 	routing.Run(config.HTTPServer.Listen)
 }
 
@@ -92,6 +97,44 @@ func setup() *gin.Engine {
 	r.POST("/add-bot/", checkBotForRequest(), addBotHandler)
 	r.POST("/delete-bot/", checkBotForRequest(), deleteBotHandler)
 	r.POST("/set-lang/", checkBotForRequest(), setLangBotHandler)
+	r.POST("/set-group-policy/", checkBotForRequest(), setGroupPolicyBotHandler)
+	r.POST("/add-payload-source/", checkBotTokenForRequest(), addPayloadSourceHandler)
+	r.POST("/delete-payload-source/", checkBotTokenForRequest(), deletePayloadSourceHandler)
+	r.POST("/add-deep-link/", checkBotTokenForRequest(), addDeepLinkHandler)
+	r.POST("/delete-deep-link/", checkBotTokenForRequest(), deleteDeepLinkHandler)
+	r.GET("/deep-link/:uid/:id/:file", deepLinkQRCodeHandler)
+	r.POST("/add-command/", checkBotTokenForRequest(), addCommandHandler)
+	r.POST("/delete-command/", checkBotTokenForRequest(), deleteCommandHandler)
+	r.POST("/set-schedule/", checkBotTokenForRequest(), setScheduleHandler)
+	r.POST("/add-business-hours/", checkBotTokenForRequest(), addBusinessHoursHandler)
+	r.POST("/delete-business-hours/", checkBotTokenForRequest(), deleteBusinessHoursHandler)
+	r.POST("/add-holiday/", checkBotTokenForRequest(), addHolidayHandler)
+	r.POST("/delete-holiday/", checkBotTokenForRequest(), deleteHolidayHandler)
+	r.POST("/add-away-message/", checkBotTokenForRequest(), addAwayMessageHandler)
+	r.POST("/delete-away-message/", checkBotTokenForRequest(), deleteAwayMessageHandler)
+	r.POST("/save-rule/", checkBotTokenForRequest(), saveRuleHandler)
+	r.POST("/delete-rule/", checkBotTokenForRequest(), deleteRuleHandler)
+	r.POST("/set-rule-active/", checkBotTokenForRequest(), setRuleActiveHandler)
+	r.POST("/move-rule/", checkBotTokenForRequest(), moveRuleHandler)
+	r.POST("/set-spam-filter/", checkBotTokenForRequest(), setSpamFilterHandler)
+	r.POST("/set-request-phone/", checkBotTokenForRequest(), setRequestPhoneHandler)
+	r.POST("/set-orders/", checkBotTokenForRequest(), setOrdersHandler)
+	r.POST("/set-payments/", checkBotTokenForRequest(), setPaymentsHandler)
+	r.POST("/set-csat/", checkBotTokenForRequest(), setCsatHandler)
+	r.POST("/set-inline-search/", checkBotTokenForRequest(), setInlineSearchHandler)
+	r.POST("/set-operator-notifications/", checkBotTokenForRequest(), setOperatorNotificationsHandler)
+	r.POST("/set-signature/", checkBotTokenForRequest(), setSignatureHandler)
+	r.POST("/set-staff-notifications/", checkBotTokenForRequest(), setStaffNotificationsHandler)
+	r.POST("/set-quiet-hours/", checkBotTokenForRequest(), setQuietHoursHandler)
+	r.POST("/set-fallbacks/", checkBotTokenForRequest(), setFallbacksHandler)
+	r.POST("/add-blocked-user/", checkBotTokenForRequest(), addBlockedUserHandler)
+	r.POST("/delete-blocked-user/", checkBotTokenForRequest(), deleteBlockedUserHandler)
+	r.POST("/add-campaign/", checkBotTokenForRequest(), addCampaignHandler)
+	r.POST("/start-campaign/", checkBotTokenForRequest(), startCampaignHandler)
+	r.POST("/pause-campaign/", checkBotTokenForRequest(), pauseCampaignHandler)
+	r.POST("/delete-campaign/", checkBotTokenForRequest(), deleteCampaignHandler)
+	r.POST("/generate-notify-token/", generateNotifyTokenHandler)
+	r.POST("/api/notify", checkConnectionForNotify(), notifyHandler)
 	r.POST("/actions/activity", activityHandler)
 	r.POST("/telegram/:token", checkBotForWebhook(), telegramWebhookHandler)
 	r.POST("/webhook/", checkConnectionForWebhook(), mgWebhookHandler)
@@ -135,6 +178,37 @@ func checkBotForRequest() gin.HandlerFunc {
 	}
 }
 
+func checkBotTokenForRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Token string `json:"token"`
+		}
+
+		if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+			c.AbortWithStatusJSON(BadRequest("wrong_data"))
+			return
+		}
+
+		if req.Token == "" {
+			c.AbortWithStatusJSON(BadRequest("no_bot_token"))
+			return
+		}
+
+		b, err := getBotByToken(req.Token)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		if b.ID == 0 {
+			c.AbortWithStatusJSON(BadRequest("wrong_data"))
+			return
+		}
+
+		c.Set("bot", *b)
+	}
+}
+
 func checkConnectionForRequest() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var conn Connection
@@ -167,6 +241,25 @@ func checkConnectionForWebhook() gin.HandlerFunc {
 	}
 }
 
+func checkConnectionForNotify() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientID := c.GetHeader("Clientid")
+		token := c.GetHeader("X-Notify-Token")
+		if clientID == "" || token == "" {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		conn := getConnection(clientID)
+		if !conn.Active || conn.NotifyToken == "" || subtle.ConstantTimeCompare([]byte(conn.NotifyToken), []byte(token)) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		c.Set("connection", *conn)
+	}
+}
+
 func checkBotForWebhook() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := c.Param("token")
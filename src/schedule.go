@@ -0,0 +1,72 @@
+package main
+
+import (
+	"time"
+)
+
+// DefaultAwayInterval is the number of hours between the away messages sent to the same chat
+const DefaultAwayInterval = 12
+
+// getLocation returns the time zone of the bot schedule, UTC is used when it is not set
+func getLocation(timezone string) *time.Location {
+	if timezone == "" {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		logger.Error(timezone, err.Error())
+		return time.UTC
+	}
+
+	return loc
+}
+
+// isOutOfHours reports whether the moment is out of the business hours, t must be in the bot time zone
+func isOutOfHours(hours []BusinessHours, holidays []Holiday, t time.Time) bool {
+	if len(hours) == 0 {
+		return false
+	}
+
+	date := t.Format("2006-01-02")
+	for _, v := range holidays {
+		if v.Date.Format("2006-01-02") == date {
+			return true
+		}
+	}
+
+	now := t.Format("15:04")
+	for _, v := range hours {
+		if time.Weekday(v.Weekday) == t.Weekday() && v.Opens <= now && now < v.Closes {
+			return false
+		}
+	}
+
+	return true
+}
+
+// getAwayMessage returns the away message in the customer language or in the bot language
+func getAwayMessage(messages []AwayMessage, lang, botLang string) *AwayMessage {
+	var fallback *AwayMessage
+
+	for i := range messages {
+		if messages[i].Lang == lang {
+			return &messages[i]
+		}
+
+		if messages[i].Lang == botLang {
+			fallback = &messages[i]
+		}
+	}
+
+	return fallback
+}
+
+// isAwayMessageDue reports whether the chat has not got the away message during the interval in hours
+func isAwayMessageDue(chat *Chat, interval int, now time.Time) bool {
+	if interval <= 0 {
+		interval = DefaultAwayInterval
+	}
+
+	return chat.AwaySentAt == nil || now.Sub(*chat.AwaySentAt) >= time.Duration(interval)*time.Hour
+}
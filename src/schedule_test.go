@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedule_isOutOfHours(t *testing.T) {
+	hours := []BusinessHours{
+		{Weekday: int(time.Monday), Opens: "09:00", Closes: "13:00"},
+		{Weekday: int(time.Monday), Opens: "14:00", Closes: "18:00"},
+		{Weekday: int(time.Tuesday), Opens: "00:00", Closes: "24:00"},
+	}
+	holidays := []Holiday{{Date: time.Date(2019, 1, 7, 0, 0, 0, 0, time.UTC)}}
+	loc := getLocation("Europe/Moscow")
+
+	assert.False(t, isOutOfHours(hours, nil, time.Date(2019, 1, 14, 9, 0, 0, 0, loc)))
+	assert.True(t, isOutOfHours(hours, nil, time.Date(2019, 1, 14, 13, 30, 0, 0, loc)))
+	assert.True(t, isOutOfHours(hours, nil, time.Date(2019, 1, 14, 18, 0, 0, 0, loc)))
+	assert.False(t, isOutOfHours(hours, nil, time.Date(2019, 1, 15, 23, 59, 0, 0, loc)))
+	assert.True(t, isOutOfHours(hours, nil, time.Date(2019, 1, 16, 12, 0, 0, 0, loc)))
+	assert.True(t, isOutOfHours(hours, holidays, time.Date(2019, 1, 7, 10, 0, 0, 0, loc)))
+	assert.False(t, isOutOfHours(nil, holidays, time.Date(2019, 1, 7, 10, 0, 0, 0, loc)))
+}
+
+func TestSchedule_getAwayMessage(t *testing.T) {
+	messages := []AwayMessage{{ID: 1, Lang: "en"}, {ID: 2, Lang: "ru"}}
+
+	assert.Equal(t, 2, getAwayMessage(messages, "ru", "en").ID)
+	assert.Equal(t, 1, getAwayMessage(messages, "de", "en").ID)
+	assert.Nil(t, getAwayMessage(messages, "de", "es"))
+}
+
+func TestSchedule_isAwayMessageDue(t *testing.T) {
+	now := time.Now()
+	sentAt := now.Add(-2 * time.Hour)
+
+	assert.True(t, isAwayMessageDue(&Chat{}, 0, now))
+	assert.False(t, isAwayMessageDue(&Chat{AwaySentAt: &sentAt}, 0, now))
+	assert.True(t, isAwayMessageDue(&Chat{AwaySentAt: &sentAt}, 2, now))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	// DefaultFloodMute is the number of minutes the flooding customer is muted for
+	DefaultFloodMute = 60
+
+	// SpamReasonBot is set for messages sent by other bots
+	SpamReasonBot = "bot"
+	// SpamReasonBlocked is set for messages of the users from the block list
+	SpamReasonBlocked = "blocked"
+	// SpamReasonFlood is set for messages of the muted customers
+	SpamReasonFlood = "flood"
+)
+
+// isMuted reports whether the customer is muted at the moment
+func isMuted(counter *FloodCounter, now time.Time) bool {
+	return counter.MutedUntil != nil && now.Before(*counter.MutedUntil)
+}
+
+// getMuteDuration returns the time the customer exceeding the flood limit is muted for
+func getMuteDuration(minutes int) time.Duration {
+	if minutes <= 0 {
+		minutes = DefaultFloodMute
+	}
+
+	return time.Duration(minutes) * time.Minute
+}
+
+// getSpamReason checks the message sender and the flood limit of the customer, an empty reason means the message can be forwarded
+func getSpamReason(b *Bot, message *tgbotapi.Message, count bool) (string, error) {
+	if message.From == nil || isServiceMessage(message) {
+		return "", nil
+	}
+
+	if message.From.IsBot {
+		return SpamReasonBot, nil
+	}
+
+	if bu := getBlockedUser(b.ID, message.From.ID); bu.ID != 0 {
+		return SpamReasonBlocked, incrementBlockedUserHits(bu.ID)
+	}
+
+	if b.FloodLimit <= 0 {
+		return "", nil
+	}
+
+	now := time.Now()
+	if isMuted(getFloodCounter(b.ID, message.From.ID), now) {
+		return SpamReasonFlood, nil
+	}
+
+	if !count {
+		return "", nil
+	}
+
+	n, err := countUserMessage(b.ID, message.From.ID, now)
+	if err != nil || n <= b.FloodLimit {
+		return "", err
+	}
+
+	return SpamReasonFlood, setUserMutedUntil(b.ID, message.From.ID, now.Add(getMuteDuration(b.FloodMute)))
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpam_isMuted(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Minute)
+
+	assert.False(t, isMuted(&FloodCounter{}, now))
+	assert.False(t, isMuted(&FloodCounter{MutedUntil: &past}, now))
+	assert.True(t, isMuted(&FloodCounter{MutedUntil: &future}, now))
+}
+
+func TestSpam_getSpamReason_service(t *testing.T) {
+	message := &tgbotapi.Message{From: &tgbotapi.User{ID: 1}, MigrateToChatID: -1001}
+	reason, err := getSpamReason(&Bot{FloodLimit: 1}, message, true)
+
+	assert.NoError(t, err)
+	assert.Empty(t, reason)
+}
+
+func TestSpam_getMuteDuration(t *testing.T) {
+	assert.Equal(t, time.Hour, getMuteDuration(0))
+	assert.Equal(t, 5*time.Minute, getMuteDuration(5))
+}
+
+func TestSpam_getSpamReason_bot(t *testing.T) {
+	reason, err := getSpamReason(&Bot{}, &tgbotapi.Message{From: &tgbotapi.User{ID: 1, IsBot: true}}, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, SpamReasonBot, reason)
+}
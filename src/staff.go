@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+const (
+	// StaffIdle is the pause between the checks for unanswered chats
+	StaffIdle = time.Minute
+	// StaffLease is the time the chat claimed for the alert by the instance is not alerted about by the others
+	StaffLease = 5 * time.Minute
+	// MaxStaffTextLength is the length of the customer message quoted in the staff alert
+	MaxStaffTextLength = 200
+	// StaffDialogIdle is the silence in the chat after which the customer message starts a new dialog
+	StaffDialogIdle = 24 * time.Hour
+)
+
+// isNewDialog reports whether the customer message sent at the time starts a new dialog in the chat
+func isNewDialog(chat *Chat, at time.Time) bool {
+	last := chat.LastInboundAt
+	if last == nil {
+		return true
+	}
+
+	if chat.LastReplyAt != nil && chat.LastReplyAt.After(*last) {
+		last = chat.LastReplyAt
+	}
+
+	return at.Sub(*last) >= StaffDialogIdle
+}
+
+// getDialogsURL returns the link to the CRM customer of the chat, or to all the chats if the customer is not linked.
+// The dialog cannot be linked, neither the MG webhooks nor the responses to the sent messages have the dialog ID
+func getDialogsURL(conn *Connection, chat *Chat) string {
+	if chat.CustomerID != 0 {
+		return fmt.Sprintf("%s/customers/%d", strings.TrimRight(conn.APIURL, "/"), chat.CustomerID)
+	}
+
+	return fmt.Sprintf("%s/chats", strings.TrimRight(conn.APIURL, "/"))
+}
+
+// getStaffText returns the shortened customer message for the staff alert
+func getStaffText(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if r := []rune(text); len(r) > MaxStaffTextLength {
+		text = string(r[:MaxStaffTextLength-1]) + "…"
+	}
+
+	return text
+}
+
+// getStaffAlert returns the alert about the chat posted to the staff group
+func getStaffAlert(l *i18n.Localizer, messageID string, b *Bot, conn *Connection, chat *Chat) string {
+	return localize(l, messageID, map[string]interface{}{
+		"Bot":     "@" + strings.TrimPrefix(b.Name, "@"),
+		"Name":    chat.Name,
+		"Text":    chat.LastMessage,
+		"Link":    getStaffLink(l, conn, chat),
+		"Minutes": b.UnansweredMinutes,
+	})
+}
+
+// getStaffLink returns the labeled link of the staff alert, the label tells where the link leads as the dialog cannot be linked
+func getStaffLink(l *i18n.Localizer, conn *Connection, chat *Chat) string {
+	messageID := "staff_chats_link"
+	if chat.CustomerID != 0 {
+		messageID = "staff_customer_link"
+	}
+
+	return localize(l, messageID, map[string]interface{}{"URL": getDialogsURL(conn, chat)})
+}
+
+// sendStaffAlert posts the alert about the chat to the staff group of the bot
+func sendStaffAlert(messageID string, b *Bot, chat *Chat) error {
+	conn := getConnectionById(b.ConnectionID)
+
+	bot, err := tgbotapi.NewBotAPI(b.Token)
+	if err != nil {
+		return err
+	}
+
+	bot.Debug = config.Debug
+
+	msg := tgbotapi.NewMessage(b.StaffChatID, getStaffAlert(newLocalizer(b.Lang), messageID, b, conn, chat))
+	msg.DisableWebPagePreview = true
+
+	_, err = bot.Send(msg)
+
+	return err
+}
+
+func staffWorker() {
+	for {
+		chats := claimUnansweredChats(time.Now(), StaffLease)
+		for i := range chats {
+			b := getBotByID(chats[i].BotID)
+			if err := sendStaffAlert("staff_unanswered", b, &chats[i]); err != nil {
+				logger.Errorf("staffWorker bot: %d, chat: %d, err: %s", b.ID, chats[i].ExternalID, err.Error())
+				continue
+			}
+
+			if err := setChatAlertSentAt(chats[i].BotID, chats[i].ExternalID); err != nil {
+				logger.Error(chats[i].BotID, chats[i].ExternalID, err)
+			}
+		}
+
+		time.Sleep(StaffIdle)
+	}
+}
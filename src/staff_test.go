@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaff_getStaffText(t *testing.T) {
+	assert.Equal(t, "Where is my order?", getStaffText("  Where is\n\nmy   order? "))
+
+	text := getStaffText(strings.Repeat("я", MaxStaffTextLength+10))
+	assert.Equal(t, MaxStaffTextLength, len([]rune(text)))
+	assert.True(t, strings.HasSuffix(text, "…"))
+}
+
+func TestStaff_getStaffAlert(t *testing.T) {
+	l := newLocalizer("en")
+	b := &Bot{Name: "TestBot", UnansweredMinutes: 15}
+	conn := &Connection{APIURL: "https://test.retailcrm.pro/"}
+	chat := &Chat{Name: "John Doe (@john)", LastMessage: "Where is my order?"}
+
+	assert.Equal(
+		t,
+		"New dialog in @TestBot\nJohn Doe (@john): Where is my order?\nAll chats (MG does not report the dialog): https://test.retailcrm.pro/chats",
+		getStaffAlert(l, "staff_new_dialog", b, conn, chat),
+	)
+	assert.Equal(
+		t,
+		"No answer for 15 min. in @TestBot\nJohn Doe (@john): Where is my order?\nAll chats (MG does not report the dialog): https://test.retailcrm.pro/chats",
+		getStaffAlert(l, "staff_unanswered", b, conn, chat),
+	)
+
+	chat.CustomerID = 25
+	assert.Equal(
+		t,
+		"No answer for 15 min. in @TestBot\nJohn Doe (@john): Where is my order?\nCustomer card (MG does not report the dialog): https://test.retailcrm.pro/customers/25",
+		getStaffAlert(l, "staff_unanswered", b, conn, chat),
+	)
+}
+
+func TestStaff_isNewDialog(t *testing.T) {
+	now := time.Now()
+	inbound := now.Add(-time.Hour)
+	reply := now.Add(-StaffDialogIdle)
+
+	assert.True(t, isNewDialog(&Chat{}, now))
+	assert.False(t, isNewDialog(&Chat{LastInboundAt: &inbound}, now))
+	assert.False(t, isNewDialog(&Chat{LastInboundAt: &inbound, DialogClosed: true}, now))
+
+	inbound = now.Add(-2 * StaffDialogIdle)
+	assert.True(t, isNewDialog(&Chat{LastInboundAt: &inbound}, now))
+	assert.True(t, isNewDialog(&Chat{LastInboundAt: &inbound, LastReplyAt: &reply}, now))
+
+	reply = now.Add(-time.Minute)
+	assert.False(t, isNewDialog(&Chat{LastInboundAt: &inbound, LastReplyAt: &reply}, now))
+}
@@ -1,6 +1,91 @@
 package main
 
-import "github.com/go-telegram-bot-api/telegram-bot-api"
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/retailcrm/api-client-go/v5"
+)
+
+var unreachableChatErrors = []string{
+	"bot was blocked by the user",
+	"user is deactivated",
+}
+
+// transientErrors are the Telegram API errors of the flood control and the server failures, the request may be retried
+var transientErrors = []string{
+	"Too Many Requests",
+	"Internal Server Error",
+	"Bad Gateway",
+	"Service Unavailable",
+	"Gateway Timeout",
+}
+
+const (
+	// MessageNotEditableError is returned by Telegram when the message is too old to be edited
+	MessageNotEditableError = "message can't be edited"
+	// MessageNotDeletableError is returned by Telegram when the message is too old to be deleted
+	MessageNotDeletableError = "message can't be deleted"
+)
+
+// Update extends tgbotapi.Update with the update types unknown to the library
+type Update struct {
+	tgbotapi.Update
+	MyChatMember      *ChatMemberUpdated        `json:"my_chat_member"`
+	CaptionEntities   *[]tgbotapi.MessageEntity `json:"-"`
+	ForwardSenderName string                    `json:"-"`
+}
+
+// UnmarshalJSON decodes the update and the message fields unknown to the library
+func (u *Update) UnmarshalJSON(data []byte) error {
+	type update Update
+	if err := json.Unmarshal(data, (*update)(u)); err != nil {
+		return err
+	}
+
+	type fields struct {
+		CaptionEntities   *[]tgbotapi.MessageEntity `json:"caption_entities"`
+		ForwardSenderName string                    `json:"forward_sender_name"`
+	}
+
+	var message struct {
+		Message       fields `json:"message"`
+		EditedMessage fields `json:"edited_message"`
+	}
+	if err := json.Unmarshal(data, &message); err != nil {
+		return err
+	}
+
+	res := message.Message
+	if u.EditedMessage != nil {
+		res = message.EditedMessage
+	}
+
+	u.CaptionEntities = res.CaptionEntities
+	u.ForwardSenderName = res.ForwardSenderName
+
+	return nil
+}
+
+// ChatMemberUpdated represents changes in the status of a chat member
+type ChatMemberUpdated struct {
+	Chat          tgbotapi.Chat       `json:"chat"`
+	From          tgbotapi.User       `json:"from"`
+	Date          int                 `json:"date"`
+	OldChatMember tgbotapi.ChatMember `json:"old_chat_member"`
+	NewChatMember tgbotapi.ChatMember `json:"new_chat_member"`
+}
+
+// MenuCommand is an entry of the bot command menu
+type MenuCommand struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+}
 
 //GetFileIDAndURL function
 func GetFileIDAndURL(token string, userID int) (fileID, fileURL string, err error) {
@@ -57,3 +142,402 @@ func getMessageID(data *tgbotapi.Message) string {
 		return "undefined"
 	}
 }
+
+// isTelegramError reports whether the Telegram error has the description
+func isTelegramError(err error, description string) bool {
+	return err != nil && strings.Contains(err.Error(), description)
+}
+
+func isChatUnreachableError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	for _, v := range unreachableChatErrors {
+		if strings.Contains(err.Error(), v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isTransientError reports whether the failed request may succeed later, the network errors are transient while the
+// Telegram API errors are not unless it is the flood control or the server failure
+func isTransientError(err error) bool {
+	e, ok := err.(tgbotapi.Error)
+	if !ok {
+		return err != nil
+	}
+
+	if e.RetryAfter > 0 {
+		return true
+	}
+
+	for _, v := range transientErrors {
+		if strings.Contains(e.Message, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getMGChatID returns the chat ID under which the Telegram chat is known to MG
+func getMGChatID(botID int, chatID int64) int64 {
+	if chat := getChatByMigratedToID(botID, chatID); chat.ID != 0 {
+		return chat.ExternalID
+	}
+
+	return chatID
+}
+
+// getTelegramChatID returns the current Telegram chat ID for the chat known to MG
+func getTelegramChatID(botID int, externalID int64) int64 {
+	if chat := getChat(botID, externalID); chat.MigratedToID != 0 {
+		return chat.MigratedToID
+	}
+
+	return externalID
+}
+
+func getUserName(user *tgbotapi.User) string {
+	if user == nil {
+		return ""
+	}
+
+	name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+	if user.UserName != "" {
+		if name == "" {
+			return "@" + user.UserName
+		}
+
+		name += " (@" + user.UserName + ")"
+	}
+
+	return name
+}
+
+func isServiceMessage(data *tgbotapi.Message) bool {
+	return data.NewChatMembers != nil ||
+		data.LeftChatMember != nil ||
+		data.NewChatTitle != "" ||
+		data.NewChatPhoto != nil ||
+		data.DeleteChatPhoto ||
+		data.GroupChatCreated ||
+		data.SuperGroupChatCreated ||
+		data.ChannelChatCreated ||
+		data.MigrateToChatID != 0 ||
+		data.MigrateFromChatID != 0 ||
+		data.PinnedMessage != nil
+}
+
+// getForwardOrigin returns the author of the forwarded message, senderName is set when the author hides the account
+func getForwardOrigin(message *tgbotapi.Message, senderName string) string {
+	switch {
+	case message.ForwardFromChat != nil:
+		origin := message.ForwardFromChat.Title
+		if message.ForwardFromChat.UserName != "" {
+			origin += " (@" + message.ForwardFromChat.UserName + ")"
+
+			if message.ForwardFromMessageID != 0 {
+				origin += fmt.Sprintf(" https://t.me/%s/%d", message.ForwardFromChat.UserName, message.ForwardFromMessageID)
+			}
+		}
+
+		return strings.TrimSpace(origin)
+	case message.ForwardFrom != nil:
+		return getUserName(message.ForwardFrom)
+	default:
+		return senderName
+	}
+}
+
+// sendMediaGroup sends the album and returns its messages, tgbotapi expects a single message in the response
+func sendMediaGroup(bot *tgbotapi.BotAPI, config tgbotapi.MediaGroupConfig) ([]tgbotapi.Message, error) {
+	media, err := json.Marshal(config.InputMedia)
+	if err != nil {
+		return nil, err
+	}
+
+	v := url.Values{}
+	v.Add("chat_id", strconv.FormatInt(config.ChatID, 10))
+	v.Add("media", string(media))
+	v.Add("disable_notification", strconv.FormatBool(config.DisableNotification))
+
+	if config.ReplyToMessageID != 0 {
+		v.Add("reply_to_message_id", strconv.Itoa(config.ReplyToMessageID))
+	}
+
+	resp, err := bot.MakeRequest("sendMediaGroup", v)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []tgbotapi.Message
+	err = json.Unmarshal(resp.Result, &messages)
+
+	return messages, err
+}
+
+// getStartPayload returns the deep-link payload passed with the /start command, the text typed manually
+// outside of the deep-link alphabet is ignored
+func getStartPayload(data *tgbotapi.Message) string {
+	if !data.Chat.IsPrivate() || data.Command() != "start" {
+		return ""
+	}
+
+	payload := strings.TrimSpace(data.CommandArguments())
+	if !regStartPayload.MatchString(payload) {
+		return ""
+	}
+
+	return payload
+}
+
+// getSharedPhone returns the phone number of the contact the user shared about themselves
+func getSharedPhone(data *tgbotapi.Message) string {
+	if data.Contact == nil || data.From == nil || data.Contact.UserID != data.From.ID {
+		return ""
+	}
+
+	phone := strings.TrimSpace(data.Contact.PhoneNumber)
+	if phone != "" && !strings.HasPrefix(phone, "+") {
+		phone = "+" + phone
+	}
+
+	return phone
+}
+
+// PhoneRequestCommand is sent by the operator to ask the customer for the phone
+const PhoneRequestCommand = "/phone"
+
+// getPhoneRequest returns the prompt with the keyboard button sharing the customer phone
+func getPhoneRequest(cid int64) tgbotapi.MessageConfig {
+	keyboard := tgbotapi.NewReplyKeyboard(
+		tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButtonContact(getLocalizedMessage("share_phone"))),
+	)
+	keyboard.OneTimeKeyboard = true
+	keyboard.ResizeKeyboard = true
+
+	msg := tgbotapi.NewMessage(cid, getLocalizedMessage("phone_request"))
+	msg.ReplyMarkup = keyboard
+
+	return msg
+}
+
+// getPhoneReceived returns the reply removing the phone request keyboard
+func getPhoneReceived(cid int64) tgbotapi.MessageConfig {
+	msg := tgbotapi.NewMessage(cid, getLocalizedMessage("phone_received"))
+	msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(false)
+
+	return msg
+}
+
+// LanguageCallbackPrefix prefixes the language code in the data of the language buttons
+const LanguageCallbackPrefix = "lang:"
+
+// getLanguageKeyboard returns the inline keyboard with the languages of the transport
+func getLanguageKeyboard() tgbotapi.InlineKeyboardMarkup {
+	var row []tgbotapi.InlineKeyboardButton
+	for _, v := range languages {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(languageNames[v], LanguageCallbackPrefix+v))
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(row)
+}
+
+// getLanguageCallback returns the language chosen with the language button
+func getLanguageCallback(data string) string {
+	if !strings.HasPrefix(data, LanguageCallbackPrefix) {
+		return ""
+	}
+
+	if lang := strings.TrimPrefix(data, LanguageCallbackPrefix); isLanguage(lang) {
+		return lang
+	}
+
+	return ""
+}
+
+// getCustomerLanguage returns the language chosen by the customer or the language of the Telegram app
+func getCustomerLanguage(chat *Chat, user *tgbotapi.User) string {
+	if chat != nil && chat.Lang != "" {
+		return chat.Lang
+	}
+
+	return getUserLanguage(user)
+}
+
+const (
+	// OrderCallbackPrefix prefixes the offer ID in the data of the order button
+	OrderCallbackPrefix = "order:"
+	// MaxOrderOffers is the number of the offer buttons attached to the product
+	MaxOrderOffers = 10
+)
+
+// getOrderKeyboard returns the inline keyboard ordering the product offers, the offers are named when there are several of them
+func getOrderKeyboard(offers []v5.Offer) tgbotapi.InlineKeyboardMarkup {
+	if len(offers) > MaxOrderOffers {
+		offers = offers[:MaxOrderOffers]
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, v := range offers {
+		text := getLocalizedMessage("order_button")
+		if len(offers) > 1 && v.Name != "" {
+			text = fmt.Sprintf("%s: %s", text, v.Name)
+		}
+
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(text, fmt.Sprintf("%s%d", OrderCallbackPrefix, v.ID)),
+		))
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// getOrderCallbackOffer returns the offer ID passed with the order button
+func getOrderCallbackOffer(data string) int {
+	if !strings.HasPrefix(data, OrderCallbackPrefix) {
+		return 0
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(data, OrderCallbackPrefix))
+	if err != nil || id <= 0 {
+		return 0
+	}
+
+	return id
+}
+
+// getDeepLinkURL returns the link which starts the bot with the payload
+func getDeepLinkURL(botName, payload string) string {
+	return fmt.Sprintf("https://t.me/%s?start=%s", botName, payload)
+}
+
+// getCommand returns the command addressed to the bot, commands for other bots in groups are skipped
+func getCommand(data *tgbotapi.Message, botName string) string {
+	command := data.CommandWithAt()
+	if i := strings.Index(command, "@"); i != -1 {
+		if !strings.EqualFold(command[i+1:], botName) {
+			return ""
+		}
+
+		command = command[:i]
+	}
+
+	return strings.ToLower(command)
+}
+
+// getUserLanguage returns the two-letter language code of the user
+func getUserLanguage(user *tgbotapi.User) string {
+	if user == nil || len(user.LanguageCode) < 2 {
+		return ""
+	}
+
+	return user.LanguageCode[:2]
+}
+
+// getBotCommand returns the command reply in the customer language or in the bot language
+func getBotCommand(commands []BotCommand, name, lang, botLang string) *BotCommand {
+	var fallback *BotCommand
+
+	for i := range commands {
+		if commands[i].Name != name {
+			continue
+		}
+
+		if commands[i].Lang == lang {
+			return &commands[i]
+		}
+
+		if commands[i].Lang == botLang {
+			fallback = &commands[i]
+		}
+	}
+
+	return fallback
+}
+
+func getMenuCommands(commands []BotCommand, lang string) []MenuCommand {
+	menu := []MenuCommand{}
+	for _, v := range commands {
+		if v.Lang == lang {
+			menu = append(menu, MenuCommand{Command: v.Name, Description: v.Description})
+		}
+	}
+
+	return menu
+}
+
+// setMyCommands registers the command menu for every language, the bot language is used by default
+func setMyCommands(bot *tgbotapi.BotAPI, commands []BotCommand, botLang string) error {
+	for _, lang := range append([]string{""}, languages...) {
+		menuLang := lang
+		if menuLang == "" {
+			menuLang = botLang
+		}
+
+		menu, err := json.Marshal(getMenuCommands(commands, menuLang))
+		if err != nil {
+			return err
+		}
+
+		params := url.Values{"commands": {string(menu)}}
+		if lang != "" {
+			params.Set("language_code", lang)
+		}
+
+		if _, err = bot.MakeRequest("setMyCommands", params); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getEntitiesText expands the links hidden behind the text, the entity offsets are in UTF-16 code units
+func getEntitiesText(text string, entities *[]tgbotapi.MessageEntity) string {
+	if entities == nil || len(*entities) == 0 {
+		return text
+	}
+
+	var (
+		units = utf16.Encode([]rune(text))
+		res   []uint16
+		pos   int
+	)
+
+	for _, e := range *entities {
+		var link string
+		switch e.Type {
+		case "text_link":
+			link = e.URL
+		case "text_mention":
+			if e.User != nil {
+				link = fmt.Sprintf("tg://user?id=%d", e.User.ID)
+			}
+		}
+
+		end := e.Offset + e.Length
+		if link == "" || e.Offset < pos || end > len(units) {
+			continue
+		}
+
+		res = append(res, units[pos:end]...)
+		res = append(res, utf16.Encode([]rune(" ("+link+")"))...)
+		pos = end
+	}
+
+	return string(utf16.Decode(append(res, units[pos:]...)))
+}
+
+// getEditedMessage returns the edited text marked as edited and quoting the original message
+func getEditedMessage(cid int64, uid int, text string) tgbotapi.MessageConfig {
+	m := tgbotapi.NewMessage(cid, fmt.Sprintf("%s\n\n_%s_", replaceMarkdownSymbols(text), getLocalizedMessage("message_edited")))
+	m.ReplyToMessageID = uid
+	m.ParseMode = "Markdown"
+
+	return m
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/h2non/gock"
+	"github.com/retailcrm/api-client-go/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTelegram_isChatUnreachableError(t *testing.T) {
+	assert.True(t, isChatUnreachableError(tgbotapi.Error{Message: "Forbidden: bot was blocked by the user"}))
+	assert.True(t, isChatUnreachableError(tgbotapi.Error{Message: "Forbidden: user is deactivated"}))
+	assert.False(t, isChatUnreachableError(errors.New("Bad Request: message text is empty")))
+	assert.False(t, isChatUnreachableError(nil))
+}
+
+func TestTelegram_isTransientError(t *testing.T) {
+	assert.True(t, isTransientError(errors.New("dial tcp: i/o timeout")))
+	assert.True(t, isTransientError(tgbotapi.Error{Message: "Too Many Requests: retry after 5"}))
+	assert.True(t, isTransientError(tgbotapi.Error{Message: "flood", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 5}}))
+	assert.True(t, isTransientError(tgbotapi.Error{Message: "Internal Server Error"}))
+	assert.False(t, isTransientError(tgbotapi.Error{Message: "Bad Request: chat not found"}))
+	assert.False(t, isTransientError(tgbotapi.Error{Message: "Forbidden: bot was blocked by the user"}))
+	assert.False(t, isTransientError(nil))
+}
+
+func TestTelegram_getUserName(t *testing.T) {
+	assert.Equal(t, "John Doe (@jdoe)", getUserName(&tgbotapi.User{FirstName: "John", LastName: "Doe", UserName: "jdoe"}))
+	assert.Equal(t, "John", getUserName(&tgbotapi.User{FirstName: "John"}))
+	assert.Equal(t, "@jdoe", getUserName(&tgbotapi.User{UserName: "jdoe"}))
+	assert.Equal(t, "", getUserName(nil))
+}
+
+func TestTelegram_getCommand(t *testing.T) {
+	message := func(text string, length int) *tgbotapi.Message {
+		return &tgbotapi.Message{
+			Text:     text,
+			Entities: &[]tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: length}},
+		}
+	}
+
+	assert.Equal(t, "start", getCommand(message("/start promo", 6), "TestBot"))
+	assert.Equal(t, "help", getCommand(message("/Help@testbot", 13), "TestBot"))
+	assert.Equal(t, "", getCommand(message("/help@OtherBot", 14), "TestBot"))
+	assert.Equal(t, "", getCommand(&tgbotapi.Message{Text: "hello"}, "TestBot"))
+}
+
+func TestTelegram_getStartPayload(t *testing.T) {
+	message := func(text string) *tgbotapi.Message {
+		return &tgbotapi.Message{
+			Text:     text,
+			Chat:     &tgbotapi.Chat{ID: 1, Type: "private"},
+			Entities: &[]tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: 6}},
+		}
+	}
+
+	assert.Equal(t, "fb_ads-summer", getStartPayload(message("/start fb_ads-summer")))
+	assert.Equal(t, "", getStartPayload(message("/start привет")))
+	assert.Equal(t, "", getStartPayload(message("/start "+strings.Repeat("a", 65))))
+}
+
+func TestTelegram_getBotCommand(t *testing.T) {
+	commands := []BotCommand{
+		{ID: 1, Name: "help", Lang: "en"},
+		{ID: 2, Name: "help", Lang: "ru"},
+		{ID: 3, Name: "start", Lang: "es"},
+	}
+
+	assert.Equal(t, 2, getBotCommand(commands, "help", "ru", "en").ID)
+	assert.Equal(t, 1, getBotCommand(commands, "help", "de", "en").ID)
+	assert.Nil(t, getBotCommand(commands, "start", "ru", "en"))
+	assert.Nil(t, getBotCommand(commands, "stop", "en", "en"))
+}
+
+func TestTelegram_getMenuCommands(t *testing.T) {
+	commands := []BotCommand{
+		{Name: "help", Lang: "en", Description: "Help"},
+		{Name: "help", Lang: "ru", Description: "Помощь"},
+	}
+
+	assert.Equal(t, []MenuCommand{{Command: "help", Description: "Help"}}, getMenuCommands(commands, "en"))
+	assert.Equal(t, []MenuCommand{}, getMenuCommands(commands, "es"))
+}
+
+func TestTelegram_getSharedPhone(t *testing.T) {
+	user := &tgbotapi.User{ID: 1}
+
+	assert.Equal(t, "+79990000000", getSharedPhone(&tgbotapi.Message{
+		From:    user,
+		Contact: &tgbotapi.Contact{PhoneNumber: "79990000000", UserID: 1},
+	}))
+	assert.Equal(t, "", getSharedPhone(&tgbotapi.Message{
+		From:    user,
+		Contact: &tgbotapi.Contact{PhoneNumber: "+79990000001", UserID: 2},
+	}))
+	assert.Equal(t, "", getSharedPhone(&tgbotapi.Message{From: user, Text: "hello"}))
+}
+
+func TestTelegram_getOrderCallbackOffer(t *testing.T) {
+	assert.Equal(t, 42, getOrderCallbackOffer("order:42"))
+	assert.Equal(t, 0, getOrderCallbackOffer("order:abc"))
+	assert.Equal(t, 0, getOrderCallbackOffer("order:-1"))
+	assert.Equal(t, 0, getOrderCallbackOffer("rate:5"))
+}
+
+func TestTelegram_getOrderKeyboard(t *testing.T) {
+	setLocale("en")
+
+	keyboard := getOrderKeyboard([]v5.Offer{{ID: 420, Name: "Red"}})
+	require.Len(t, keyboard.InlineKeyboard, 1)
+	assert.Equal(t, "Order", keyboard.InlineKeyboard[0][0].Text)
+	assert.Equal(t, "order:420", *keyboard.InlineKeyboard[0][0].CallbackData)
+
+	keyboard = getOrderKeyboard([]v5.Offer{{ID: 420, Name: "Red"}, {ID: 421, Name: "Blue"}})
+	require.Len(t, keyboard.InlineKeyboard, 2)
+	assert.Equal(t, "Order: Blue", keyboard.InlineKeyboard[1][0].Text)
+	assert.Equal(t, "order:421", *keyboard.InlineKeyboard[1][0].CallbackData)
+}
+
+func TestTelegram_isTelegramError(t *testing.T) {
+	err := tgbotapi.Error{Message: "Bad Request: message can't be deleted"}
+
+	assert.True(t, isTelegramError(err, MessageNotDeletableError))
+	assert.False(t, isTelegramError(err, MessageNotEditableError))
+	assert.False(t, isTelegramError(nil, MessageNotDeletableError))
+}
+
+func TestTelegram_getEditedMessage(t *testing.T) {
+	setLocale("en")
+
+	m := getEditedMessage(1, 10, "New *price*")
+	assert.Equal(t, "New \\*price\\*\n\n_(edited)_", m.Text)
+	assert.Equal(t, 10, m.ReplyToMessageID)
+	assert.Equal(t, "Markdown", m.ParseMode)
+}
+
+func TestTelegram_getLanguageCallback(t *testing.T) {
+	assert.Equal(t, "ru", getLanguageCallback("lang:ru"))
+	assert.Equal(t, "", getLanguageCallback("lang:de"))
+	assert.Equal(t, "", getLanguageCallback("rate:5"))
+
+	keyboard := getLanguageKeyboard()
+	require.Len(t, keyboard.InlineKeyboard, 1)
+	assert.Len(t, keyboard.InlineKeyboard[0], len(languages))
+	assert.Equal(t, "lang:en", *keyboard.InlineKeyboard[0][0].CallbackData)
+}
+
+func TestTelegram_getCustomerLanguage(t *testing.T) {
+	user := &tgbotapi.User{ID: 1, LanguageCode: "en-US"}
+
+	assert.Equal(t, "en", getCustomerLanguage(nil, user))
+	assert.Equal(t, "en", getCustomerLanguage(&Chat{}, user))
+	assert.Equal(t, "es", getCustomerLanguage(&Chat{Lang: "es"}, user))
+}
+
+func TestTelegram_getEntitiesText(t *testing.T) {
+	assert.Equal(t, "hello", getEntitiesText("hello", nil))
+
+	entities := &[]tgbotapi.MessageEntity{
+		{Type: "bold", Offset: 0, Length: 5},
+		{Type: "text_link", Offset: 9, Length: 10, URL: "https://example.com"},
+		{Type: "text_mention", Offset: 24, Length: 4, User: &tgbotapi.User{ID: 42}},
+	}
+	assert.Equal(
+		t,
+		"Hello 👋 click here (https://example.com) ask John (tg://user?id=42)!",
+		getEntitiesText("Hello 👋 click here ask John!", entities),
+	)
+}
+
+func TestTelegram_Update_UnmarshalJSON(t *testing.T) {
+	var update Update
+	err := json.Unmarshal([]byte(`{
+		"update_id": 1,
+		"message": {
+			"message_id": 2,
+			"chat": {"id": 3, "type": "private"},
+			"caption": "see docs",
+			"caption_entities": [{"type": "text_link", "offset": 4, "length": 4, "url": "https://example.com/docs"}]
+		}
+	}`), &update)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, update.UpdateID)
+	assert.Equal(t, 2, update.Message.MessageID)
+	assert.Equal(t, "see docs (https://example.com/docs)", getEntitiesText(update.Message.Caption, update.CaptionEntities))
+
+	update = Update{}
+	err = json.Unmarshal([]byte(`{
+		"update_id": 4,
+		"edited_message": {
+			"message_id": 5,
+			"chat": {"id": -6, "type": "group"},
+			"caption": "see docs",
+			"caption_entities": [{"type": "text_link", "offset": 4, "length": 4, "url": "https://example.com/docs"}],
+			"forward_sender_name": "Hidden Sender"
+		}
+	}`), &update)
+	require.NoError(t, err)
+
+	assert.Equal(t, 5, update.EditedMessage.MessageID)
+	assert.Equal(t, "see docs (https://example.com/docs)", getEntitiesText(update.EditedMessage.Caption, update.CaptionEntities))
+	assert.Equal(t, "Hidden Sender", update.ForwardSenderName)
+}
+
+func TestTelegram_getForwardOrigin(t *testing.T) {
+	assert.Equal(t, "", getForwardOrigin(&tgbotapi.Message{}, ""))
+	assert.Equal(t, "Hidden Sender", getForwardOrigin(&tgbotapi.Message{}, "Hidden Sender"))
+	assert.Equal(t, "John Doe (@john)", getForwardOrigin(&tgbotapi.Message{
+		ForwardFrom: &tgbotapi.User{FirstName: "John", LastName: "Doe", UserName: "john"},
+	}, ""))
+	assert.Equal(t, "Shop (@shop) https://t.me/shop/15", getForwardOrigin(&tgbotapi.Message{
+		ForwardFromChat:      &tgbotapi.Chat{Title: "Shop", UserName: "shop"},
+		ForwardFromMessageID: 15,
+	}, ""))
+	assert.Equal(t, "Private channel", getForwardOrigin(&tgbotapi.Message{
+		ForwardFromChat: &tgbotapi.Chat{Title: "Private channel"},
+	}, ""))
+}
+
+func TestTelegram_sendMediaGroup(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("https://api.telegram.org").
+		Post("/bot123123:Qwerty/getMe").
+		Reply(200).
+		BodyString(`{"ok":true,"result":{"id":123,"is_bot":true,"first_name":"Test","username":"TestBot"}}`)
+
+	gock.New("https://api.telegram.org").
+		Post("/bot123123:Qwerty/sendMediaGroup").
+		BodyString(`disable_notification=true`).
+		Reply(200).
+		BodyString(`{"ok":true,"result":[{"message_id":5,"date":0,"chat":{"id":1,"type":"private"}},{"message_id":6,"date":0,"chat":{"id":1,"type":"private"}}]}`)
+
+	bot, err := tgbotapi.NewBotAPI("123123:Qwerty")
+	require.NoError(t, err)
+
+	album := tgbotapi.NewMediaGroup(1, []interface{}{
+		tgbotapi.NewInputMediaPhoto("https://example.com/1.jpg"),
+		tgbotapi.NewInputMediaPhoto("https://example.com/2.jpg"),
+	})
+
+	messages, err := sendMediaGroup(bot, silence(album).(tgbotapi.MediaGroupConfig))
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, 5, messages[0].MessageID)
+	assert.Equal(t, 6, messages[1].MessageID)
+	assert.True(t, gock.IsDone())
+}
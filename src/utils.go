@@ -24,6 +24,34 @@ var (
 		"/api/integration-modules/{code}",
 		"/api/integration-modules/{code}/edit",
 	}
+	// credentialsSources are checked when the bot stores the deep-link sources on the CRM customers
+	credentialsSources = []string{
+		"/api/customers/{externalId}/edit",
+		"/api/customers/create",
+	}
+	// credentialsCustomers are checked when the bot links the customers to the CRM
+	credentialsCustomers = []string{
+		"/api/customers",
+		"/api/customers/{externalId}",
+		"/api/customers/{externalId}/edit",
+		"/api/customers/create",
+	}
+	// credentialsOrders are checked when the bot attaches the order button to the products
+	credentialsOrders = []string{
+		"/api/customers/{externalId}",
+		"/api/customers/create",
+		"/api/store/products",
+		"/api/orders/create",
+	}
+	// credentialsInline are checked when the bot searches the catalog in inline mode
+	credentialsInline = []string{
+		"/api/store/products",
+	}
+	// credentialsPayments are checked when the bot sends the orders as invoices
+	credentialsPayments = []string{
+		"/api/orders",
+		"/api/orders/payments/create",
+	}
 	markdownSymbols = []string{"*", "_", "`", "["}
 )
 
@@ -35,6 +63,11 @@ func GenerateToken() string {
 }
 
 func getAPIClient(url, key string) (*v5.Client, error, int) {
+	return getAPIClientWithCredentials(url, key, credentialsTransport)
+}
+
+// getAPIClientWithCredentials returns the CRM client if the API key has the required credentials
+func getAPIClientWithCredentials(url, key string, required []string) (*v5.Client, error, int) {
 	client := v5.New(url, key)
 	client.Debug = config.Debug
 
@@ -50,7 +83,7 @@ func getAPIClient(url, key string) (*v5.Client, error, int) {
 		return nil, errors.New(getLocalizedMessage("incorrect_url_key")), http.StatusBadRequest
 	}
 
-	if res := checkCredentials(cr.Credentials); len(res) != 0 {
+	if res := checkCredentials(cr.Credentials, required); len(res) != 0 {
 		logger.Error(url, status, res)
 		return nil,
 			errors.New(
@@ -67,20 +100,22 @@ func getAPIClient(url, key string) (*v5.Client, error, int) {
 	return client, nil, 0
 }
 
-func checkCredentials(credential []string) []string {
-	rc := make([]string, len(credentialsTransport))
-	copy(rc, credentialsTransport)
-
-	for _, vc := range credential {
-		for kn, vn := range rc {
-			if vn == vc {
-				if len(rc) == 1 {
-					rc = rc[:0]
-					break
-				}
-				rc = append(rc[:kn], rc[kn+1:]...)
+// checkCredentials returns the required credentials missing from the API key
+func checkCredentials(credential []string, required []string) []string {
+	var rc []string
+
+	for _, vr := range required {
+		found := false
+		for _, vc := range credential {
+			if vc == vr {
+				found = true
+				break
 			}
 		}
+
+		if !found {
+			rc = append(rc, vr)
+		}
 	}
 
 	return rc
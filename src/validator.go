@@ -8,11 +8,19 @@ import (
 	"gopkg.in/go-playground/validator.v8"
 )
 
-var regCommandName = regexp.MustCompile(`^https://?[\da-z.-]+\.(retailcrm\.(ru|pro|es)|ecomlogic\.com|simlachat\.(com|ru))/?$`)
+var (
+	regCommandName  = regexp.MustCompile(`^https://?[\da-z.-]+\.(retailcrm\.(ru|pro|es)|ecomlogic\.com|simlachat\.(com|ru))/?$`)
+	regStartPayload = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+	regBotCommand   = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+	regTime         = regexp.MustCompile(`^(([01]\d|2[0-3]):[0-5]\d|24:00)$`)
+)
 
 func setValidation() {
 	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
 		v.RegisterValidation("validatecrmurl", validateCrmURL)
+		v.RegisterValidation("validatepayload", validatePayload)
+		v.RegisterValidation("validatecommand", validateCommand)
+		v.RegisterValidation("validatetime", validateTime)
 	}
 }
 
@@ -22,3 +30,24 @@ func validateCrmURL(
 ) bool {
 	return regCommandName.Match([]byte(field.Interface().(string)))
 }
+
+func validatePayload(
+	v *validator.Validate, topStruct reflect.Value, currentStructOrField reflect.Value,
+	field reflect.Value, fieldType reflect.Type, fieldKind reflect.Kind, param string,
+) bool {
+	return regStartPayload.Match([]byte(field.Interface().(string)))
+}
+
+func validateCommand(
+	v *validator.Validate, topStruct reflect.Value, currentStructOrField reflect.Value,
+	field reflect.Value, fieldType reflect.Type, fieldKind reflect.Kind, param string,
+) bool {
+	return regBotCommand.Match([]byte(field.Interface().(string)))
+}
+
+func validateTime(
+	v *validator.Validate, topStruct reflect.Value, currentStructOrField reflect.Value,
+	field reflect.Value, fieldType reflect.Type, fieldKind reflect.Kind, param string,
+) bool {
+	return regTime.Match([]byte(field.Interface().(string)))
+}
@@ -1,4 +1,4 @@
-$(document).on("change", "select", function(e) {
+$(document).on("change", "select.select-lang", function(e) {
     send(
         "/set-lang/",
         {
@@ -11,6 +11,19 @@ $(document).on("change", "select", function(e) {
     )
 });
 
+$(document).on("change", "select.select-group-policy", function(e) {
+    send(
+        "/set-group-policy/",
+        {
+            token: $(this).attr("data-token"),
+            groupPolicy: $(this).val()
+        },
+        function () {
+            return 0;
+        }
+    )
+});
+
 $('#save-crm').on("submit", function(e) {
     e.preventDefault();
     let formData = formDataToObj($(this).serializeArray());
@@ -47,6 +60,29 @@ $("#save").on("submit", function(e) {
     )
 });
 
+$("#notify-token").on("submit", function(e) {
+    e.preventDefault();
+    let form = $(this);
+    let formData = settingsFormToObj(form);
+    disableForm(form);
+    send(
+        form.attr('action'),
+        formData,
+        function (data) {
+            $("#notify_token").val(data.token);
+            form.find("input[name=apiKey]").val("");
+            form.find("input[name=rotate]").prop("checked", false);
+            M.toast({
+                html: data.message,
+                displayLength: 1000,
+                completeCallback: function(){
+                    enableForm();
+                }
+            });
+        }
+    )
+});
+
 $("#add-bot").on("submit", function(e) {
     e.preventDefault();
     disableForm($(this));
@@ -56,19 +92,127 @@ $("#add-bot").on("submit", function(e) {
             connectionId: parseInt($(this).find('input[name=connectionId]').val()),
             token: $(this).find('input[name=token]').val(),
         },
+        function () {
+            reloadBotsTab();
+        }
+    )
+});
+
+$(document).on("submit", ".bot-settings-form", function(e) {
+    e.preventDefault();
+    let formData = settingsFormToObj($(this));
+    disableForm($(this));
+    send(
+        $(this).attr('action'),
+        formData,
         function (data) {
-            let bots = $("#bots");
-            if (bots.hasClass("hide")) {
-                bots.removeClass("hide")
-            }
-            $("#bots tbody").append(getBotTemplate(data));
-            $("#token").val("");
-            $('select').formSelect();
-            enableForm();
+            reloadBotsTab(data.message);
+        }
+    )
+});
+
+$(document).on("click", ".bot-settings-edit", function(e) {
+    e.preventDefault();
+    let form = $(this).parents(".collapsible-body").find($(this).attr("data-form"));
+    $.each(this.attributes, function () {
+        if (this.name.indexOf("data-field-") !== 0) {
+            return;
+        }
+
+        let input = form.find(":input[name=" + this.name.substring(11) + "]");
+        if (input.attr("type") === "checkbox") {
+            input.prop("checked", this.value === "true");
+        } else {
+            input.val(this.value);
+        }
+    });
+    form.find("select").formSelect();
+    M.textareaAutoResize(form.find("textarea"));
+});
+
+$(document).on("click", ".bot-settings-move", function(e) {
+    e.preventDefault();
+    send(
+        $(this).attr("data-action"),
+        {
+            token: $(this).attr("data-token"),
+            id: parseInt($(this).attr("data-id")),
+            up: $(this).attr("data-up") === "true"
+        },
+        function () {
+            reloadBotsTab();
+        }
+    )
+});
+
+$(document).on("change", ".bot-settings-toggle", function(e) {
+    send(
+        $(this).attr("data-action"),
+        {
+            token: $(this).attr("data-token"),
+            id: parseInt($(this).attr("data-id")),
+            active: $(this).is(":checked")
+        },
+        function () {
+            return 0;
+        }
+    )
+});
+
+$(document).on("click", ".bot-settings-action", function(e) {
+    e.preventDefault();
+    $(this).addClass('disabled');
+    send(
+        $(this).attr("data-action"),
+        {
+            token: $(this).attr("data-token"),
+            id: parseInt($(this).attr("data-id"))
+        },
+        function (data) {
+            reloadBotsTab(data.message);
         }
     )
 });
 
+$(document).on("click", ".bot-settings-delete", function(e) {
+    e.preventDefault();
+    var but = $(this);
+    var confirmText = JSON.parse(sessionStorage.getItem("confirmText"));
+    but.addClass('disabled');
+
+    $.confirm({
+        title: false,
+        content: confirmText["text"],
+        useBootstrap: false,
+        boxWidth: '30%',
+        type: 'blue',
+        backgroundDismiss: false,
+        backgroundDismissAnimation: 'shake',
+        buttons: {
+            confirm: {
+                text: confirmText["confirm"],
+                action: function () {
+                    send(but.attr("data-action"),
+                        {
+                            token: but.attr("data-token"),
+                            id: parseInt(but.attr("data-id")),
+                        },
+                        function () {
+                            but.parents("tr").remove();
+                        }
+                    )
+                },
+            },
+            cancel: {
+                text: confirmText["cancel"],
+                action: function () {
+                    but.removeClass('disabled');
+                },
+            },
+        }
+    });
+});
+
 $(document).on("click", ".delete-bot", function(e) {
     e.preventDefault();
     var but = $(this);
@@ -131,30 +275,6 @@ function send(url, data, callback) {
     });
 }
 
-function getBotTemplate(data) {
-    tmpl =
-        `<tr>
-            <td>${data.name}</td>
-            <td>${data.token}</td>
-            <td>
-                <div class="col s3 sel-lang">
-                    <select data-token="${data.token}">
-                        <option value="en" selected>en</option>
-                        <option value="ru">ru</option>
-                        <option value="es">es</option>
-                    </select>
-                </div>
-            </td>
-            <td>
-                <button class="delete-bot btn btn-small waves-effect waves-light light-blue darken-1" type="submit" name="action"
-                        data-token="${data.token}">
-                    <i class="material-icons">delete</i>
-                </button>
-            </td>
-        </tr>`;
-    return tmpl;
-}
-
 function formDataToObj(formArray) {
     let obj = {};
     for (let i = 0; i < formArray.length; i++){
@@ -163,10 +283,38 @@ function formDataToObj(formArray) {
     return obj;
 }
 
+function settingsFormToObj(form) {
+    let obj = {};
+    form.find(":input[name]").each(function () {
+        let input = $(this);
+        switch (input.attr("data-type") || input.attr("type")) {
+            case "checkbox":
+                obj[input.attr("name")] = input.is(":checked");
+                break;
+            case "number":
+                obj[input.attr("name")] = input.val() === "" ? 0 : Number(input.val());
+                break;
+            default:
+                obj[input.attr("name")] = input.val();
+        }
+    });
+    return obj;
+}
+
+function reloadBotsTab(message) {
+    if (message) {
+        sessionStorage.setItem("createdMsg", message);
+    }
+
+    document.location.hash = "tab2";
+    document.location.reload();
+}
+
 $( document ).ready(function() {
     $('select').formSelect();
     M.Tabs.init(document.getElementById("tab"));
-    if ($("table tbody").children().length === 0) {
+    M.Collapsible.init(document.querySelectorAll(".collapsible"));
+    if ($("#bots tbody").children().length === 0) {
         $("#bots").addClass("hide");
     }
 